@@ -2,8 +2,7 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,26 +12,62 @@ import (
 	"github.com/gin-gonic/gin"
 	"any2md/internal/adapters/handlers"
 	"any2md/internal/infrastructure/config"
+	"any2md/internal/infrastructure/metrics"
 	"any2md/internal/infrastructure/middleware"
 	"any2md/internal/usecases"
+	"any2md/pkg/recipe"
+	"any2md/pkg/tokenizer"
 )
 
 func main() {
 	cfg := config.Load()
 	
+	logger, err := middleware.NewLogger(os.Stdout, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		slog.Error("Invalid logging configuration", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+	
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
 	
+	// the logger and the metrics see the request ID, the 500 of recovered
+	// panics and the rejections of the rate limiter, which runs per route
+	serviceMetrics := metrics.New("/api/v1/convert")
+	router.Use(middleware.RequestID())
+	router.Use(middleware.RequestLogger(logger))
+	router.Use(serviceMetrics.Middleware())
 	router.Use(gin.Recovery())
-	router.Use(middleware.RequestLogger())
 	router.Use(middleware.CORS())
-	router.Use(middleware.RateLimiter(cfg.RateLimit.MaxRequests, cfg.RateLimit.Window))
 	
 	converterUseCase := usecases.NewConverterUseCase()
+	// recipe options may name a tokenizer of the directory
+	if cfg.Tokenizers.Dir != "" {
+		names, err := tokenizer.LoadDir(cfg.Tokenizers.Dir)
+		if err != nil {
+			logger.Error("Failed to load tokenizers", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("Loaded tokenizers", "tokenizers", names)
+	}
+	if cfg.Recipes.Dir != "" {
+		recipes, err := recipe.LoadDir(cfg.Recipes.Dir)
+		if err != nil {
+			logger.Error("Failed to load recipes", "error", err)
+			os.Exit(1)
+		}
+		converterUseCase.UseRecipes(recipes)
+		logger.Info("Loaded recipes", "recipes", recipes.Names())
+	}
 	httpHandler := handlers.NewHTTPHandler(converterUseCase)
 	
-	router.GET("/health", httpHandler.Health)
-	router.POST("/api/v1/convert", httpHandler.Convert)
+	// scrapes of /metrics don't count toward the rate limit
+	router.GET("/metrics", serviceMetrics.Handler())
+	limited := router.Group("", middleware.RateLimiter(cfg.RateLimit.MaxRequests, cfg.RateLimit.Window))
+	limited.GET("/health", httpHandler.Health)
+	limited.POST("/api/v1/convert", httpHandler.Convert)
+	limited.GET("/api/v1/capabilities", httpHandler.Capabilities)
 	
 	srv := &http.Server{
 		Addr:         ":" + cfg.Server.Port,
@@ -43,9 +78,10 @@ func main() {
 	}
 	
 	go func() {
-		fmt.Printf("Server starting on port %s...\n", cfg.Server.Port)
+		logger.Info("Server starting", "port", cfg.Server.Port)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+			logger.Error("Failed to start server", "error", err)
+			os.Exit(1)
 		}
 	}()
 	
@@ -53,14 +89,15 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	
-	fmt.Println("Shutting down server...")
+	logger.Info("Shutting down server")
 	
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+		logger.Error("Server forced to shutdown", "error", err)
+		os.Exit(1)
 	}
 	
-	fmt.Println("Server exited")
+	logger.Info("Server exited")
 }
\ No newline at end of file
@@ -8,6 +8,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"any2md/internal/domain"
+	"any2md/internal/infrastructure/middleware"
 	"any2md/internal/usecases"
 	"any2md/pkg/errors"
 )
@@ -43,15 +44,24 @@ func (h *HTTPHandler) Convert(c *gin.Context) {
 		request.Type = "html"
 	}
 	
-	// Manual validation for required fields
-	if request.Type == "" {
-		h.handleError(c, errors.NewValidationError("type field is required (html or pdf)"))
+	// Find the converter for the type, or detect it
+	capability, err := h.converterUseCase.Resolve(&request)
+	if err != nil {
+		h.handleError(c, err)
 		return
 	}
+	c.Set(middleware.InputTypeKey, request.Type)
+	c.Set(middleware.InputSizeKey, len(request.GetContent()))
 	
-	// Validate type
-	if request.Type != "html" && request.Type != "pdf" {
-		h.handleError(c, errors.NewValidationError("type must be 'html' or 'pdf'"))
+	// Validate content encoding
+	if request.Encoding != "" && request.Encoding != "base64" {
+		h.handleError(c, errors.NewValidationError("encoding must be empty or 'base64'"))
+		return
+	}
+	
+	// Validate options
+	if err := request.Options.Validate(); err != nil {
+		h.handleError(c, err)
 		return
 	}
 	
@@ -63,11 +73,7 @@ func (h *HTTPHandler) Convert(c *gin.Context) {
 	}
 	
 	// Size limits based on type
-	maxSize := 10 * 1024 * 1024 // 10MB default
-	if request.Type == "pdf" {
-		maxSize = 50 * 1024 * 1024 // 50MB for PDFs
-	}
-	
+	maxSize := capability.MaxSize
 	if len(content) > maxSize {
 		h.handleError(c, errors.NewValidationError(fmt.Sprintf("%s content exceeds maximum size of %dMB", request.Type, maxSize/(1024*1024))))
 		return
@@ -79,9 +85,20 @@ func (h *HTTPHandler) Convert(c *gin.Context) {
 		return
 	}
 	
+	c.Set(middleware.InputSizeKey, response.Stats.InputLength)
+	c.Set(middleware.OutputSizeKey, response.Stats.OutputLength)
 	c.JSON(http.StatusOK, response)
 }
 
+// Capabilities lists the formats the service converts and the tokenizers
+// it counts tokens with.
+func (h *HTTPHandler) Capabilities(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"converters": h.converterUseCase.Capabilities(),
+		"tokenizers": h.converterUseCase.Tokenizers(),
+	})
+}
+
 func (h *HTTPHandler) Health(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status": "healthy",
@@ -90,9 +107,19 @@ func (h *HTTPHandler) Health(c *gin.Context) {
 	})
 }
 
+// handleError writes the error response. The request ID, when there is
+// one, is added to the details so that a failure can be found in the logs.
 func (h *HTTPHandler) handleError(c *gin.Context, err error) {
+	requestID := c.GetString(middleware.RequestIDKey)
 	switch e := err.(type) {
 	case *errors.ConversionError:
+		if requestID != "" {
+			if e.Details == nil {
+				e.Details = make(map[string]interface{})
+			}
+			e.Details["request_id"] = requestID
+		}
+		c.Set(middleware.ErrorCodeKey, e.Code)
 		statusCode := http.StatusInternalServerError
 		switch e.Code {
 		case "VALIDATION_ERROR":
@@ -109,11 +136,14 @@ func (h *HTTPHandler) handleError(c *gin.Context, err error) {
 			},
 		})
 	default:
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"code":    "INTERNAL_ERROR",
-				"message": "An unexpected error occurred",
-			},
-		})
+		c.Set(middleware.ErrorCodeKey, "INTERNAL_ERROR")
+		body := gin.H{
+			"code":    "INTERNAL_ERROR",
+			"message": "An unexpected error occurred",
+		}
+		if requestID != "" {
+			body["details"] = gin.H{"request_id": requestID}
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": body})
 	}
 }
\ No newline at end of file
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"any2md/internal/domain"
+	"any2md/internal/infrastructure/middleware"
 	"any2md/internal/usecases"
 )
 
@@ -40,8 +42,11 @@ func TestHTTPHandler_Convert(t *testing.T) {
 				}
 				
 				if stats, ok := resp["stats"].(map[string]interface{}); ok {
-					if inputLen, ok := stats["input_length"].(float64); !ok || inputLen == 0 {
-						t.Error("Invalid input_length in stats")
+					if inputLen, ok := stats["input_length"].(float64); !ok || inputLen != float64(len("<h1>Hello World</h1><p>This is a test.</p>")) {
+						t.Errorf("Expected the length of the HTML as input_length, got: %v", stats["input_length"])
+					}
+					if tokens, ok := stats["output_tokens"].(float64); !ok || tokens == 0 || stats["tokenizer"] != "cl100k_base" {
+						t.Errorf("Expected output_tokens counted with the default tokenizer, got: %v", stats)
 					}
 				} else {
 					t.Error("Response missing stats field")
@@ -83,6 +88,235 @@ func TestHTTPHandler_Convert(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Unknown option value",
+			request: domain.ConversionRequest{
+				HTML: "<h1>Title</h1>",
+				Options: domain.ConversionOptions{
+					LinkReferenceStyle: "footnote",
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			checkResponse: func(t *testing.T, resp map[string]interface{}) {
+				if errObj, ok := resp["error"].(map[string]interface{}); ok {
+					if msg, ok := errObj["message"].(string); !ok || !contains(msg, "link_reference_style") {
+						t.Errorf("Expected error about link_reference_style, got: %v", msg)
+					}
+				} else {
+					t.Error("Response missing error field")
+				}
+			},
+		},
+		{
+			name: "Unknown element override",
+			request: domain.ConversionRequest{
+				HTML: "<p><mark>Title</mark></p>",
+				Options: domain.ConversionOptions{
+					Elements: map[string]string{"marquee": "text"},
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Wrap width too narrow",
+			request: domain.ConversionRequest{
+				HTML:    "<p>Hello</p>",
+				Options: domain.ConversionOptions{WrapWidth: 5},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Base64 HTML in a legacy charset",
+			request: domain.ConversionRequest{
+				Type:     "html",
+				Content:  base64.StdEncoding.EncodeToString([]byte("<p>Caf\xe9 cr\xe8me</p>")),
+				Encoding: "base64",
+			},
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, resp map[string]interface{}) {
+				if markdown, _ := resp["markdown"].(string); markdown != "Café crème" {
+					t.Errorf("Expected transcoded markdown, got: %q", markdown)
+				}
+				if charset, _ := resp["charset"].(string); charset != "windows-1252" {
+					t.Errorf("Expected charset windows-1252, got: %q", charset)
+				}
+			},
+		},
+		{
+			name: "Type detected from the content",
+			request: domain.ConversionRequest{
+				Content: "<!DOCTYPE html><h1>Detected</h1>",
+			},
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, resp map[string]interface{}) {
+				if typ, _ := resp["type"].(string); typ != "html" {
+					t.Errorf("Expected type html, got: %q", typ)
+				}
+			},
+		},
+		{
+			name: "JSON document structure",
+			request: domain.ConversionRequest{
+				Type:    "html",
+				Content: "<h1>Guide</h1><p>Intro</p><h2>Setup</h2><table><tr><th>Key</th></tr><tr><td>a</td></tr></table>",
+				Options: domain.ConversionOptions{Output: "json"},
+			},
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, resp map[string]interface{}) {
+				structure, _ := json.Marshal(resp["document"])
+				if !contains(string(structure), `"id":"setup/1"`) || !contains(string(structure), `"rows":[["a"]]`) {
+					t.Errorf("Expected the setup section with its table rows, got: %s", structure)
+				}
+				if markdown, _ := resp["markdown"].(string); !contains(markdown, "# Guide") {
+					t.Errorf("Expected the Markdown as well, got: %q", markdown)
+				}
+			},
+		},
+		{
+			name: "Type given as a MIME type",
+			request: domain.ConversionRequest{
+				Type:    "text/html; charset=utf-8",
+				Content: "<p>Hello</p>",
+			},
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, resp map[string]interface{}) {
+				if typ, _ := resp["type"].(string); typ != "html" {
+					t.Errorf("Expected type html, got: %q", typ)
+				}
+			},
+		},
+		{
+			name: "Unknown type",
+			request: domain.ConversionRequest{
+				Type:    "docx",
+				Content: "UEsDBA==",
+			},
+			expectedStatus: http.StatusBadRequest,
+			checkResponse: func(t *testing.T, resp map[string]interface{}) {
+				if errObj, ok := resp["error"].(map[string]interface{}); ok {
+					if msg, _ := errObj["message"].(string); msg != "type must be one of: html, markdown, pdf" {
+						t.Errorf("Expected the supported types to be listed, got: %q", msg)
+					}
+				}
+			},
+		},
+		{
+			name: "Chunked output",
+			request: domain.ConversionRequest{
+				Type:    "html",
+				Content: "<h1>Guide</h1><h2>Install</h2><pre><code>make install</code></pre><h2>Use</h2><p>Run it.</p>",
+				Options: domain.ConversionOptions{Chunking: &domain.ChunkingOptions{MaxTokens: 100}},
+			},
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, resp map[string]interface{}) {
+				chunks, _ := resp["chunks"].([]interface{})
+				if len(chunks) != 2 {
+					t.Fatalf("Expected a chunk per section, got: %v", resp["chunks"])
+				}
+				last, _ := chunks[1].(map[string]interface{})
+				if breadcrumb, _ := json.Marshal(last["breadcrumb"]); string(breadcrumb) != `["Guide","Use"]` {
+					t.Errorf("Expected the breadcrumb of the Use section, got: %s", breadcrumb)
+				}
+			},
+		},
+		{
+			name: "Chunk overlap larger than the chunks",
+			request: domain.ConversionRequest{
+				Type:    "html",
+				Content: "<p>Hello</p>",
+				Options: domain.ConversionOptions{Chunking: &domain.ChunkingOptions{MaxChars: 100, Overlap: 100}},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Chunk overlap larger than the default chunks",
+			request: domain.ConversionRequest{
+				Type:    "html",
+				Content: "<p>Hello</p>",
+				Options: domain.ConversionOptions{Chunking: &domain.ChunkingOptions{Overlap: domain.DefaultChunkTokens}},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Negative chunk sizes",
+			request: domain.ConversionRequest{
+				Type:    "html",
+				Content: "<p>Hello</p>",
+				Options: domain.ConversionOptions{Chunking: &domain.ChunkingOptions{MaxTokens: -1, MaxChars: -1, Overlap: -1}},
+			},
+			expectedStatus: http.StatusBadRequest,
+			checkResponse: func(t *testing.T, resp map[string]interface{}) {
+				errObj, _ := resp["error"].(map[string]interface{})
+				details, _ := errObj["details"].(map[string]interface{})
+				if details["option"] != "chunking.max_tokens" {
+					t.Errorf("Expected the first invalid field to be reported, got: %v", errObj)
+				}
+			},
+		},
+		{
+			name: "Token count with a tokenizer",
+			request: domain.ConversionRequest{
+				Type:    "html",
+				Content: "<h1>Hello World</h1><p>This is a test.</p>",
+				Options: domain.ConversionOptions{Tokenizer: "cl100k_base"},
+			},
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, resp map[string]interface{}) {
+				stats, _ := resp["stats"].(map[string]interface{})
+				if tokens, ok := stats["output_tokens"].(float64); !ok || tokens == 0 || stats["tokenizer"] != "cl100k_base" {
+					t.Errorf("Expected output_tokens counted with cl100k_base, got: %v", stats)
+				}
+			},
+		},
+		{
+			name: "Unknown tokenizer",
+			request: domain.ConversionRequest{
+				Type:    "html",
+				Content: "<p>Hello</p>",
+				Options: domain.ConversionOptions{Tokenizer: "gpt-9"},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Plain text flavor",
+			request: domain.ConversionRequest{
+				Type:    "html",
+				Content: "<h1>Guide</h1><p>Read <strong>the</strong> <a href=\"https://go.dev\">docs</a>.</p>",
+				Options: domain.ConversionOptions{Flavor: "plain"},
+			},
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, resp map[string]interface{}) {
+				if markdown, _ := resp["markdown"].(string); markdown != "Guide\n\nRead the docs (https://go.dev)." {
+					t.Errorf("Expected plain text, got: %q", markdown)
+				}
+			},
+		},
+		{
+			name: "Markdown formatting",
+			request: domain.ConversionRequest{
+				Type:    "text/markdown",
+				Content: "Title\n=====\n\n* one\n* two",
+				Options: domain.ConversionOptions{BulletListMarker: "+"},
+			},
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, resp map[string]interface{}) {
+				if markdown, _ := resp["markdown"].(string); markdown != "# Title\n\n+ one\n+ two" {
+					t.Errorf("Expected formatted Markdown, got: %q", markdown)
+				}
+				if resp["type"] != "markdown" {
+					t.Errorf("Expected the markdown converter, got: %v", resp["type"])
+				}
+			},
+		},
+		{
+			name: "Invalid base64 content",
+			request: domain.ConversionRequest{
+				Type:     "html",
+				Content:  "<p>not base64</p>",
+				Encoding: "base64",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name: "With custom options",
 			request: domain.ConversionRequest{
@@ -139,6 +373,68 @@ func TestHTTPHandler_Convert(t *testing.T) {
 	}
 }
 
+func TestHTTPHandler_RequestLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	
+	var logs bytes.Buffer
+	logger, err := middleware.NewLogger(&logs, "info", "json")
+	if err != nil {
+		t.Fatalf("NewLogger() error = %v", err)
+	}
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.RequestLogger(logger))
+	router.POST("/convert", NewHTTPHandler(usecases.NewConverterUseCase()).Convert)
+	
+	send := func(request domain.ConversionRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(request)
+		req := httptest.NewRequest("POST", "/convert", bytes.NewBuffer(body))
+		req.Header.Set(middleware.RequestIDHeader, "req-42")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	readLog := func() map[string]interface{} {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+			t.Fatalf("Expected a JSON log line, got %q", logs.String())
+		}
+		logs.Reset()
+		return entry
+	}
+	
+	w := send(domain.ConversionRequest{Type: "html", Content: "<p>Hello</p>", Options: domain.ConversionOptions{Output: "yaml"}})
+	if w.Header().Get(middleware.RequestIDHeader) != "req-42" {
+		t.Errorf("Expected the request ID back, got %q", w.Header().Get(middleware.RequestIDHeader))
+	}
+	var resp struct {
+		Error struct {
+			Details map[string]interface{} `json:"details"`
+		} `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Error.Details["request_id"] != "req-42" {
+		t.Errorf("Expected the request ID in the error details, got %v", resp.Error.Details)
+	}
+	entry := readLog()
+	for key, want := range map[string]interface{}{
+		"level": "WARN", "msg": "request", "request_id": "req-42", "route": "/convert",
+		"status": float64(400), "input_type": "html", "input_size": float64(12), "error_code": "VALIDATION_ERROR",
+	} {
+		if entry[key] != want {
+			t.Errorf("Expected log %s %v, got %v", key, want, entry[key])
+		}
+	}
+	
+	send(domain.ConversionRequest{Type: "html", Content: "<p>Hello</p>"})
+	entry = readLog()
+	if entry["level"] != "INFO" || entry["status"] != float64(200) || entry["output_size"] != float64(5) || entry["error_code"] != nil {
+		t.Errorf("Unexpected log entry %v", entry)
+	}
+	if _, ok := entry["latency_ms"].(float64); !ok {
+		t.Errorf("Expected the latency in the log entry, got %v", entry)
+	}
+}
+
 func TestHTTPHandler_Health(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	
@@ -171,6 +467,37 @@ func TestHTTPHandler_Health(t *testing.T) {
 	}
 }
 
+func TestHTTPHandler_Capabilities(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	
+	handler := NewHTTPHandler(usecases.NewConverterUseCase())
+	router := gin.New()
+	router.GET("/capabilities", handler.Capabilities)
+	
+	req := httptest.NewRequest("GET", "/capabilities", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	
+	var resp struct {
+		Converters []domain.Capability `json:"converters"`
+		Tokenizers []string            `json:"tokenizers"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	
+	sizes := make(map[string]int)
+	for _, capability := range resp.Converters {
+		sizes[capability.Type] = capability.MaxSize
+	}
+	if sizes["html"] != 10*1024*1024 || sizes["pdf"] != 50*1024*1024 || sizes["markdown"] != 10*1024*1024 {
+		t.Errorf("Expected html, pdf and markdown converters with their size limits, got: %+v", resp.Converters)
+	}
+	if len(resp.Tokenizers) == 0 || resp.Tokenizers[0] != "cl100k_base" {
+		t.Errorf("Expected the embedded tokenizer to be listed, got: %v", resp.Tokenizers)
+	}
+}
+
 func generateLargeHTML(size int) string {
 	var buf bytes.Buffer
 	buf.WriteString("<html><body>")
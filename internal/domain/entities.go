@@ -2,13 +2,19 @@ package domain
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"strings"
 	"time"
 )
 
 type ConversionRequest struct {
 	Type     string            `json:"type"`
 	Content  string            `json:"content"`
+	// Encoding is "base64" when Content carries raw bytes; PDFs always do
+	Encoding string            `json:"encoding,omitempty"`
 	Options  ConversionOptions `json:"options,omitempty"`
+	URL      string            `json:"url,omitempty"`
+	Recipe   string            `json:"recipe,omitempty"`
 	// Deprecated: use Content instead
 	HTML     string            `json:"html,omitempty"`
 }
@@ -26,10 +32,11 @@ func (r *ConversionRequest) GetContent() string {
 	return ""
 }
 
-// GetContentAsBytes returns content as bytes, handling base64 for binary formats
-func (r *ConversionRequest) GetContentAsBytes() ([]byte, error) {
+// GetContentAsBytes returns content as bytes, handling base64 for binary
+// formats, which are always base64 encoded
+func (r *ConversionRequest) GetContentAsBytes(binary bool) ([]byte, error) {
 	content := r.GetContent()
-	if r.Type == "pdf" {
+	if binary || r.Encoding == "base64" {
 		// PDF content should be base64 encoded
 		return base64.StdEncoding.DecodeString(content)
 	}
@@ -46,19 +53,241 @@ type ConversionOptions struct {
 	LinkStyle          string `json:"link_style,omitempty"`
 	LinkReferenceStyle string `json:"link_reference_style,omitempty"`
 	PreformattedCode   bool   `json:"preformatted_code,omitempty"`
+	GuessCodeLanguage  bool   `json:"guess_code_language,omitempty"`
+	TableStrategy      string `json:"table_strategy,omitempty"`
+	TOC                bool   `json:"toc,omitempty"`
+	HeadingIDs         string `json:"heading_ids,omitempty"`
+	FrontMatter        string `json:"front_matter,omitempty"`
+	Charset            string `json:"charset,omitempty"`
+	Images             string `json:"images,omitempty"`
+	KeepSROnly         bool   `json:"keep_sr_only,omitempty"`
+	PreferAriaLabel    bool   `json:"prefer_aria_label,omitempty"`
+	Profile            string `json:"profile,omitempty"`
+	Forms              string `json:"forms,omitempty"`
+	SVG                string `json:"svg,omitempty"`
+	Ruby               string `json:"ruby,omitempty"`
+	WrapWidth          int    `json:"wrap_width,omitempty"`
+	Output             string `json:"output,omitempty"`
+	Flavor             string `json:"flavor,omitempty"`
+	Chunking           *ChunkingOptions `json:"chunking,omitempty"`
+	Tokenizer          string `json:"tokenizer,omitempty"`
+	SourceMap          bool   `json:"source_map,omitempty"`
+	// Elements overrides how semantic elements are rendered, e.g. {"mark": "html"}
+	Elements map[string]string `json:"elements,omitempty"`
+
+	// set holds the JSON names of the options decoded from a request
+	set map[string]bool
+}
+
+// UnmarshalJSON decodes the options and records which ones the JSON sets,
+// false and zero values included.
+func (o *ConversionOptions) UnmarshalJSON(data []byte) error {
+	type options ConversionOptions
+	if err := json.Unmarshal(data, (*options)(o)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	o.set = make(map[string]bool, len(fields))
+	for name := range fields {
+		// encoding/json matches names regardless of case
+		o.set[strings.ToLower(name)] = true
+	}
+	return nil
+}
+
+// IsSet reports whether the JSON the options were decoded from has the
+// option with the given name. It is false for options built in Go.
+func (o ConversionOptions) IsSet(name string) bool {
+	return o.set[strings.ToLower(name)]
+}
+
+// ChunkingOptions limits the size of the chunks returned with the chunking
+// option. Without limits chunks are limited to DefaultChunkTokens tokens.
+// Overlap is in characters when only MaxChars is set, in tokens otherwise.
+type ChunkingOptions struct {
+	MaxTokens int `json:"max_tokens,omitempty"`
+	MaxChars  int `json:"max_chars,omitempty"`
+	Overlap   int `json:"overlap,omitempty"`
 }
 
+// DefaultChunkTokens is the chunk size used when the chunking option sets
+// no limit.
+const DefaultChunkTokens = 512
+
 type ConversionResponse struct {
-	Markdown  string    `json:"markdown"`
-	Timestamp time.Time `json:"timestamp"`
-	Stats     Stats     `json:"stats"`
-	Type      string    `json:"type"`
+	Markdown    string        `json:"markdown"`
+	Timestamp   time.Time     `json:"timestamp"`
+	Stats       Stats         `json:"stats"`
+	Type        string        `json:"type"`
+	Tables      []TableReport `json:"tables,omitempty"`
+	Metadata    *Metadata     `json:"metadata,omitempty"`
+	Charset     string        `json:"charset,omitempty"`
+	Attachments []Attachment  `json:"attachments,omitempty"`
+	Recipe      string        `json:"recipe,omitempty"`
+	// Document is the structure of the document, returned with output "json"
+	Document *DocumentStructure `json:"document,omitempty"`
+	Chunks   []Chunk            `json:"chunks,omitempty"`
+	SourceMap []SourceMapEntry  `json:"source_map,omitempty"`
+}
+
+// SourceMapEntry locates a block of the Markdown, by its bytes (Offset to
+// EndOffset, exclusive) and lines (1-based, inclusive), and tells where it
+// comes from: HTML for HTML input, PDF for PDFs.
+type SourceMapEntry struct {
+	Type      string      `json:"type"`
+	Offset    int         `json:"offset"`
+	EndOffset int         `json:"end_offset"`
+	Line      int         `json:"line"`
+	EndLine   int         `json:"end_line"`
+	HTML      *HTMLSource `json:"html,omitempty"`
+	PDF       *PDFSource  `json:"pdf,omitempty"`
+}
+
+// HTMLSource is an element of the HTML: its CSS path and its bytes, from
+// its start tag to the end of its end tag, in the HTML decoded to UTF-8.
+// EndOffset is 0 when the element isn't closed explicitly.
+type HTMLSource struct {
+	Path      string `json:"path"`
+	Offset    int    `json:"offset"`
+	EndOffset int    `json:"end_offset,omitempty"`
+}
+
+// PDFSource is an area of a PDF page. BBox is the lower left and upper
+// right corners in PDF points: x0, y0, x1, y1.
+type PDFSource struct {
+	Page int       `json:"page"`
+	BBox []float64 `json:"bbox,omitempty"`
+}
+
+// Chunk is a part of the Markdown, returned with the chunking option.
+// Breadcrumb is the path of headings of the section the chunk belongs to,
+// PageStart and PageEnd the pages it comes from (PDF only). The chunk
+// starts with Overlap characters repeated from the previous chunk.
+type Chunk struct {
+	Index      int      `json:"index"`
+	Markdown   string   `json:"markdown"`
+	Breadcrumb []string `json:"breadcrumb,omitempty"`
+	PageStart  int      `json:"page_start,omitempty"`
+	PageEnd    int      `json:"page_end,omitempty"`
+	Tokens     int      `json:"tokens"`
+	Characters int      `json:"characters"`
+	Overlap    int      `json:"overlap,omitempty"`
+}
+
+// Capability describes a format the service converts
+type Capability struct {
+	Type       string   `json:"type"`
+	MIMETypes  []string `json:"mime_types"`
+	Extensions []string `json:"extensions"`
+	Binary     bool     `json:"binary"` // content must be base64 encoded
+	MaxSize    int      `json:"max_size"`
+}
+
+// DocumentStructure is the converted document as a tree of sections.
+// Content before the first heading is in a section of level 0.
+type DocumentStructure struct {
+	Title    string    `json:"title,omitempty"`
+	Sections []Section `json:"sections"`
+}
+
+// Section is a heading with the content up to the next heading of the same
+// or a higher level. IDs are derived from the heading text, numbered like
+// GitHub anchors when repeated, so they are stable across conversions of
+// the same document.
+type Section struct {
+	ID       string         `json:"id"`
+	Heading  string         `json:"heading,omitempty"`
+	Level    int            `json:"level"`
+	Page     int            `json:"page,omitempty"`
+	Blocks   []ContentBlock `json:"blocks,omitempty"`
+	Links    []Link         `json:"links,omitempty"`
+	Images   []Image        `json:"images,omitempty"`
+	Sections []Section      `json:"sections,omitempty"`
+}
+
+// ContentBlock is a block of a section. Text is its plain text and Markdown
+// its rendering; lists also have their items and tables their cells as
+// plain text.
+type ContentBlock struct {
+	ID       string     `json:"id"`
+	Type     string     `json:"type"` // paragraph, list, code, table, quote, html
+	Text     string     `json:"text,omitempty"`
+	Markdown string     `json:"markdown"`
+	Page     int        `json:"page,omitempty"`
+	Language string     `json:"language,omitempty"`
+	Ordered  bool       `json:"ordered,omitempty"`
+	Items    []string   `json:"items,omitempty"`
+	Header   []string   `json:"header,omitempty"`
+	Rows     [][]string `json:"rows,omitempty"`
+}
+
+type Link struct {
+	URL   string `json:"url"`
+	Text  string `json:"text,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+type Image struct {
+	Source string `json:"src"`
+	Alt    string `json:"alt,omitempty"`
+	Title  string `json:"title,omitempty"`
+}
+
+// Attachment is a file extracted from the source document and referenced
+// from the Markdown by its filename
+type Attachment struct {
+	Filename  string `json:"filename"`
+	MediaType string `json:"media_type"`
+	Size      int    `json:"size"`
+	Data      string `json:"data"` // base64
+}
+
+// Metadata is what the source document says about itself. Dates are
+// RFC 3339 strings when they could be parsed.
+type Metadata struct {
+	Title        string            `json:"title,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Author       string            `json:"author,omitempty"`
+	Subject      string            `json:"subject,omitempty"`
+	Keywords     []string          `json:"keywords,omitempty"`
+	Language     string            `json:"language,omitempty"`
+	CanonicalURL string            `json:"canonical_url,omitempty"`
+	Creator      string            `json:"creator,omitempty"`
+	Producer     string            `json:"producer,omitempty"`
+	Created      string            `json:"created,omitempty"`
+	Modified     string            `json:"modified,omitempty"`
+	Pages        int               `json:"pages,omitempty"`
+	OpenGraph    map[string]string `json:"open_graph,omitempty"`
+	Twitter      map[string]string `json:"twitter,omitempty"`
+	JSONLD       []interface{}     `json:"json_ld,omitempty"`
+}
+
+// IsEmpty reports whether no metadata was found
+func (m *Metadata) IsEmpty() bool {
+	return m.Title == "" && m.Description == "" && m.Author == "" && m.Subject == "" &&
+		len(m.Keywords) == 0 && m.Language == "" && m.CanonicalURL == "" && m.Creator == "" &&
+		m.Producer == "" && m.Created == "" && m.Modified == "" && m.Pages == 0 &&
+		len(m.OpenGraph) == 0 && len(m.Twitter) == 0 && len(m.JSONLD) == 0
+}
+
+// TableReport describes how a single source table was rendered
+type TableReport struct {
+	Index       int    `json:"index"`
+	Strategy    string `json:"strategy"`
+	Rows        int    `json:"rows"`
+	Columns     int    `json:"columns"`
+	MergedCells int    `json:"merged_cells"`
 }
 
 type Stats struct {
 	InputLength   int           `json:"input_length"`
 	OutputLength  int           `json:"output_length"`
 	ProcessingMs  int64         `json:"processing_ms"`
+	OutputTokens  int           `json:"output_tokens"`
+	Tokenizer     string        `json:"tokenizer"`
 	ElementsCount ElementsCount `json:"elements_count"`
 }
 
@@ -0,0 +1,130 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+
+	"any2md/pkg/errors"
+	"any2md/pkg/tokenizer"
+)
+
+// enumOption describes an option that only accepts a fixed set of values.
+type enumOption struct {
+	name    string
+	value   func(o ConversionOptions) string
+	allowed []string
+}
+
+var enumOptions = []enumOption{
+	{"heading_style", func(o ConversionOptions) string { return o.HeadingStyle }, []string{"atx", "setext"}},
+	{"bullet_list_marker", func(o ConversionOptions) string { return o.BulletListMarker }, []string{"-", "*", "+"}},
+	{"code_block_style", func(o ConversionOptions) string { return o.CodeBlockStyle }, []string{"fenced", "indented"}},
+	{"fence", func(o ConversionOptions) string { return o.Fence }, []string{"```", "~~~"}},
+	{"em_delimiter", func(o ConversionOptions) string { return o.EmDelimiter }, []string{"_", "*"}},
+	{"strong_delimiter", func(o ConversionOptions) string { return o.StrongDelimiter }, []string{"**", "__"}},
+	{"link_style", func(o ConversionOptions) string { return o.LinkStyle }, []string{"inlined", "referenced"}},
+	{"link_reference_style", func(o ConversionOptions) string { return o.LinkReferenceStyle }, []string{"full", "collapsed", "shortcut"}},
+	{"table_strategy", func(o ConversionOptions) string { return o.TableStrategy }, []string{"gfm", "expand", "html", "key_value"}},
+	{"heading_ids", func(o ConversionOptions) string { return o.HeadingIDs }, []string{"preserve", "github"}},
+	{"front_matter", func(o ConversionOptions) string { return o.FrontMatter }, []string{"yaml", "toml", "json"}},
+	{"images", func(o ConversionOptions) string { return o.Images }, []string{"keep", "remove", "alt_only", "extract"}},
+	{"profile", func(o ConversionOptions) string { return o.Profile }, []string{"strict-commonmark", "gfm", "pandoc", "obsidian"}},
+	{"forms", func(o ConversionOptions) string { return o.Forms }, []string{"drop", "describe"}},
+	{"svg", func(o ConversionOptions) string { return o.SVG }, []string{"drop", "alt", "extract"}},
+	{"ruby", func(o ConversionOptions) string { return o.Ruby }, []string{"annotate", "base"}},
+	{"output", func(o ConversionOptions) string { return o.Output }, []string{"markdown", "json"}},
+	{"flavor", func(o ConversionOptions) string { return o.Flavor }, []string{"gfm", "commonmark", "multimarkdown", "pandoc", "plain"}},
+}
+
+// semanticElements are the elements that can be configured with the
+// elements option, and elementModes the ways they can be rendered.
+var (
+	semanticElements = []string{"nav", "aside", "header", "footer", "abbr", "details", "mark", "ins", "del", "sub", "sup", "kbd"}
+	elementModes     = []string{"drop", "text", "html", "extended"}
+)
+
+// minWrapWidth is the narrowest wrap_width accepted; narrower columns
+// would leave most lines unbreakable.
+const minWrapWidth = 20
+
+// Validate rejects option values the converters don't understand. Empty
+// values are always accepted and mean "use the default".
+func (o ConversionOptions) Validate() error {
+	for _, option := range enumOptions {
+		value := option.value(o)
+		if value == "" || contains(option.allowed, value) {
+			continue
+		}
+
+		err := errors.NewValidationError(fmt.Sprintf("invalid value %q for option %s, expected one of: %s", value, option.name, strings.Join(option.allowed, ", ")))
+		err.Details["option"] = option.name
+		err.Details["allowed"] = option.allowed
+		return err
+	}
+
+	if names := tokenizer.Names(); o.Tokenizer != "" && !contains(names, o.Tokenizer) {
+		err := errors.NewValidationError(fmt.Sprintf("invalid value %q for option tokenizer, expected one of: %s", o.Tokenizer, strings.Join(names, ", ")))
+		err.Details["option"] = "tokenizer"
+		err.Details["allowed"] = names
+		return err
+	}
+
+	if o.WrapWidth != 0 && o.WrapWidth < minWrapWidth {
+		err := errors.NewValidationError(fmt.Sprintf("invalid value %d for option wrap_width, expected 0 (no wrapping) or at least %d", o.WrapWidth, minWrapWidth))
+		err.Details["option"] = "wrap_width"
+		err.Details["minimum"] = minWrapWidth
+		return err
+	}
+
+	if c := o.Chunking; c != nil {
+		fields := []struct {
+			name  string
+			value int
+		}{{"max_tokens", c.MaxTokens}, {"max_chars", c.MaxChars}, {"overlap", c.Overlap}}
+		for _, field := range fields {
+			if field.value < 0 {
+				err := errors.NewValidationError(fmt.Sprintf("invalid value %d for option chunking.%s, expected a positive number", field.value, field.name))
+				err.Details["option"] = "chunking." + field.name
+				return err
+			}
+		}
+		// the overlap is measured like the limit that applies
+		limit := c.MaxTokens
+		if limit == 0 {
+			limit = c.MaxChars
+		}
+		if limit == 0 {
+			limit = DefaultChunkTokens
+		}
+		if c.Overlap >= limit {
+			err := errors.NewValidationError(fmt.Sprintf("invalid value %d for option chunking.overlap, expected less than the chunk size (%d)", c.Overlap, limit))
+			err.Details["option"] = "chunking.overlap"
+			return err
+		}
+	}
+
+	for element, mode := range o.Elements {
+		if !contains(semanticElements, element) {
+			err := errors.NewValidationError(fmt.Sprintf("unknown element %q in option elements, expected one of: %s", element, strings.Join(semanticElements, ", ")))
+			err.Details["option"] = "elements"
+			err.Details["allowed"] = semanticElements
+			return err
+		}
+		if !contains(elementModes, mode) {
+			err := errors.NewValidationError(fmt.Sprintf("invalid value %q for element %s, expected one of: %s", mode, element, strings.Join(elementModes, ", ")))
+			err.Details["option"] = "elements"
+			err.Details["allowed"] = elementModes
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -9,6 +9,9 @@ import (
 type Config struct {
 	Server   ServerConfig
 	RateLimit RateLimitConfig
+	Recipes  RecipesConfig
+	Tokenizers TokenizersConfig
+	Log      LogConfig
 }
 
 type ServerConfig struct {
@@ -23,6 +26,24 @@ type RateLimitConfig struct {
 	Window      time.Duration
 }
 
+type RecipesConfig struct {
+	// Dir holds the site recipe YAML files; recipes are disabled when empty
+	Dir string
+}
+
+type TokenizersConfig struct {
+	// Dir holds tiktoken vocabularies (cl100k_base.tiktoken...) registered
+	// next to the embedded ones
+	Dir string
+}
+
+type LogConfig struct {
+	// Level is debug, info, warn or error
+	Level string
+	// Format is json or text
+	Format string
+}
+
 func Load() *Config {
 	return &Config{
 		Server: ServerConfig{
@@ -35,6 +56,16 @@ func Load() *Config {
 			MaxRequests: getIntEnv("RATE_LIMIT_MAX_REQUESTS", 100),
 			Window:      getDurationEnv("RATE_LIMIT_WINDOW", 1*time.Minute),
 		},
+		Recipes: RecipesConfig{
+			Dir: getEnv("RECIPES_DIR", ""),
+		},
+		Tokenizers: TokenizersConfig{
+			Dir: getEnv("TOKENIZERS_DIR", ""),
+		},
+		Log: LogConfig{
+			Level:  getEnv("LOG_LEVEL", "info"),
+			Format: getEnv("LOG_FORMAT", "json"),
+		},
 	}
 }
 
@@ -0,0 +1,319 @@
+// Package metrics collects the service metrics and exposes them in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"any2md/internal/infrastructure/middleware"
+)
+
+// Buckets of the histograms: seconds for latencies, bytes for sizes.
+var (
+	LatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	SizeBuckets    = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216, 67108864}
+)
+
+// Metrics holds the metrics of the service. Its zero value isn't usable;
+// create one with New.
+type Metrics struct {
+	requests        *counter
+	errors          *counter
+	rateLimited     *counter
+	latency         *histogram
+	inputSize       *histogram
+	outputSize      *histogram
+	inFlight        *gauge
+	conversionPaths map[string]bool
+	start           time.Time
+}
+
+// New creates the metrics. Requests to conversionRoutes, such as
+// "/api/v1/convert", count as conversions while they are in flight.
+func New(conversionRoutes ...string) *Metrics {
+	m := &Metrics{
+		requests:        newCounter("any2md_http_requests_total", "HTTP requests by route and status.", "route", "status"),
+		errors:          newCounter("any2md_errors_total", "Error responses by error code.", "code"),
+		rateLimited:     newCounter("any2md_rate_limit_rejections_total", "Requests rejected by the rate limiter."),
+		latency:         newHistogram("any2md_conversion_duration_seconds", "Duration of successful conversions by input type.", LatencyBuckets, "type"),
+		inputSize:       newHistogram("any2md_input_size_bytes", "Size of the converted inputs by input type.", SizeBuckets, "type"),
+		outputSize:      newHistogram("any2md_output_size_bytes", "Size of the Markdown produced by input type.", SizeBuckets, "type"),
+		inFlight:        &gauge{name: "any2md_conversions_in_flight", help: "Conversions being processed."},
+		conversionPaths: make(map[string]bool),
+		start:           time.Now(),
+	}
+	for _, route := range conversionRoutes {
+		m.conversionPaths[route] = true
+	}
+	return m
+}
+
+// Middleware records every request. It reads the input type, sizes and
+// error code that the handlers and the rate limiter set on the context,
+// so it has to run before them.
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			// unknown paths share a label, to bound the number of series
+			route = "unmatched"
+		}
+		conversion := m.conversionPaths[route]
+		if conversion {
+			m.inFlight.add(1)
+		}
+		start := time.Now()
+		c.Next()
+		if conversion {
+			m.inFlight.add(-1)
+		}
+
+		m.requests.inc(route, strconv.Itoa(c.Writer.Status()))
+		code := c.GetString(middleware.ErrorCodeKey)
+		if code != "" {
+			m.errors.inc(code)
+		}
+		if code == "RATE_LIMIT_EXCEEDED" {
+			m.rateLimited.inc()
+		}
+
+		inputType := c.GetString(middleware.InputTypeKey)
+		output, converted := c.Get(middleware.OutputSizeKey)
+		if inputType == "" || !converted {
+			return
+		}
+		m.latency.observe(time.Since(start).Seconds(), inputType)
+		if input, ok := c.Get(middleware.InputSizeKey); ok {
+			m.inputSize.observe(toFloat(input), inputType)
+		}
+		m.outputSize.observe(toFloat(output), inputType)
+	}
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// Handler serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		m.Write(c.Writer)
+	}
+}
+
+// Write writes the metrics, followed by the Go runtime statistics, in the
+// Prometheus text exposition format.
+func (m *Metrics) Write(w io.Writer) {
+	m.requests.write(w)
+	m.errors.write(w)
+	m.rateLimited.write(w)
+	m.latency.write(w)
+	m.inputSize.write(w)
+	m.outputSize.write(w)
+	m.inFlight.write(w)
+	m.writeRuntime(w)
+}
+
+func (m *Metrics) writeRuntime(w io.Writer) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	writeSample(w, "go_goroutines", "Number of goroutines that currently exist.", "gauge", float64(runtime.NumGoroutine()))
+	writeSample(w, "go_threads", "Number of OS threads created.", "gauge", float64(threads()))
+	fmt.Fprintf(w, "# HELP go_info Information about the Go environment.\n# TYPE go_info gauge\ngo_info{version=%s} 1\n", quote(runtime.Version()))
+	writeSample(w, "go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", "gauge", float64(stats.Alloc))
+	writeSample(w, "go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", "counter", float64(stats.TotalAlloc))
+	writeSample(w, "go_memstats_sys_bytes", "Number of bytes obtained from system.", "gauge", float64(stats.Sys))
+	writeSample(w, "go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", "gauge", float64(stats.HeapAlloc))
+	writeSample(w, "go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", "gauge", float64(stats.HeapInuse))
+	writeSample(w, "go_memstats_heap_objects", "Number of allocated objects.", "gauge", float64(stats.HeapObjects))
+	writeSample(w, "go_memstats_stack_inuse_bytes", "Number of bytes in use by the stack allocator.", "gauge", float64(stats.StackInuse))
+	writeSample(w, "go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", "gauge", float64(stats.NextGC))
+	writeSample(w, "go_gc_cycles_total", "Number of completed GC cycles.", "counter", float64(stats.NumGC))
+	writeSample(w, "go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", "counter", float64(stats.PauseTotalNs)/1e9)
+	writeSample(w, "process_start_time_seconds", "Start time of the process since unix epoch in seconds.", "gauge", float64(m.start.UnixNano())/1e9)
+	writeSample(w, "process_uptime_seconds", "Time since the process started, in seconds.", "gauge", time.Since(m.start).Seconds())
+}
+
+func threads() int {
+	n, _ := runtime.ThreadCreateProfile(nil)
+	return n
+}
+
+func writeSample(w io.Writer, name, help, kind string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatValue(value))
+}
+
+// series are the values of a metric by label values, joined with \xff.
+type series struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (s *series) key(values []string) string {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d labels, got %d", s.name, len(s.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelSet formats the labels of a key, with extra appended, as {a="x"}.
+func (s *series) labelSet(key string, extra ...string) string {
+	var pairs []string
+	if len(s.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, s.labels[i]+"="+quote(value))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+quote(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type counter struct {
+	series
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	return &counter{series: series{name: name, help: help, labels: labels}, values: make(map[string]float64)}
+}
+
+func (c *counter) inc(values ...string) {
+	key := c.key(values)
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", c.name, formatValue(c.values[""]))
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelSet(key), formatValue(c.values[key]))
+	}
+}
+
+type gauge struct {
+	name  string
+	help  string
+	mu    sync.Mutex
+	value float64
+}
+
+func (g *gauge) add(delta float64) {
+	g.mu.Lock()
+	g.value += delta
+	g.mu.Unlock()
+}
+
+func (g *gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeSample(w, g.name, g.help, "gauge", g.value)
+}
+
+type histogram struct {
+	series
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *histogram {
+	return &histogram{series: series{name: name, help: help, labels: labels}, buckets: buckets, values: make(map[string]*histogramValue)}
+}
+
+func (h *histogram) observe(value float64, labels ...string) {
+	key := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			v.counts[i]++
+		}
+	}
+	v.count++
+	v.sum += value
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.values) {
+		v := h.values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelSet(key, "le", formatValue(bound)), v.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelSet(key, "le", "+Inf"), v.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelSet(key), formatValue(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelSet(key), v.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// quote writes a label value with the escapes of the text format.
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
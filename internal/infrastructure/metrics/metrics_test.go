@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"any2md/internal/infrastructure/middleware"
+)
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	m := New("/convert")
+	router := gin.New()
+	router.Use(m.Middleware())
+	router.Use(middleware.RateLimiter(3, time.Minute))
+	var inFlight string
+	router.POST("/convert", func(c *gin.Context) {
+		var b bytes.Buffer
+		m.inFlight.write(&b)
+		inFlight = b.String()
+
+		c.Set(middleware.InputTypeKey, "html")
+		c.Set(middleware.InputSizeKey, 2000)
+		if c.Query("fail") != "" {
+			c.Set(middleware.ErrorCodeKey, "PARSING_ERROR")
+			c.Status(422)
+			return
+		}
+		c.Set(middleware.OutputSizeKey, 300)
+		c.Status(200)
+	})
+
+	for _, target := range []string{"/convert", "/convert?fail=1", "/missing", "/convert"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", target, nil))
+	}
+	if !strings.Contains(inFlight, "any2md_conversions_in_flight 1\n") {
+		t.Errorf("Expected a conversion in flight during the request, got %q", inFlight)
+	}
+
+	// scraped apart, out of reach of the rate limiter
+	scraper := gin.New()
+	scraper.GET("/metrics", m.Handler())
+	w := httptest.NewRecorder()
+	scraper.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		`any2md_http_requests_total{route="/convert",status="200"} 1`,
+		`any2md_http_requests_total{route="/convert",status="422"} 1`,
+		`any2md_http_requests_total{route="/convert",status="429"} 1`,
+		`any2md_http_requests_total{route="unmatched",status="404"} 1`,
+		`any2md_errors_total{code="PARSING_ERROR"} 1`,
+		`any2md_errors_total{code="RATE_LIMIT_EXCEEDED"} 1`,
+		`any2md_rate_limit_rejections_total 1`,
+		`any2md_conversion_duration_seconds_bucket{type="html",le="+Inf"} 1`,
+		`any2md_conversion_duration_seconds_count{type="html"} 1`,
+		`any2md_input_size_bytes_bucket{type="html",le="1024"} 0`,
+		`any2md_input_size_bytes_bucket{type="html",le="4096"} 1`,
+		`any2md_output_size_bytes_sum{type="html"} 300`,
+		`any2md_conversions_in_flight 0`,
+		`# TYPE go_goroutines gauge`,
+		`go_info{version="`,
+		`# TYPE go_gc_cycles_total counter`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got := quote("a\"b\\c\nd"); got != `"a\"b\\c\nd"` {
+		t.Errorf("Unexpected escaping %s", got)
+	}
+}
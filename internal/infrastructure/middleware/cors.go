@@ -42,6 +42,7 @@ func RateLimiter(maxRequests int, window time.Duration) gin.HandlerFunc {
 			} else {
 				cl.count++
 				if cl.count > maxRequests {
+					c.Set(ErrorCodeKey, "RATE_LIMIT_EXCEEDED")
 					c.JSON(429, gin.H{
 						"error": gin.H{
 							"code":    "RATE_LIMIT_EXCEEDED",
@@ -62,9 +63,3 @@ func RateLimiter(maxRequests int, window time.Duration) gin.HandlerFunc {
 		c.Next()
 	}
 }
-
-func RequestLogger() gin.HandlerFunc {
-	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		return ""
-	})
-}
\ No newline at end of file
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the request ID, from the client or generated.
+const RequestIDHeader = "X-Request-ID"
+
+// Keys of the values handlers set on the gin context for the access log.
+const (
+	RequestIDKey  = "request_id"
+	InputTypeKey  = "input_type"
+	InputSizeKey  = "input_size"
+	OutputSizeKey = "output_size"
+	ErrorCodeKey  = "error_code"
+)
+
+// NewLogger creates the service logger. level is "debug", "info", "warn"
+// or "error" and format "json" or "text".
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+	options := &slog.HandlerOptions{Level: l}
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// RequestID gives every request an ID: the X-Request-ID header sent by the
+// client when it is a reasonable token, a random one otherwise. The ID is
+// returned in the same header and stored under RequestIDKey.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(RequestIDKey, id)
+		c.Writer.Header().Set(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// validRequestID accepts IDs of up to 128 visible ASCII characters, so
+// that a client can't forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestLogger writes an access log entry per request: request ID, client
+// IP, method, route, status and latency, and the input type, sizes and
+// error code the handlers set on the context. Server errors are logged at
+// error level and client errors at warn level.
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+
+		attrs := []slog.Attr{
+			slog.String("request_id", c.GetString(RequestIDKey)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		}
+		if inputType := c.GetString(InputTypeKey); inputType != "" {
+			attrs = append(attrs, slog.String("input_type", inputType))
+		}
+		if size, ok := c.Get(InputSizeKey); ok {
+			attrs = append(attrs, slog.Any("input_size", size))
+		}
+		if size, ok := c.Get(OutputSizeKey); ok {
+			attrs = append(attrs, slog.Any("output_size", size))
+		}
+		if code := c.GetString(ErrorCodeKey); code != "" {
+			attrs = append(attrs, slog.String("error_code", code))
+		}
+		logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
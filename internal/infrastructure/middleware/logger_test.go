@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNewLogger(t *testing.T) {
+	var b bytes.Buffer
+	logger, err := NewLogger(&b, "warn", "text")
+	if err != nil {
+		t.Fatalf("NewLogger() error = %v", err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown")
+	if out := b.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "level=WARN msg=shown") {
+		t.Errorf("Expected warnings only in text format, got %q", out)
+	}
+
+	if _, err := NewLogger(&b, "loud", "json"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+	if _, err := NewLogger(&b, "info", "xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/", func(c *gin.Context) {
+		c.String(200, c.GetString(RequestIDKey))
+	})
+
+	for header, keep := range map[string]bool{"abc-123": true, "": false, "two words": false, strings.Repeat("x", 129): false} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIDHeader, header)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		id := w.Header().Get(RequestIDHeader)
+		if id != w.Body.String() {
+			t.Errorf("Expected the same ID in the header and the context, got %q and %q", id, w.Body.String())
+		}
+		if keep && id != header {
+			t.Errorf("Expected %q to be kept, got %q", header, id)
+		}
+		if !keep && (id == header || len(id) != 32) {
+			t.Errorf("Expected a generated ID instead of %q, got %q", header, id)
+		}
+	}
+}
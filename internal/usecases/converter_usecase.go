@@ -2,73 +2,138 @@ package usecases
 
 import (
 	"context"
-	"fmt"
+	"encoding/base64"
+	"strings"
 	"time"
 
 	"any2md/internal/domain"
 	"any2md/pkg/converter"
+	"any2md/pkg/errors"
+	"any2md/pkg/recipe"
+	"any2md/pkg/tokenizer"
 )
 
 type ConverterUseCase struct {
-	htmlConverter *converter.HTMLToMarkdownConverter
-	pdfConverter  *converter.PDFToMarkdownConverter
+	registry *converter.Registry
 }
 
 func NewConverterUseCase() *ConverterUseCase {
 	return &ConverterUseCase{
-		htmlConverter: converter.NewHTMLToMarkdownConverter(),
-		pdfConverter:  converter.NewPDFToMarkdownConverter(),
+		registry: converter.NewRegistry(),
 	}
 }
 
+// UseRecipes makes site recipes available to the converters that use them.
+func (uc *ConverterUseCase) UseRecipes(book *recipe.Book) {
+	for _, c := range uc.registry.Converters() {
+		if r, ok := c.(interface{ UseRecipes(*recipe.Book) }); ok {
+			r.UseRecipes(book)
+		}
+	}
+}
+
+// Capabilities describes the registered converters.
+func (uc *ConverterUseCase) Capabilities() []domain.Capability {
+	return uc.registry.Capabilities()
+}
+
+// Tokenizers lists the tokenizers available for token counts.
+func (uc *ConverterUseCase) Tokenizers() []string {
+	return tokenizer.Names()
+}
+
+// Resolve sets request.Type to the name of the converter that handles the
+// request. The type may be given as a converter name, MIME type or file
+// extension; when it is missing it is detected from the content.
+func (uc *ConverterUseCase) Resolve(request *domain.ConversionRequest) (domain.Capability, error) {
+	content := request.GetContent()
+	names := strings.Join(uc.registry.Names(), ", ")
+
+	if request.Type == "" {
+		c := uc.registry.Detect([]byte(content))
+		if decoded, err := base64.StdEncoding.DecodeString(content); err == nil && (c == nil || request.Encoding == "base64") {
+			c = uc.registry.Detect(decoded)
+		}
+		if c == nil {
+			return domain.Capability{}, errors.NewValidationError("type field is required (" + names + ")")
+		}
+		request.Type = c.Name()
+	}
+
+	c, ok := uc.registry.Lookup(request.Type)
+	if !ok {
+		return domain.Capability{}, errors.NewValidationError("type must be one of: " + names)
+	}
+	request.Type = c.Name()
+	return converter.Describe(c), nil
+}
+
 func (uc *ConverterUseCase) Convert(ctx context.Context, request domain.ConversionRequest) (*domain.ConversionResponse, error) {
 	startTime := time.Now()
 	
-	var markdown string
-	var stats domain.ElementsCount
-	var err error
-	var inputLength int
+	if _, err := uc.Resolve(&request); err != nil {
+		return nil, err
+	}
+	c, _ := uc.registry.Lookup(request.Type)
 	
-	// Get content as bytes for processing
-	contentBytes, err := request.GetContentAsBytes()
+	// the name was validated with the options: only loading can fail
+	tokens, err := tokenizer.Get(request.Options.Tokenizer)
 	if err != nil {
-		return nil, err
+		return nil, errors.NewInternalError(err.Error())
 	}
-	inputLength = len(contentBytes)
 	
-	// Route to appropriate converter based on type
-	switch request.Type {
-	case "html":
-		content := request.GetContent()
-		markdown, stats, err = uc.htmlConverter.Convert(content, request.Options)
-		inputLength = len(content) // Use string length for HTML
-	case "pdf":
-		markdown, stats, err = uc.pdfConverter.Convert(contentBytes, request.Options)
-	default:
-		// Backward compatibility: if no type specified but HTML exists
-		if request.HTML != "" {
-			markdown, stats, err = uc.htmlConverter.Convert(request.HTML, request.Options)
-			inputLength = len(request.HTML)
-		} else {
-			return nil, fmt.Errorf("unsupported conversion type: %s", request.Type)
-		}
+	// Get content as bytes for processing
+	contentBytes, err := request.GetContentAsBytes(c.Binary())
+	if err != nil {
+		return nil, errors.NewValidationError("content is not valid base64: " + err.Error())
 	}
 	
+	input := converter.Input{
+		Data:   contentBytes,
+		Text:   !c.Binary() && request.Encoding != "base64",
+		Source: converter.Source{URL: request.URL, Recipe: request.Recipe},
+	}
+	result, err := c.Convert(ctx, input, request.Options)
 	if err != nil {
 		return nil, err
 	}
 	
-	processingTime := time.Since(startTime).Milliseconds()
+	var structure *domain.DocumentStructure
+	if request.Options.Output == "json" && result.Document != nil {
+		structure = result.Document.Structure(request.Options)
+	}
+	var sourceMap []domain.SourceMapEntry
+	if request.Options.SourceMap && result.Document != nil {
+		sourceMap = result.Document.SourceMap(result.Markdown)
+	}
+	var chunks []domain.Chunk
+	if request.Options.Chunking != nil && result.Document != nil {
+		if chunks, err = result.Document.Chunks(request.Options); err != nil {
+			return nil, errors.NewInternalError(err.Error())
+		}
+	}
+	
+	stats := domain.Stats{
+		InputLength:   len(contentBytes),
+		OutputLength:  len(result.Markdown),
+		OutputTokens:  tokens.Count(result.Markdown),
+		Tokenizer:     tokens.Name(),
+		ElementsCount: result.Elements,
+	}
+	stats.ProcessingMs = time.Since(startTime).Milliseconds()
 	
 	return &domain.ConversionResponse{
-		Markdown:  markdown,
-		Timestamp: time.Now(),
-		Type:      request.Type,
-		Stats: domain.Stats{
-			InputLength:   inputLength,
-			OutputLength:  len(markdown),
-			ProcessingMs:  processingTime,
-			ElementsCount: stats,
-		},
+		Markdown:    result.Markdown,
+		Timestamp:   time.Now(),
+		Type:        request.Type,
+		Tables:      result.Tables,
+		Metadata:    result.Metadata,
+		Charset:     result.Charset,
+		Attachments: result.Attachments,
+		Recipe:      result.Recipe,
+		Document:    structure,
+		Chunks:      chunks,
+		SourceMap:   sourceMap,
+		Stats:       stats,
 	}, nil
 }
\ No newline at end of file
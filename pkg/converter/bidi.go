@@ -0,0 +1,98 @@
+package converter
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// attrBidiDir marks the wrappers added by prepareBidi with the direction
+// of their content.
+const attrBidiDir = "data-any2md-dir"
+
+// Unicode directional isolates and overrides.
+const (
+	leftToRightIsolate  = "\u2066"
+	rightToLeftIsolate  = "\u2067"
+	firstStrongIsolate  = "\u2068"
+	popDirectional      = "\u2069"
+	leftToRightOverride = "\u202D"
+	rightToLeftOverride = "\u202E"
+	popFormatting       = "\u202C"
+)
+
+// bidiBlocks are the elements whose direction is kept by wrapping them in a
+// <div dir> HTML block. Other elements (spans, list items, table cells)
+// can't be wrapped without breaking the Markdown around them and get
+// directional isolates instead.
+var bidiBlocks = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true, "blockquote": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "dl": true, "table": true, "pre": true, "figure": true,
+	"header": true, "footer": true, "nav": true, "aside": true, "address": true, "details": true,
+}
+
+// prepareBidi keeps the direction of text whose dir attribute differs from
+// the direction around it, and of <bdi> and <bdo> elements. Markdown has no
+// syntax for it: blocks are wrapped in a <div dir> HTML block and inline
+// text is enclosed in the matching Unicode isolates, which plain text
+// viewers honour as well.
+func prepareBidi(doc *goquery.Document) {
+	doc.Find("body [dir], body bdi, body bdo").Each(func(i int, s *goquery.Selection) {
+		dir := strings.ToLower(strings.TrimSpace(s.AttrOr("dir", "")))
+		if dir != "ltr" && dir != "rtl" && dir != "auto" {
+			dir = ""
+		}
+		if strings.TrimSpace(s.Text()) == "" {
+			return
+		}
+
+		if s.Is("bdo") {
+			switch dir {
+			case "rtl":
+				isolate(s, rightToLeftOverride, popFormatting)
+			case "ltr":
+				isolate(s, leftToRightOverride, popFormatting)
+			}
+			return
+		}
+		if s.Is("bdi") && dir == "" {
+			dir = "auto"
+		}
+		if dir == "" || (dir == inheritedDir(s) && !s.Is("bdi")) {
+			return
+		}
+
+		if bidiBlocks[goquery.NodeName(s)] {
+			s.WrapHtml(`<div ` + attrBidiDir + `="` + dir + `"></div>`)
+			return
+		}
+		switch dir {
+		case "rtl":
+			isolate(s, rightToLeftIsolate, popDirectional)
+		case "ltr":
+			isolate(s, leftToRightIsolate, popDirectional)
+		default:
+			isolate(s, firstStrongIsolate, popDirectional)
+		}
+	})
+}
+
+// inheritedDir is the direction an element gets from its ancestors.
+func inheritedDir(s *goquery.Selection) string {
+	dir := "ltr"
+	s.Parents().EachWithBreak(func(i int, parent *goquery.Selection) bool {
+		switch value := strings.ToLower(strings.TrimSpace(parent.AttrOr("dir", ""))); value {
+		case "ltr", "rtl", "auto":
+			dir = value
+			return false
+		}
+		return true
+	})
+	return dir
+}
+
+func isolate(s *goquery.Selection, open, close string) {
+	s.PrependNodes(textNode(open))
+	s.AppendNodes(textNode(close))
+}
@@ -0,0 +1,162 @@
+package converter
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"any2md/pkg/errors"
+)
+
+// legacyCandidates are the multi-byte encodings tried when undeclared
+// content is not valid UTF-8. The double-byte ranges of these encodings
+// overlap, so Japanese and Korean decodings must also contain a minimum
+// share of kana or hangul to be considered.
+var legacyCandidates = []struct {
+	name     string
+	encoding encoding.Encoding
+	script   []*unicode.RangeTable
+	minShare float64
+}{
+	{"shift_jis", japanese.ShiftJIS, []*unicode.RangeTable{unicode.Hiragana, unicode.Katakana}, 0.15},
+	{"euc-jp", japanese.EUCJP, []*unicode.RangeTable{unicode.Hiragana, unicode.Katakana}, 0.15},
+	{"euc-kr", korean.EUCKR, []*unicode.RangeTable{unicode.Hangul}, 0.6},
+	{"gbk", simplifiedchinese.GBK, nil, 0},
+	{"big5", traditionalchinese.Big5, nil, 0},
+}
+
+// commonCJK are some of the most frequent characters in Chinese (simplified
+// and traditional), Japanese and Korean text. Text decoded with the wrong
+// encoding is still CJK, but rarely hits these.
+const commonCJK = "のにはをたがでてとしいすまなる이다는의에가을를하고지서한니습기로도사어대정수시리자국있요것들보해나게입" +
+	"的一是不了在人有我他这這个個们們中来來上大为為和国國地到以说說时時要就出会會也你对對生能而子那得于於着著下自之年过過后後作里裏用道行所然家种種事成方多经經么麼去法学學如都同现現当當没沒动動面起看定天分还還进進好小部其些主样樣理心她本前开開但因只从從想实實日"
+
+// declaresCharsetR finds a <meta charset> or http-equiv declaration, which
+// DetermineEncoding honours but does not report as certain.
+var declaresCharsetR = regexp.MustCompile(`(?i)<meta[^>]+charset`)
+
+// decodeHTML transcodes raw HTML bytes to UTF-8 and returns the text with
+// the canonical name of the charset it was read as. An explicit charset
+// wins; otherwise the BOM and <meta charset>/http-equiv declarations are
+// honoured, and undeclared content falls back to UTF-8, a CJK encoding or
+// Windows-1252, whichever decodes it most plausibly.
+func decodeHTML(data []byte, override string) (string, string, error) {
+	if override != "" {
+		enc, err := htmlindex.Get(override)
+		if err != nil {
+			return "", "", errors.NewValidationError("unknown charset: " + override)
+		}
+		name, _ := htmlindex.Name(enc)
+		return transcode(data, enc, name)
+	}
+
+	enc, name, certain := charset.DetermineEncoding(data, "")
+	if !certain && !declaresCharsetR.Match(data[:min(len(data), 1024)]) && !utf8.Valid(data) {
+		if guess, guessName := guessLegacyEncoding(data); guess != nil {
+			enc, name = guess, guessName
+		}
+	}
+	return transcode(data, enc, name)
+}
+
+func transcode(data []byte, enc encoding.Encoding, name string) (string, string, error) {
+	// the decoder would otherwise keep a UTF-8 BOM as U+FEFF
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", "", errors.NewParsingError("Failed to decode HTML as "+name, map[string]interface{}{
+			"charset": name,
+			"error":   err.Error(),
+		})
+	}
+	return string(decoded), name, nil
+}
+
+// guessLegacyEncoding decodes the content with every candidate and keeps the
+// most plausible result: no invalid sequences, mostly CJK text and as many
+// common characters as possible. It returns nil when nothing looks like
+// CJK, leaving the Windows-1252 fallback in place.
+func guessLegacyEncoding(data []byte) (encoding.Encoding, string) {
+	var best encoding.Encoding
+	var bestName string
+	bestScore := 0.0
+
+	for _, candidate := range legacyCandidates {
+		decoded, err := candidate.encoding.NewDecoder().Bytes(data)
+		if err != nil {
+			continue
+		}
+		text := string(decoded)
+		if candidate.script != nil && scriptShare(text, candidate.script) < candidate.minShare {
+			continue
+		}
+		if score := cjkScore(text); score > bestScore {
+			best, bestName, bestScore = candidate.encoding, candidate.name, score
+		}
+	}
+
+	if bestScore < 0.5 {
+		return nil, ""
+	}
+	return best, bestName
+}
+
+// cjkScore rates how much the non-ASCII part of text looks like CJK: the
+// share of ideographs, kana, hangul and CJK punctuation, plus a bonus for
+// common characters. Replacement characters and controls disqualify the
+// text; half-width katakana, which a wrong guess tends to produce, count
+// against it.
+func cjkScore(text string) float64 {
+	var total, cjk, common int
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			continue
+		}
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return 0
+		}
+		total++
+		switch {
+		case r >= 0xff61 && r <= 0xff9f:
+			cjk--
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul),
+			r >= 0x3000 && r <= 0x303f, r >= 0xff01 && r <= 0xff5e:
+			cjk++
+			if strings.ContainsRune(commonCJK, r) {
+				common++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(cjk+2*common) / float64(total)
+}
+
+// scriptShare is the share of non-ASCII runes in text that belong to script.
+func scriptShare(text string, script []*unicode.RangeTable) float64 {
+	var total, matched int
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			continue
+		}
+		total++
+		if unicode.In(r, script...) {
+			matched++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(matched) / float64(total)
+}
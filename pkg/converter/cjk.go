@@ -0,0 +1,163 @@
+package converter
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Ruby modes accepted in ConversionOptions.Ruby.
+const (
+	RubyAnnotate = "annotate"
+	RubyBase     = "base"
+)
+
+// isCJK reports whether r belongs to a script written without spaces
+// between words, or is one of its full-width punctuation marks.
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Bopomofo) ||
+		(r >= 0x3000 && r <= 0x303F) || (r >= 0x30A0 && r <= 0x30FF) || (r >= 0xFF00 && r <= 0xFFEF)
+}
+
+// joinsLines reports whether a line break next to r is dropped rather than
+// read as a space. Korean separates words with spaces, so Hangul doesn't.
+func joinsLines(r rune) bool {
+	return isCJK(r) && !unicode.Is(unicode.Hangul, r)
+}
+
+// prepareRuby replaces <ruby> elements with their text: "base(annotation)"
+// in annotate mode (the default), using the <rp> parentheses of the source
+// when it has them, or the base text alone in base mode.
+func prepareRuby(doc *goquery.Document, mode string) {
+	doc.Find("ruby").Each(func(i int, ruby *goquery.Selection) {
+		type segment struct {
+			base        string
+			annotations []string
+		}
+		var segments []segment
+		var base strings.Builder
+		open, close := "", ""
+
+		ruby.Contents().Each(func(i int, child *goquery.Selection) {
+			switch goquery.NodeName(child) {
+			case "rp":
+				if len(segments) == 0 && open == "" {
+					open = strings.TrimSpace(child.Text())
+				} else if len(segments) > 0 && close == "" {
+					close = strings.TrimSpace(child.Text())
+				}
+			case "rt", "rtc":
+				annotation := collapseSpace(child.Clone().Find("rp").Remove().End().Text())
+				if base.Len() == 0 && len(segments) > 0 {
+					last := &segments[len(segments)-1]
+					last.annotations = append(last.annotations, annotation)
+					return
+				}
+				segments = append(segments, segment{base: base.String(), annotations: []string{annotation}})
+				base.Reset()
+			default:
+				base.WriteString(strings.TrimSpace(child.Text()))
+			}
+		})
+		if base.Len() > 0 {
+			segments = append(segments, segment{base: base.String()})
+		}
+		if open == "" || close == "" {
+			open, close = "(", ")"
+		}
+
+		var text strings.Builder
+		for _, s := range segments {
+			text.WriteString(s.base)
+			if mode == RubyBase {
+				continue
+			}
+			for _, annotation := range s.annotations {
+				if annotation != "" {
+					text.WriteString(open + annotation + close)
+				}
+			}
+		}
+		ruby.ReplaceWithNodes(textNode(text.String()))
+	})
+}
+
+// joinSegmentBreaks removes the line breaks of the source between two
+// Chinese or Japanese characters. Browsers render them as nothing, but
+// Markdown renderers turn a soft line break into a space, which splits
+// words that have no spaces between them.
+func joinSegmentBreaks(doc *goquery.Document) {
+	var texts []*html.Node
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "pre", "code", "textarea", "script", "style":
+				return
+			}
+		}
+		if n.Type == html.TextNode {
+			texts = append(texts, n)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+	}
+	for _, n := range doc.Nodes {
+		collect(n)
+	}
+
+	// neighbours are looked up across text nodes: `<b>日本</b>\n語`
+	prevRune := func(i int) rune {
+		for ; i >= 0; i-- {
+			if text := strings.TrimRightFunc(texts[i].Data, unicode.IsSpace); text != "" {
+				r, _ := utf8.DecodeLastRuneInString(text)
+				return r
+			}
+		}
+		return 0
+	}
+	nextRune := func(i int) rune {
+		for ; i < len(texts); i++ {
+			if text := strings.TrimLeftFunc(texts[i].Data, unicode.IsSpace); text != "" {
+				r, _ := utf8.DecodeRuneInString(text)
+				return r
+			}
+		}
+		return 0
+	}
+
+	for i, n := range texts {
+		if !strings.Contains(n.Data, "\n") {
+			continue
+		}
+		var b strings.Builder
+		text := n.Data
+		for len(text) > 0 {
+			start := strings.IndexFunc(text, unicode.IsSpace)
+			if start < 0 {
+				b.WriteString(text)
+				break
+			}
+			b.WriteString(text[:start])
+			end := start + len(text[start:]) - len(strings.TrimLeftFunc(text[start:], unicode.IsSpace))
+			space := text[start:end]
+
+			before, after := prevRune(i-1), nextRune(i+1)
+			if b.Len() > 0 {
+				before, _ = utf8.DecodeLastRuneInString(b.String())
+			}
+			if end < len(text) {
+				after, _ = utf8.DecodeRuneInString(text[end:])
+			}
+			if !strings.Contains(space, "\n") || !joinsLines(before) || !joinsLines(after) {
+				b.WriteString(space)
+			}
+			text = text[end:]
+		}
+		n.Data = b.String()
+	}
+}
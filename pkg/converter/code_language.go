@@ -0,0 +1,278 @@
+package converter
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"any2md/pkg/document"
+)
+
+// codeClassPrefixes are the class name prefixes used by common highlighters
+// (Prism, highlight.js, GitHub, Rouge, Chroma, Pygments) to carry the language.
+var codeClassPrefixes = []string{"language-", "lang-", "highlight-source-", "highlight-text-", "highlight-", "brush:"}
+
+// nonLanguageClasses are class names that highlighters add but that never
+// name a language.
+var nonLanguageClasses = map[string]bool{
+	"hljs":              true,
+	"highlight":         true,
+	"highlighter-rouge": true,
+	"prettyprint":       true,
+	"linenums":          true,
+	"line-numbers":      true,
+	"notranslate":       true,
+	"chroma":            true,
+	"sourcecode":        true,
+	"code":              true,
+	"codehilite":        true,
+	"syntax":            true,
+	"source":            true,
+	"text":              true,
+	"plain":             true,
+	"nohighlight":       true,
+	"no-highlight":      true,
+}
+
+// codeLanguageAliases normalizes the names highlighters use to the info
+// strings most Markdown renderers recognize.
+var codeLanguageAliases = map[string]string{
+	"c++":           "cpp",
+	"cs":            "csharp",
+	"c#":            "csharp",
+	"golang":        "go",
+	"js":            "javascript",
+	"ts":            "typescript",
+	"py":            "python",
+	"python3":       "python",
+	"rb":            "ruby",
+	"sh":            "shell",
+	"shell-session": "console",
+	"zsh":           "shell",
+	"yml":           "yaml",
+	"html5":         "html",
+	"xhtml":         "html",
+	"objective-c":   "objectivec",
+}
+
+// codeGutterSelector matches line-number gutters that highlighters render
+// next to the code and that must not end up inside the fence.
+const codeGutterSelector = ".linenos, .lineno, .line-numbers-rows, .gutter, .blob-num, .hljs-ln-numbers, [data-line-number]"
+
+// codeLanguage looks for a language hint on a <pre> element, its <code>
+// child and the wrappers highlighters put around them.
+func codeLanguage(pre *goquery.Selection) string {
+	candidates := []*goquery.Selection{pre.Find("code").First(), pre}
+	for parent := pre.Parent(); parent.Length() > 0 && len(candidates) < 5; parent = parent.Parent() {
+		if !parent.Is("div, figure, span") {
+			break
+		}
+		candidates = append(candidates, parent)
+	}
+
+	for _, selec := range candidates {
+		if selec.Length() == 0 {
+			continue
+		}
+		attrs := []string{"data-lang", "data-language"}
+		if selec.Is("pre, code") {
+			// GitHub renders fences as <pre lang="go">
+			attrs = append(attrs, "lang")
+		}
+		for _, attr := range attrs {
+			if value := strings.TrimSpace(selec.AttrOr(attr, "")); value != "" {
+				return normalizeCodeLanguage(value)
+			}
+		}
+		if language := languageFromClass(selec.AttrOr("class", "")); language != "" {
+			return language
+		}
+	}
+
+	return ""
+}
+
+// languageFromClass extracts a language from a class attribute such as
+// "language-go", "hljs python", "highlight-source-js" or "sourceCode haskell".
+func languageFromClass(class string) string {
+	fields := strings.Fields(strings.ToLower(class))
+
+	for _, field := range fields {
+		for _, prefix := range codeClassPrefixes {
+			if strings.HasPrefix(field, prefix) && len(field) > len(prefix) {
+				return normalizeCodeLanguage(strings.TrimPrefix(field, prefix))
+			}
+		}
+	}
+
+	// highlight.js (older releases) and Pandoc put the bare language name
+	// next to a marker class.
+	hasMarker := false
+	for _, field := range fields {
+		if field == "hljs" || field == "sourcecode" {
+			hasMarker = true
+		}
+	}
+	if hasMarker {
+		for _, field := range fields {
+			if !nonLanguageClasses[field] {
+				return normalizeCodeLanguage(field)
+			}
+		}
+	}
+
+	return ""
+}
+
+func normalizeCodeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	language = strings.TrimSuffix(language, ";")
+
+	// GitHub scopes look like "source-shell" or "text-html-basic".
+	if strings.HasPrefix(language, "source-") || strings.HasPrefix(language, "text-") {
+		language = language[strings.Index(language, "-")+1:]
+		if i := strings.Index(language, "-"); i > 0 {
+			language = language[:i]
+		}
+	}
+
+	if alias, ok := codeLanguageAliases[language]; ok {
+		return alias
+	}
+	if nonLanguageClasses[language] {
+		return ""
+	}
+	return language
+}
+
+// codeText returns the text of a code block with highlighting markup and
+// line-number gutters stripped. Unless preformatted is set, trailing blank
+// lines and spaces are dropped and runs of blank lines collapsed.
+func codeText(pre *goquery.Selection, preformatted bool) string {
+	clone := pre.Clone()
+	clone.Find(codeGutterSelector).Remove()
+
+	var buf strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "style", "script", "textarea":
+				return
+			case "br":
+				buf.WriteString("\n")
+				return
+			case "div":
+				// line-based highlighters wrap every line in a div
+				if buf.Len() > 0 && !strings.HasSuffix(buf.String(), "\n") {
+					buf.WriteString("\n")
+				}
+			}
+		}
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	for _, node := range clone.Nodes {
+		walk(node)
+	}
+
+	if preformatted {
+		return strings.TrimSuffix(buf.String(), "\n")
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return blankLinesR.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+}
+
+// codeLanguageSignature is a content pattern that hints at a language.
+type codeLanguageSignature struct {
+	language string
+	pattern  *regexp.Regexp
+	weight   int
+}
+
+var codeLanguageSignatures = []codeLanguageSignature{
+	{"go", regexp.MustCompile(`(?m)^package \w+$`), 3},
+	{"go", regexp.MustCompile(`\bfunc (\(\w+ \*?\w+\) )?\w+\(`), 2},
+	{"go", regexp.MustCompile(`:= |\bfmt\.\w+\(|\berr != nil\b`), 2},
+	{"python", regexp.MustCompile(`(?m)^\s*def \w+\(.*\):\s*$`), 3},
+	{"python", regexp.MustCompile(`(?m)^\s*(from [\w.]+ )?import [\w., ]+$`), 1},
+	{"python", regexp.MustCompile(`(?m)^\s*(class \w+(\(.*\))?|if .+|elif .+|else|for .+ in .+|with .+):\s*$`), 2},
+	{"python", regexp.MustCompile(`\bprint\(|\bself\.|\bNone\b|\bTrue\b|\bFalse\b`), 1},
+	{"javascript", regexp.MustCompile(`\b(const|let|var) \w+ = `), 2},
+	{"javascript", regexp.MustCompile(`\bfunction\s*\w*\s*\(|=> \{|\bconsole\.log\(`), 2},
+	{"javascript", regexp.MustCompile(`\brequire\(['"]|\bmodule\.exports\b|\bdocument\.\w+`), 2},
+	{"typescript", regexp.MustCompile(`\b(interface|type) \w+ (=|\{)|: (string|number|boolean)\b`), 3},
+	{"java", regexp.MustCompile(`\bpublic (static )?(class|void|final)\b|\bSystem\.out\.print`), 3},
+	{"java", regexp.MustCompile(`(?m)^import java\.`), 3},
+	{"c", regexp.MustCompile(`(?m)^#include <\w+\.h>`), 3},
+	{"c", regexp.MustCompile(`\bprintf\(|\bint main\(`), 2},
+	{"cpp", regexp.MustCompile(`(?m)^#include <\w+>$|\bstd::|\bcout <<`), 3},
+	{"csharp", regexp.MustCompile(`(?m)^using System|\bConsole\.Write|\bnamespace \w+`), 3},
+	{"rust", regexp.MustCompile(`\bfn \w+\(|\blet mut\b|\bimpl\b|println!\(`), 3},
+	{"ruby", regexp.MustCompile(`(?m)^\s*(def \w+[^:]*|end|require ['"].+['"]|puts .+)$`), 2},
+	{"php", regexp.MustCompile(`<\?php|\$\w+ = |\becho \$`), 3},
+	{"shell", regexp.MustCompile(`(?m)^#!/bin/(ba)?sh|^\$ \w+|^(sudo |apt(-get)? |npm |go |pip |cd |export |echo )`), 3},
+	{"sql", regexp.MustCompile(`(?i)\b(select .+ from|insert into|create table|update \w+ set|delete from)\b`), 3},
+	{"html", regexp.MustCompile(`(?i)<!doctype html|<(html|head|body|div|span|p|a)[\s>]`), 3},
+	{"css", regexp.MustCompile(`(?m)^\s*[.#]?[\w-]+(\s*[,>]\s*[.#]?[\w-]+)*\s*\{\s*$|^\s*[\w-]+:\s*[^;]+;\s*$`), 2},
+	{"json", regexp.MustCompile(`^\s*[\[{]\s*"[\w-]+"\s*:`), 4},
+	{"yaml", regexp.MustCompile(`(?m)^(---|[\w-]+:( [^{;]*)?)$`), 1},
+	{"xml", regexp.MustCompile(`^\s*<\?xml `), 4},
+	{"dockerfile", regexp.MustCompile(`(?m)^(FROM [\w./:-]+|RUN |COPY |ENTRYPOINT |WORKDIR )`), 3},
+}
+
+// guessCodeLanguage scores the code against a set of signatures and returns
+// the best match, or "" when nothing is convincing.
+func guessCodeLanguage(code string) string {
+	if strings.TrimSpace(code) == "" {
+		return ""
+	}
+
+	scores := make(map[string]int)
+	for _, signature := range codeLanguageSignatures {
+		if matches := len(signature.pattern.FindAllStringIndex(code, 3)); matches > 0 {
+			scores[signature.language] += signature.weight * matches
+		}
+	}
+
+	best, bestScore := "", 0
+	for _, signature := range codeLanguageSignatures {
+		if score := scores[signature.language]; score > bestScore {
+			best, bestScore = signature.language, score
+		}
+	}
+
+	// a single weak hit is more likely a coincidence than a language
+	if bestScore < 3 {
+		return ""
+	}
+	return best
+}
+
+// codeBlock converts a <pre> block into code annotated with the language
+// found in class names, data attributes or, optionally, the content.
+// Inside inline content it becomes inline code.
+func (b *domBuilder) codeBlock(n *html.Node) {
+	pre := selection(n)
+	code := codeText(pre, b.options.PreformattedCode)
+	if b.depth > 0 {
+		if code = strings.TrimSpace(strings.ReplaceAll(code, "\n", " ")); code != "" {
+			b.add(&document.Code{Value: code})
+		}
+		return
+	}
+	language := codeLanguage(pre)
+	if language == "" && b.options.GuessCodeLanguage {
+		language = guessCodeLanguage(code)
+	}
+	b.addBlock(&document.CodeBlock{Language: language, Code: code}, n)
+}
@@ -1,114 +1,189 @@
 package converter
 
 import (
+	"bytes"
+	"context"
+	"net/http"
+	"regexp"
 	"strings"
 
-	md "github.com/JohannesKaufmann/html-to-markdown"
-	"github.com/JohannesKaufmann/html-to-markdown/plugin"
 	"github.com/PuerkitoBio/goquery"
 	"any2md/internal/domain"
+	"any2md/pkg/document"
 	"any2md/pkg/errors"
+	"any2md/pkg/recipe"
 )
 
+func init() {
+	Register(func() Converter { return &htmlConverter{NewHTMLToMarkdownConverter()} })
+}
+
+// htmlConverter registers the HTML converter.
+type htmlConverter struct {
+	*HTMLToMarkdownConverter
+}
+
+func (c *htmlConverter) Name() string { return "html" }
+
+func (c *htmlConverter) MIMETypes() []string { return []string{"text/html", "application/xhtml+xml"} }
+
+func (c *htmlConverter) Extensions() []string { return []string{".html", ".htm", ".xhtml"} }
+
+func (c *htmlConverter) Binary() bool { return false }
+
+func (c *htmlConverter) MaxSize() int { return 10 * 1024 * 1024 }
+
+// Detect recognizes the markup sniffed as HTML by browsers, and fragments
+// that start with an element and close one.
+func (c *htmlConverter) Detect(data []byte) bool {
+	if strings.HasPrefix(http.DetectContentType(data), "text/html") {
+		return true
+	}
+	return htmlFragmentR.Match(data) && bytes.Contains(data, []byte("</"))
+}
+
+var htmlFragmentR = regexp.MustCompile(`^\s*<[a-zA-Z][a-zA-Z0-9-]*[\s/>]`)
+
+func (c *htmlConverter) Convert(ctx context.Context, input Input, options domain.ConversionOptions) (*Result, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	if input.Text {
+		return c.ConvertFrom(string(input.Data), input.Source, options)
+	}
+	return c.ConvertBytes(input.Data, input.Source, options)
+}
+
+// HTMLToMarkdownConverter is shared by concurrent conversions: the
+// document tree of a page is built from its DOM for each of them, and the
+// shared renderer applies the options of the request.
 type HTMLToMarkdownConverter struct {
-	converter *md.Converter
+	renderer *document.MarkdownRenderer
+	recipes  *recipe.Book
+}
+
+// Source describes where a page comes from: its URL and the recipe asked
+// for by name, if any. Both are used to select a site recipe.
+type Source struct {
+	URL    string
+	Recipe string
+}
+
+// UseRecipes sets the site recipes available to conversions.
+func (c *HTMLToMarkdownConverter) UseRecipes(book *recipe.Book) {
+	c.recipes = book
 }
 
 func NewHTMLToMarkdownConverter() *HTMLToMarkdownConverter {
-	opts := &md.Options{
-		HeadingStyle:     "atx",
-		BulletListMarker: "-",
-		CodeBlockStyle:   "fenced",
-		Fence:            "```",
-		EmDelimiter:      "_",
-		StrongDelimiter:  "**",
-		LinkStyle:        "inlined",
+	return &HTMLToMarkdownConverter{
+		renderer: document.NewMarkdownRenderer(),
+	}
+}
+
+// ConvertBytes converts raw HTML in any charset. The encoding is taken from
+// options.Charset when set and detected otherwise, and the HTML is
+// transcoded to UTF-8 before parsing.
+func (c *HTMLToMarkdownConverter) ConvertBytes(data []byte, source Source, options domain.ConversionOptions) (*Result, error) {
+	html, charset, err := decodeHTML(data, options.Charset)
+	if err != nil {
+		return nil, err
 	}
 	
-	converter := md.NewConverter("", true, opts)
-	
-	converter.Use(plugin.GitHubFlavored())
-	converter.Use(plugin.TaskListItems())
-	converter.Use(plugin.Table())
-	converter.Use(plugin.ConfluenceCodeBlock())
-	converter.Use(plugin.ConfluenceAttachments())
-	
-	converter.AddRules(customRules()...)
-	
-	return &HTMLToMarkdownConverter{
-		converter: converter,
+	result, err := c.ConvertFrom(html, source, options)
+	if err != nil {
+		return nil, err
 	}
+	result.Charset = charset
+	return result, nil
 }
 
-func (c *HTMLToMarkdownConverter) Convert(html string, options domain.ConversionOptions) (string, domain.ElementsCount, error) {
-	if strings.TrimSpace(html) == "" {
-		return "", domain.ElementsCount{}, errors.NewValidationError("HTML content cannot be empty")
+// Parse converts HTML with the default options and returns the document
+// tree instead of its Markdown. It implements domain.HTMLParser.
+func (c *HTMLToMarkdownConverter) Parse(html string) (domain.ParsedDocument, error) {
+	result, err := c.Convert(html, domain.ConversionOptions{})
+	if err != nil {
+		return nil, err
 	}
+	return result.Document, nil
+}
+
+func (c *HTMLToMarkdownConverter) Convert(html string, options domain.ConversionOptions) (*Result, error) {
+	return c.ConvertFrom(html, Source{}, options)
+}
 
-	c.applyOptions(options)
+// ConvertFrom converts a page, applying the site recipe selected for its
+// source before any other processing.
+func (c *HTMLToMarkdownConverter) ConvertFrom(html string, source Source, options domain.ConversionOptions) (*Result, error) {
+	if strings.TrimSpace(html) == "" {
+		return nil, errors.NewValidationError("HTML content cannot be empty")
+	}
 	
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
 	if err != nil {
-		return "", domain.ElementsCount{}, errors.NewParsingError("Failed to parse HTML", map[string]interface{}{
+		return nil, errors.NewParsingError("Failed to parse HTML", map[string]interface{}{
 			"error": err.Error(),
 		})
 	}
 	
-	stats := c.countElements(doc)
+	var sources htmlSources
+	if options.SourceMap {
+		sources = indexHTMLSources(html, doc)
+	}
 	
-	markdown, err := c.converter.ConvertString(html)
+	site, err := c.recipes.Select(source.Recipe, source.URL, doc)
 	if err != nil {
-		return "", domain.ElementsCount{}, errors.NewInternalError("Failed to convert HTML to Markdown: " + err.Error())
+		return nil, err
 	}
-	
-	markdown = c.postProcess(markdown)
-	
-	return markdown, stats, nil
-}
-
-func (c *HTMLToMarkdownConverter) applyOptions(options domain.ConversionOptions) {
-	// The html-to-markdown library doesn't support changing options after creation
-	// For now, we'll create a new converter with the requested options
-	opts := &md.Options{
-		HeadingStyle:     "atx",
-		BulletListMarker: "-",
-		CodeBlockStyle:   "fenced",
-		Fence:            "```",
-		EmDelimiter:      "_",
-		StrongDelimiter:  "**",
-		LinkStyle:        "inlined",
+	recipeName := ""
+	if site != nil {
+		recipeName = site.Name
+		options = site.WithDefaults(options)
+		site.Apply(doc)
 	}
 	
-	if options.HeadingStyle != "" {
-		opts.HeadingStyle = options.HeadingStyle
-	}
-	if options.BulletListMarker != "" {
-		opts.BulletListMarker = options.BulletListMarker
-	}
-	if options.CodeBlockStyle != "" {
-		opts.CodeBlockStyle = options.CodeBlockStyle
-	}
-	if options.Fence != "" {
-		opts.Fence = options.Fence
-	}
-	if options.EmDelimiter != "" {
-		opts.EmDelimiter = options.EmDelimiter
+	pruneHidden(doc, options)
+	prepareForms(doc, options.Forms)
+	prepareRuby(doc, options.Ruby)
+	prepareBidi(doc)
+	joinSegmentBreaks(doc)
+	stats := c.countElements(doc)
+	metadata := extractHTMLMetadata(doc)
+	if options.FrontMatter != "" && metadata != nil {
+		// the title moves into the front matter
+		doc.Find("head title").Remove()
+	}
+	footnotes := extractFootnotes(doc)
+	tables := prepareTables(doc, options.TableStrategy)
+	var files attachments
+	prepareImages(doc, options.Images, &files)
+	prepareGraphics(doc, options.SVG, &files)
+	prepareHeadingIDs(doc, options.HeadingIDs)
+	
+	tree := buildHTMLDocument(doc, footnotes, options, sources)
+	tree.Metadata = metadata
+	markdown, err := c.renderer.Render(tree, options)
+	if err != nil {
+		return nil, err
 	}
-	if options.StrongDelimiter != "" {
-		opts.StrongDelimiter = options.StrongDelimiter
+	markdown = c.postProcess(markdown, options)
+	if options.TOC && options.Flavor != document.FlavorPlain {
+		markdown = insertTableOfContents(markdown, options.BulletListMarker)
 	}
-	if options.LinkStyle != "" {
-		opts.LinkStyle = options.LinkStyle
+	markdown, err = prependFrontMatter(markdown, metadata, options.FrontMatter)
+	if err != nil {
+		return nil, errors.NewInternalError("Failed to render front matter: " + err.Error())
 	}
 	
-	// Create new converter with custom options
-	c.converter = md.NewConverter("", true, opts)
-	c.converter.Use(plugin.GitHubFlavored())
-	c.converter.Use(plugin.TaskListItems())
-	c.converter.Use(plugin.Table())
-	c.converter.Use(plugin.ConfluenceCodeBlock())
-	c.converter.Use(plugin.ConfluenceAttachments())
-	c.converter.AddRules(customRules()...)
+	return &Result{
+		Markdown:    markdown,
+		Document:    tree,
+		Elements:    stats,
+		Tables:      tables,
+		Metadata:    metadata,
+		Charset:     "utf-8",
+		Attachments: files.files,
+		Recipe:      recipeName,
+	}, nil
 }
 
 func (c *HTMLToMarkdownConverter) countElements(doc *goquery.Document) domain.ElementsCount {
@@ -116,175 +191,13 @@ func (c *HTMLToMarkdownConverter) countElements(doc *goquery.Document) domain.El
 		Headings:   doc.Find("h1, h2, h3, h4, h5, h6").Length(),
 		Paragraphs: doc.Find("p").Length(),
 		Links:      doc.Find("a").Length(),
-		Images:     doc.Find("img").Length(),
+		Images:     doc.Find("img").Length() + graphics(doc).Length(),
 		Lists:      doc.Find("ul, ol").Length(),
 		CodeBlocks: doc.Find("pre, code").Length(),
 		Tables:     doc.Find("table").Length(),
 	}
 }
 
-func (c *HTMLToMarkdownConverter) postProcess(markdown string) string {
-	lines := strings.Split(markdown, "\n")
-	var processed []string
-	
-	for i, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		
-		if i > 0 && trimmed == "" && strings.TrimSpace(lines[i-1]) == "" {
-			continue
-		}
-		
-		processed = append(processed, line)
-	}
-	
-	result := strings.Join(processed, "\n")
-	result = strings.TrimSpace(result)
-	
-	return result
+func (c *HTMLToMarkdownConverter) postProcess(markdown string, options domain.ConversionOptions) string {
+	return normalizeMarkdown(markdown, options.WrapWidth)
 }
-
-func customRules() []md.Rule {
-	return []md.Rule{
-		{
-			Filter: []string{"nav", "aside", "header", "footer"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				if strings.TrimSpace(content) != "" {
-					result := "\n---\n" + strings.TrimSpace(content) + "\n---\n"
-					return &result
-				}
-				empty := ""
-				return &empty
-			},
-		},
-		{
-			Filter: []string{"script", "style", "noscript"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				empty := ""
-				return &empty
-			},
-		},
-		{
-			Filter: []string{"abbr", "acronym"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				title, exists := selec.Attr("title")
-				if exists && title != "" {
-					result := content + " (" + title + ")"
-					return &result
-				}
-				return &content
-			},
-		},
-		{
-			Filter: []string{"details"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				summary := selec.Find("summary").Text()
-				if summary != "" {
-					result := "\n<details>\n<summary>" + summary + "</summary>\n\n" + content + "\n</details>\n"
-					return &result
-				}
-				result := "\n<details>\n" + content + "\n</details>\n"
-				return &result
-			},
-		},
-		{
-			Filter: []string{"mark"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				result := "==" + content + "=="
-				return &result
-			},
-		},
-		{
-			Filter: []string{"ins"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				result := "++" + content + "++"
-				return &result
-			},
-		},
-		{
-			Filter: []string{"del", "s", "strike"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				result := "~~" + content + "~~"
-				return &result
-			},
-		},
-		{
-			Filter: []string{"sub"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				result := "~" + content + "~"
-				return &result
-			},
-		},
-		{
-			Filter: []string{"sup"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				result := "^" + content + "^"
-				return &result
-			},
-		},
-		{
-			Filter: []string{"kbd"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				result := "`" + content + "`"
-				return &result
-			},
-		},
-		{
-			Filter: []string{"figure"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				caption := selec.Find("figcaption").Text()
-				if caption != "" {
-					result := content + "\n*" + caption + "*\n"
-					return &result
-				}
-				return &content
-			},
-		},
-		{
-			Filter: []string{"video", "audio"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				src, exists := selec.Attr("src")
-				if !exists {
-					src = selec.Find("source").First().AttrOr("src", "")
-				}
-				tagName := selec.Get(0).Data
-				if src != "" {
-					result := "[" + tagName + "](" + src + ")"
-					return &result
-				}
-				result := "[" + tagName + "]"
-				return &result
-			},
-		},
-		{
-			Filter: []string{"iframe"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				src, exists := selec.Attr("src")
-				if exists {
-					title := selec.AttrOr("title", "Embedded content")
-					result := "[" + title + "](" + src + ")"
-					return &result
-				}
-				empty := ""
-				return &empty
-			},
-		},
-		{
-			Filter: []string{"dl"},
-			Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
-				var result strings.Builder
-				result.WriteString("\n")
-				
-				selec.Find("dt, dd").Each(func(i int, s *goquery.Selection) {
-					if s.Is("dt") {
-						result.WriteString("**" + strings.TrimSpace(s.Text()) + "**\n")
-					} else {
-						result.WriteString(": " + strings.TrimSpace(s.Text()) + "\n")
-					}
-				})
-				
-				resultStr := result.String()
-				return &resultStr
-			},
-		},
-	}
-}
\ No newline at end of file
@@ -1,8 +1,23 @@
 package converter
 
 import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
 	"any2md/internal/domain"
+	"any2md/pkg/document"
+	"any2md/pkg/errors"
+	"any2md/pkg/recipe"
 )
 
 func TestHTMLToMarkdownConverter_Convert(t *testing.T) {
@@ -134,7 +149,7 @@ func TestHTMLToMarkdownConverter_Convert(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			markdown, stats, err := converter.Convert(tt.html, tt.options)
+			result, err := converter.Convert(tt.html, tt.options)
 			
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Convert() error = %v, wantErr %v", err, tt.wantErr)
@@ -142,15 +157,12 @@ func TestHTMLToMarkdownConverter_Convert(t *testing.T) {
 			}
 			
 			if !tt.wantErr {
+				markdown := result.Markdown
 				for _, substr := range tt.contains {
 					if !contains(markdown, substr) {
 						t.Errorf("Expected markdown to contain %q, but it doesn't. Markdown:\n%s", substr, markdown)
 					}
 				}
-				
-				if stats.InputLength != len(tt.html) {
-					t.Errorf("Stats.InputLength = %d, want %d", stats.InputLength, len(tt.html))
-				}
 			}
 		})
 	}
@@ -237,11 +249,12 @@ func TestHTMLToMarkdownConverter_ComplexDocument(t *testing.T) {
 </body>
 </html>`
 	
-	markdown, stats, err := converter.Convert(html, domain.ConversionOptions{})
+	result, err := converter.Convert(html, domain.ConversionOptions{})
 	
 	if err != nil {
 		t.Fatalf("Convert() error = %v", err)
 	}
+	markdown, stats := result.Markdown, result.Elements
 	
 	expectedElements := []string{
 		"# Understanding AI and LLMs",
@@ -253,11 +266,11 @@ func TestHTMLToMarkdownConverter_ComplexDocument(t *testing.T) {
 		"<details>",
 		"<summary>Advanced Features</summary>",
 		"![LLM Architecture](llm-diagram.png)",
-		"*Figure 1: LLM Architecture Overview*",
+		"_Figure 1: LLM Architecture Overview_",
 		"| Model",
 		"| GPT-4",
-		"---\n[Section 1](#section1)",
-		"---\n© 2024 AI Research",
+		"---\n\n[Section 1](#section1)",
+		"---\n\n© 2024 AI Research",
 	}
 	
 	for _, elem := range expectedElements {
@@ -279,6 +292,1323 @@ func TestHTMLToMarkdownConverter_ComplexDocument(t *testing.T) {
 	}
 }
 
+func TestHTMLToMarkdownConverter_CodeLanguage(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	tests := []struct {
+		name    string
+		html    string
+		options domain.ConversionOptions
+		want    string
+		exclude []string
+	}{
+		{
+			name: "Prism language class",
+			html: `<pre class="language-go"><code class="language-go">fmt.Println("hi")</code></pre>`,
+			want: "```go\nfmt.Println(\"hi\")\n```",
+		},
+		{
+			name: "highlight.js with token spans",
+			html: `<pre><code class="hljs language-python"><span class="hljs-keyword">def</span> <span class="hljs-title">f</span>():
+    <span class="hljs-keyword">return</span> 1</code></pre>`,
+			want:    "```python\ndef f():\n    return 1\n```",
+			exclude: []string{"hljs", "<span"},
+		},
+		{
+			name: "GitHub wrapper",
+			html: `<div class="highlight highlight-source-shell notranslate"><pre>echo hello</pre></div>`,
+			want: "```shell\necho hello\n```",
+		},
+		{
+			name: "Chroma data-lang",
+			html: `<div class="highlight"><pre class="chroma"><code data-lang="yml">key: value</code></pre></div>`,
+			want: "```yaml\nkey: value\n```",
+		},
+		{
+			name: "lang prefix and line numbers",
+			html: `<pre class="prettyprint lang-js"><span class="linenos">1</span>let x = 1;</pre>`,
+			want: "```javascript\nlet x = 1;\n```",
+		},
+		{
+			name: "No hint without guessing",
+			html: `<pre><code>package main
+
+func main() {
+	fmt.Println("hi")
+}</code></pre>`,
+			want: "```\npackage main",
+		},
+		{
+			name: "Guess from content",
+			html: `<pre><code>package main
+
+func main() {
+	fmt.Println("hi")
+}</code></pre>`,
+			options: domain.ConversionOptions{GuessCodeLanguage: true},
+			want:    "```go\npackage main",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(tt.html, tt.options)
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			markdown := result.Markdown
+			if !contains(markdown, tt.want) {
+				t.Errorf("Expected markdown to contain %q, got:\n%s", tt.want, markdown)
+			}
+			for _, substr := range tt.exclude {
+				if contains(markdown, substr) {
+					t.Errorf("Expected markdown not to contain %q, got:\n%s", substr, markdown)
+				}
+			}
+		})
+	}
+}
+
+func TestHTMLToMarkdownConverter_TableStrategy(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	merged := `<table>
+		<thead>
+			<tr><th rowspan="2">Region</th><th colspan="2">2024</th></tr>
+			<tr><th>Q1</th><th>Q2</th></tr>
+		</thead>
+		<tbody>
+			<tr><td rowspan="2">North</td><td>10</td><td>12</td></tr>
+			<tr><td>11</td><td>13</td></tr>
+		</tbody>
+	</table>`
+	simple := `<table><tr><th>A</th><th>B</th></tr><tr><td>1</td><td>2</td></tr></table>`
+
+	tests := []struct {
+		name     string
+		html     string
+		strategy string
+		want     []string
+		exclude  []string
+		reported []string
+	}{
+		{
+			name:     "Expand merged cells",
+			html:     merged,
+			strategy: TableStrategyExpand,
+			want: []string{
+				"| Region | 2024 Q1 | 2024 Q2 |",
+				"| North | 10 | 12 |",
+				"| North | 11 | 13 |",
+			},
+			reported: []string{TableStrategyExpand},
+		},
+		{
+			name:     "Raw HTML for merged cells only",
+			html:     merged + simple,
+			strategy: TableStrategyHTML,
+			want:     []string{`<th rowspan="2">Region</th>`, "| A | B |"},
+			exclude:  []string{"data-any2md-table", "\n\n<tr"},
+			reported: []string{TableStrategyHTML, TableStrategyGFM},
+		},
+		{
+			name:     "Key-value lists",
+			html:     merged,
+			strategy: TableStrategyKeyValue,
+			want: []string{
+				"- **Region**: North\n- **2024 Q1**: 10\n- **2024 Q2**: 12",
+				"- **Region**: North\n- **2024 Q1**: 11\n- **2024 Q2**: 13",
+			},
+			exclude:  []string{"|"},
+			reported: []string{TableStrategyKeyValue},
+		},
+		{
+			name:     "Merged cells expanded by default",
+			html:     `<table><tr><th>Name</th><th>Value</th></tr><tr><td rowspan="2">x</td><td>1</td></tr><tr><td>2</td></tr></table>` + simple,
+			want:     []string{"| x | 1 |\n| x | 2 |", "| A | B |"},
+			reported: []string{TableStrategyExpand, TableStrategyGFM},
+		},
+		{
+			name:     "Merged cells expanded with gfm",
+			html:     merged,
+			strategy: TableStrategyGFM,
+			want:     []string{"| Region | 2024 Q1 | 2024 Q2 |", "| North | 11 | 13 |"},
+			reported: []string{TableStrategyExpand},
+		},
+		{
+			name:     "Simple tables keep GFM",
+			html:     simple,
+			strategy: TableStrategyExpand,
+			want:     []string{"| A | B |", "| 1 | 2 |"},
+			reported: []string{TableStrategyGFM},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(tt.html, domain.ConversionOptions{TableStrategy: tt.strategy})
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			for _, substr := range tt.exclude {
+				if contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown not to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			if len(result.Tables) != len(tt.reported) {
+				t.Fatalf("Expected %d table reports, got %d", len(tt.reported), len(result.Tables))
+			}
+			for i, strategy := range tt.reported {
+				if result.Tables[i].Strategy != strategy {
+					t.Errorf("Table %d strategy = %q, want %q", i, result.Tables[i].Strategy, strategy)
+				}
+			}
+		})
+	}
+}
+
+func TestHTMLToMarkdownConverter_Footnotes(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	tests := []struct {
+		name    string
+		html    string
+		want    []string
+		exclude []string
+	}{
+		{
+			name: "Wikipedia references",
+			html: `<p>Go was designed at Google<sup id="cite_ref-1" class="reference"><a href="#cite_note-1">[1]</a></sup> in 2007.<sup class="reference"><a href="#cite_note-2">[2]</a></sup></p>
+				<h2>References</h2>
+				<div class="reflist"><ol class="references">
+					<li id="cite_note-1"><span class="mw-cite-backlink"><a href="#cite_ref-1">^</a></span> <span class="reference-text">Pike, Rob. <i>Go at Google</i>.</span></li>
+					<li id="cite_note-2"><span class="mw-cite-backlink"><a href="#cite_ref-2">^</a></span> <span class="reference-text">Release notes.</span></li>
+				</ol></div>`,
+			want: []string{
+				"designed at Google[^1] in 2007.[^2]",
+				"[^1]: Pike, Rob. _Go at Google_.",
+				"[^2]: Release notes.",
+			},
+			exclude: []string{"^[", "cite_note", "[^]"},
+		},
+		{
+			name: "Pandoc footnotes",
+			html: `<p>Text<a href="#fn1" class="footnote-ref" id="fnref1" role="doc-noteref"><sup>1</sup></a> and again<a href="#fn1" class="footnote-ref" role="doc-noteref"><sup>1</sup></a>.</p>
+				<section class="footnotes" role="doc-endnotes"><hr /><ol>
+					<li id="fn1"><p>First paragraph.</p><p>Second paragraph.<a href="#fnref1" class="footnote-back" role="doc-backlink">↩︎</a></p></li>
+				</ol></section>`,
+			want: []string{
+				"Text[^1] and again[^1].",
+				"[^1]: First paragraph.\n\n    Second paragraph.",
+			},
+			exclude: []string{"↩", "* * *"},
+		},
+		{
+			name:    "In-page links are not footnotes",
+			html:    `<p>See <a href="#setup">setup</a>.</p><h2 id="setup">Setup</h2>`,
+			want:    []string{"[setup](#setup)"},
+			exclude: []string{"[^"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(tt.html, domain.ConversionOptions{})
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			for _, substr := range tt.exclude {
+				if contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown not to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+		})
+	}
+}
+
+func TestHTMLToMarkdownConverter_LinkAndCodeOptions(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	links := `<p><a href="https://go.dev">Go</a> and <a href="https://example.com">Example</a></p>`
+	preformatted := `<p>Run <code>make   build</code> first.</p><p><code>line one
+    line two</code></p><pre><code>a
+
+
+b
+</code></pre>`
+
+	tests := []struct {
+		name    string
+		html    string
+		options domain.ConversionOptions
+		want    []string
+		exclude []string
+	}{
+		{
+			name:    "Full reference links",
+			html:    links,
+			options: domain.ConversionOptions{LinkStyle: "referenced", LinkReferenceStyle: "full"},
+			want:    []string{"[Go][1]", "[1]: https://go.dev", "[Example][2]"},
+		},
+		{
+			name:    "Collapsed reference links",
+			html:    links,
+			options: domain.ConversionOptions{LinkStyle: "referenced", LinkReferenceStyle: "collapsed"},
+			want:    []string{"[Go][]", "[Go]: https://go.dev"},
+		},
+		{
+			name:    "Shortcut reference links",
+			html:    links,
+			options: domain.ConversionOptions{LinkStyle: "referenced", LinkReferenceStyle: "shortcut"},
+			want:    []string{"[Go] and", "[Example]: https://example.com"},
+			exclude: []string{"[Go][", "[Go]("},
+		},
+		{
+			name:    "Code whitespace collapsed by default",
+			html:    preformatted,
+			want:    []string{"```\na\n\nb\n```"},
+			exclude: []string{"a\n\n\nb"},
+		},
+		{
+			name:    "Preformatted code passthrough",
+			html:    preformatted,
+			options: domain.ConversionOptions{PreformattedCode: true},
+			want:    []string{"`make   build`", "```\nline one\n    line two\n```", "```\na\n\n\nb\n```"},
+		},
+		{
+			name:    "Indented code blocks",
+			html:    `<p>Example:</p><pre><code class="language-go">x := 1
+y := 2</code></pre>`,
+			options: domain.ConversionOptions{CodeBlockStyle: "indented"},
+			want:    []string{"    x := 1\n    y := 2"},
+			exclude: []string{"```"},
+		},
+		{
+			name:    "Leading indented code block",
+			html:    "<pre><code>x := 1\ny := 2</code></pre><p>after</p>",
+			options: domain.ConversionOptions{CodeBlockStyle: "indented"},
+			want:    []string{"    x := 1\n    y := 2\n\nafter"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(tt.html, tt.options)
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			for _, substr := range tt.exclude {
+				if contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown not to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+		})
+	}
+}
+
+func TestPDFToMarkdownConverter_PostProcess(t *testing.T) {
+	converter := NewPDFToMarkdownConverter()
+
+	text := "Installation steps for the service are below.\n    go build ./...\n        ./server --port 80\nDocs live at https://example.com/docs. See https://example.com/docs again."
+	options := domain.ConversionOptions{PreformattedCode: true, LinkStyle: "referenced", LinkReferenceStyle: "full"}
+
+	doc := &document.Document{Blocks: converter.processPageText(text, options, 1)}
+	markdown, err := converter.renderer.Render(doc, options)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	markdown = converter.postProcess(markdown, options)
+	stats := doc.GetStats()
+
+	for _, substr := range []string{
+		"```\ngo build ./...\n    ./server --port 80\n```",
+		"[https://example.com/docs][1]. See [https://example.com/docs][1] again.",
+		"[1]: https://example.com/docs",
+	} {
+		if !contains(markdown, substr) {
+			t.Errorf("Expected markdown to contain %q, got:\n%s", substr, markdown)
+		}
+	}
+	if stats.CodeBlocks != 1 || stats.Links != 2 {
+		t.Errorf("Expected 1 code block and 2 links, got %+v", stats)
+	}
+
+	wrapped := converter.postProcess("Installation steps for the service are below.", domain.ConversionOptions{WrapWidth: 30})
+	if wrapped != "Installation steps for the\nservice are below." {
+		t.Errorf("Expected the line to be wrapped at 30 columns, got:\n%s", wrapped)
+	}
+
+	for _, policy := range []string{ImagesKeep, ImagesAltOnly} {
+		_, err := converter.Convert([]byte("%PDF-1.7\n"), domain.ConversionOptions{Images: policy})
+		convErr, ok := err.(*errors.ConversionError)
+		if !ok || convErr.Code != "VALIDATION_ERROR" || convErr.Details["option"] != "images" {
+			t.Errorf("Expected images %q to be rejected for PDFs, got %v", policy, err)
+		}
+	}
+}
+
+func TestHTMLToMarkdownConverter_TableOfContents(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	html := `<h1>Guide</h1>
+		<p>Jump to <a href="#install">installing</a> or <a href="#faq">the FAQ</a>.</p>
+		<section id="install"><h2>Getting Started!</h2><p>Text.</p>
+			<h3>On <code>Linux</code></h3><p>Text.</p>
+		</section>
+		<h2 id="faq">FAQ</h2>
+		<h2>FAQ</h2>
+		<pre><code># not a heading</code></pre>`
+
+	tests := []struct {
+		name    string
+		options domain.ConversionOptions
+		want    []string
+		exclude []string
+	}{
+		{
+			name:    "Nested table of contents",
+			options: domain.ConversionOptions{TOC: true},
+			want: []string{
+				"# Guide\n\n- [Getting Started!](#getting-started)\n  - [On Linux](#on-linux)\n- [FAQ](#faq)\n- [FAQ](#faq-1)\n\nJump to",
+			},
+			exclude: []string{"(#not-a-heading)"},
+		},
+		{
+			name:    "GitHub slugs rewrite in-document links",
+			options: domain.ConversionOptions{HeadingIDs: "github"},
+			want:    []string{"[installing](#getting-started)", "[the FAQ](#faq)"},
+			exclude: []string{"(#install)"},
+		},
+		{
+			name:    "Preserved ids become anchors used by the TOC",
+			options: domain.ConversionOptions{HeadingIDs: "preserve", TOC: true},
+			want: []string{
+				"[installing](#install)",
+				"<a id=\"install\"></a>\n\n## Getting Started!",
+				"<a id=\"faq\"></a>\n\n## FAQ",
+				"- [Getting Started!](#install)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(html, tt.options)
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			for _, substr := range tt.exclude {
+				if contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown not to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+		})
+	}
+
+	// a repeated heading whose next slug is taken by another heading
+	result, err := converter.Convert(`<h1>Intro</h1><h2>Intro 1</h2><h2>Intro</h2>`, domain.ConversionOptions{TOC: true})
+	if err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	if want := "- [Intro 1](#intro-1)\n- [Intro](#intro-2)"; !contains(result.Markdown, want) {
+		t.Errorf("Expected markdown to contain %q, got:\n%s", want, result.Markdown)
+	}
+}
+
+func TestHTMLToMarkdownConverter_FrontMatter(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	html := `<html lang="en"><head>
+		<title>Release Notes</title>
+		<meta name="description" content="What changed in &quot;v2&quot;">
+		<meta name="keywords" content="release, changelog">
+		<meta property="og:image" content="https://example.com/cover.png">
+		<meta name="twitter:card" content="summary">
+		<meta property="article:published_time" content="2024-03-01T10:00:00Z">
+		<link rel="canonical" href="https://example.com/notes">
+		<script type="application/ld+json">{"@type": "Article", "headline": "Release Notes"}</script>
+	</head><body><h1>Release Notes</h1><p>Body.</p></body></html>`
+
+	tests := []struct {
+		name    string
+		options domain.ConversionOptions
+		want    []string
+	}{
+		{
+			name:    "YAML",
+			options: domain.ConversionOptions{FrontMatter: "yaml"},
+			want: []string{
+				"---\ntitle: \"Release Notes\"\ndescription: \"What changed in \\\"v2\\\"\"\n",
+				"keywords: [\"release\", \"changelog\"]\n",
+				"language: \"en\"\ncanonical_url: \"https://example.com/notes\"\ncreated: \"2024-03-01T10:00:00Z\"\n",
+				"open_graph:\n  \"image\": \"https://example.com/cover.png\"\ntwitter:\n  \"card\": \"summary\"\n",
+				"json_ld: [{\"@type\":\"Article\",\"headline\":\"Release Notes\"}]\n---\n\n# Release Notes",
+			},
+		},
+		{
+			name:    "TOML",
+			options: domain.ConversionOptions{FrontMatter: "toml"},
+			want: []string{
+				"+++\ntitle = \"Release Notes\"\n",
+				"\n[open_graph]\n\"image\" = \"https://example.com/cover.png\"\n",
+				"+++\n\n# Release Notes",
+			},
+		},
+		{
+			name:    "JSON",
+			options: domain.ConversionOptions{FrontMatter: "json"},
+			want:    []string{"{\n  \"title\": \"Release Notes\",", "}\n\n# Release Notes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(html, tt.options)
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			if result.Metadata == nil || result.Metadata.Title != "Release Notes" || result.Metadata.OpenGraph["image"] == "" {
+				t.Errorf("Expected metadata to be returned, got %+v", result.Metadata)
+			}
+		})
+	}
+
+	result, err := converter.Convert("<p>No head here</p>", domain.ConversionOptions{FrontMatter: "yaml"})
+	if err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	if result.Metadata != nil || result.Markdown != "No head here" {
+		t.Errorf("Expected no front matter without metadata, got %q", result.Markdown)
+	}
+}
+
+func TestHTMLToMarkdownConverter_Charset(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	tests := []struct {
+		name        string
+		encoding    encoding.Encoding
+		html        string
+		options     domain.ConversionOptions
+		wantCharset string
+		want        string
+	}{
+		{
+			name:        "Shift_JIS declared in meta charset",
+			encoding:    japanese.ShiftJIS,
+			html:        `<meta charset="Shift_JIS"><p>会議</p>`,
+			wantCharset: "shift_jis",
+			want:        "会議",
+		},
+		{
+			name:        "GBK declared in http-equiv",
+			encoding:    simplifiedchinese.GBK,
+			html:        `<meta http-equiv="Content-Type" content="text/html; charset=gbk"><p>欢迎</p>`,
+			wantCharset: "gbk",
+			want:        "欢迎",
+		},
+		{
+			name:        "Undeclared Shift_JIS",
+			encoding:    japanese.ShiftJIS,
+			html:        "<p>日本語のテキストです。東京都に住んでいます。</p>",
+			wantCharset: "shift_jis",
+			want:        "日本語のテキストです。東京都に住んでいます。",
+		},
+		{
+			name:        "Undeclared GBK",
+			encoding:    simplifiedchinese.GBK,
+			html:        "<p>这是一个简体中文的测试页面，我们在北京。</p>",
+			wantCharset: "gbk",
+			want:        "这是一个简体中文的测试页面，我们在北京。",
+		},
+		{
+			name:        "Undeclared Big5",
+			encoding:    traditionalchinese.Big5,
+			html:        "<p>這是一個繁體中文的測試頁面，我們在臺北。</p>",
+			wantCharset: "big5",
+			want:        "這是一個繁體中文的測試頁面，我們在臺北。",
+		},
+		{
+			name:        "Undeclared EUC-KR",
+			encoding:    korean.EUCKR,
+			html:        "<p>한국어 테스트 페이지입니다.</p>",
+			wantCharset: "euc-kr",
+			want:        "한국어 테스트 페이지입니다.",
+		},
+		{
+			name:        "Undeclared Windows-1252",
+			encoding:    charmap.Windows1252,
+			html:        "<p>Café – naïve “quotes”</p>",
+			wantCharset: "windows-1252",
+			want:        "Café – naïve “quotes”",
+		},
+		{
+			name:        "UTF-8 with BOM",
+			encoding:    encoding.Nop,
+			html:        "\ufeff<p>Grüße</p>",
+			wantCharset: "utf-8",
+			want:        "Grüße",
+		},
+		{
+			name:        "Explicit charset wins over the declaration",
+			encoding:    charmap.ISO8859_15,
+			html:        `<meta charset="utf-8"><p>Prix : 5 €</p>`,
+			options:     domain.ConversionOptions{Charset: "iso-8859-15"},
+			wantCharset: "iso-8859-15",
+			want:        "Prix : 5 €",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.encoding.NewEncoder().Bytes([]byte(tt.html))
+			if err != nil {
+				t.Fatalf("failed to encode test input: %v", err)
+			}
+			result, err := converter.ConvertBytes(data, Source{}, tt.options)
+			if err != nil {
+				t.Fatalf("ConvertBytes() error = %v", err)
+			}
+			if result.Charset != tt.wantCharset {
+				t.Errorf("Expected charset %q, got %q", tt.wantCharset, result.Charset)
+			}
+			if result.Markdown != tt.want {
+				t.Errorf("Expected markdown %q, got %q", tt.want, result.Markdown)
+			}
+		})
+	}
+
+	if _, err := converter.ConvertBytes([]byte("<p>x</p>"), Source{}, domain.ConversionOptions{Charset: "klingon"}); err == nil {
+		t.Error("Expected an error for an unknown charset")
+	}
+}
+
+func TestHTMLToMarkdownConverter_Images(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	// a 1x1 transparent GIF
+	gif := "R0lGODlhAQABAAAAACH5BAEKAAEALAAAAAABAAEAAAICTAEAOw=="
+	html := `<p>Logo: <img src="https://example.com/logo.png" alt="Example logo"></p>
+		<p><a href="https://example.com"><img src="https://example.com/banner.png"></a></p>
+		<p><img src="data:image/gif;base64,` + gif + `" alt="Pixel"> and again <img src="data:image/gif;base64,` + gif + `"></p>`
+
+	tests := []struct {
+		name        string
+		images      string
+		want        []string
+		exclude     []string
+		attachments int
+	}{
+		{
+			name:    "Keep",
+			images:  "keep",
+			want:    []string{"![Example logo](https://example.com/logo.png)", "[![](https://example.com/banner.png)](https://example.com)", "![Pixel](data:image/gif;base64,"},
+		},
+		{
+			name:    "Remove",
+			images:  "remove",
+			want:    []string{"Logo:", "and again"},
+			exclude: []string{"![", "Example logo", "](https://example.com)"},
+		},
+		{
+			name:    "Alt text only",
+			images:  "alt_only",
+			want:    []string{"Logo: Example logo", "Pixel and again"},
+			exclude: []string{"![", "](https://example.com)"},
+		},
+		{
+			name:        "Extract data URIs",
+			images:      "extract",
+			want:        []string{"![Example logo](https://example.com/logo.png)", "![Pixel](image-", ".gif) and again ![](image-"},
+			exclude:     []string{"data:image"},
+			attachments: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(html, domain.ConversionOptions{Images: tt.images})
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			for _, substr := range tt.exclude {
+				if contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown not to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			if len(result.Attachments) != tt.attachments {
+				t.Fatalf("Expected %d attachments, got %d", tt.attachments, len(result.Attachments))
+			}
+			for _, file := range result.Attachments {
+				if file.MediaType != "image/gif" || file.Data != gif || !contains(result.Markdown, "("+file.Filename+")") {
+					t.Errorf("Unexpected attachment %+v", file)
+				}
+			}
+		})
+	}
+}
+
+func TestHTMLToMarkdownConverter_HiddenContent(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	html := `<p>Visible text</p>
+		<p hidden>Hidden attribute</p>
+		<div aria-hidden="true">Decorative</div>
+		<div style="color: red; display: none">Display none</div>
+		<div style="VISIBILITY:hidden !important">Visibility hidden</div>
+		<template><p>Template</p></template>
+		<p hidden="until-found">Searchable</p>
+		<p>Read more<span class="sr-only"> about pricing</span></p>
+		<p><a href="/close" title="Close dialog"><i class="icon-x" aria-hidden="true"></i></a></p>
+		<p id="share-label">Share this page</p>
+		<p><button aria-labelledby="share-label"><svg></svg></button></p>`
+
+	tests := []struct {
+		name    string
+		options domain.ConversionOptions
+		want    []string
+		exclude []string
+	}{
+		{
+			name:    "Hidden content is pruned",
+			options: domain.ConversionOptions{},
+			want:    []string{"Visible text", "Searchable", "Read more"},
+			exclude: []string{"Hidden attribute", "Decorative", "Display none", "Visibility hidden", "Template", "about pricing", "Share this page\n\nShare this page"},
+		},
+		{
+			name:    "Screen reader text can be kept",
+			options: domain.ConversionOptions{KeepSROnly: true},
+			want:    []string{"Read more about pricing"},
+			exclude: []string{"Decorative"},
+		},
+		{
+			name:    "Icon-only controls use their accessible name",
+			options: domain.ConversionOptions{PreferAriaLabel: true},
+			want:    []string{"[Close dialog](/close \"Close dialog\")", "Share this page\n\nShare this page"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(html, tt.options)
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			for _, substr := range tt.exclude {
+				if contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown not to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+		})
+	}
+}
+
+func TestHTMLToMarkdownConverter_Profiles(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	html := `<nav><a href="/">Home</a></nav>
+		<p><mark>Marked</mark>, <ins>added</ins>, <del>removed</del>, H<sub>2</sub>O and x<sup>2</sup>.</p>
+		<p>Press <kbd>Ctrl</kbd>.</p>`
+
+	tests := []struct {
+		name    string
+		options domain.ConversionOptions
+		want    []string
+		exclude []string
+	}{
+		{
+			name:    "Default uses extended syntax",
+			options: domain.ConversionOptions{},
+			want:    []string{"---\n\n[Home](/)\n\n---", "==Marked==, ++added++, ~~removed~~, H~2~O and x^2^.", "Press `Ctrl`."},
+		},
+		{
+			name:    "Strict CommonMark keeps inline HTML",
+			options: domain.ConversionOptions{Profile: "strict-commonmark"},
+			want:    []string{"<mark>Marked</mark>, <ins>added</ins>, <del>removed</del>, H<sub>2</sub>O and x<sup>2</sup>.", "Press <kbd>Ctrl</kbd>."},
+			exclude: []string{"---", "=="},
+		},
+		{
+			name:    "GFM keeps strikethrough",
+			options: domain.ConversionOptions{Profile: "gfm"},
+			want:    []string{"<mark>Marked</mark>, <ins>added</ins>, ~~removed~~, H<sub>2</sub>O"},
+		},
+		{
+			name:    "Pandoc sub and superscript",
+			options: domain.ConversionOptions{Profile: "pandoc"},
+			want:    []string{"<mark>Marked</mark>, <ins>added</ins>, ~~removed~~, H~2~O and x^2^."},
+		},
+		{
+			name:    "Obsidian highlights",
+			options: domain.ConversionOptions{Profile: "obsidian"},
+			want:    []string{"==Marked==, <ins>added</ins>, ~~removed~~, H<sub>2</sub>O"},
+		},
+		{
+			name: "Per-element overrides win over the profile",
+			options: domain.ConversionOptions{
+				Profile:  "obsidian",
+				Elements: map[string]string{"mark": "text", "del": "drop", "nav": "html", "kbd": "extended"},
+			},
+			want:    []string{"<nav>\n\n[Home](/)\n\n</nav>", "Marked, <ins>added</ins>, , H<sub>2</sub>O", "Press `Ctrl`."},
+			exclude: []string{"removed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(html, tt.options)
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			for _, substr := range tt.exclude {
+				if contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown not to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+		})
+	}
+}
+
+func TestHTMLToMarkdownConverter_Recipes(t *testing.T) {
+	site, err := recipe.Parse([]byte("name: docs\nmatch:\n  hosts: [docs.example.com]\nkeep: [article]\ndrop: [.edit]\noptions:\n  bullet_list_marker: '*'\n"))
+	if err != nil {
+		t.Fatalf("recipe.Parse() error = %v", err)
+	}
+	converter := NewHTMLToMarkdownConverter()
+	converter.UseRecipes(recipe.NewBook(site))
+
+	html := `<nav>Menu</nav><article><h1>Guide</h1><a class="edit" href="/edit">Edit</a><ul><li>Step</li></ul></article>`
+
+	result, err := converter.ConvertFrom(html, Source{URL: "https://docs.example.com/guide"}, domain.ConversionOptions{})
+	if err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if result.Recipe != "docs" || result.Markdown != "# Guide\n\n* Step" {
+		t.Errorf("Expected the docs recipe to apply, got %q: %q", result.Recipe, result.Markdown)
+	}
+
+	result, err = converter.ConvertFrom(html, Source{URL: "https://blog.example.com/"}, domain.ConversionOptions{})
+	if err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if result.Recipe != "" || !contains(result.Markdown, "Menu") {
+		t.Errorf("Expected no recipe for another host, got %q: %q", result.Recipe, result.Markdown)
+	}
+
+	if _, err := converter.ConvertFrom(html, Source{Recipe: "wiki"}, domain.ConversionOptions{}); err == nil {
+		t.Error("Expected an error for an unknown recipe")
+	}
+}
+
+func TestHTMLToMarkdownConverter_Forms(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	html := `<h2>Sign up</h2>
+		<form action="/signup" method="post" aria-label="Registration">
+			<p>All fields marked * are required.</p>
+			<label for="email">Email *</label> <input id="email" type="email" name="email" required>
+			<label>Password <input type="password" name="password" value="secret" required></label>
+			<label for="country">Country</label>
+			<select id="country" name="country">
+				<option value="de">Germany</option>
+				<option value="fr" selected>France</option>
+			</select>
+			<fieldset><legend>Plan</legend>
+				<label><input type="radio" name="plan" value="free" checked> Free</label>
+				<label><input type="radio" name="plan" value="pro"> Pro</label>
+			</fieldset>
+			<label><input type="checkbox" name="terms"> I accept the terms</label>
+			<button type="submit">Create account</button>
+		</form>
+		<p>Sort by <select aria-label="Sort order"><option>Newest</option><option>Oldest</option></select></p>`
+
+	tests := []struct {
+		name    string
+		forms   string
+		want    []string
+		exclude []string
+	}{
+		{
+			name:    "Controls are dropped by default",
+			forms:   "",
+			want:    []string{"## Sign up\n\nAll fields marked \\* are required.\n\nSort by"},
+			exclude: []string{"Email", "Password", "Germany", "Create account", "Plan", "terms"},
+		},
+		{
+			name:  "Forms are described",
+			forms: "describe",
+			want: []string{
+				"All fields marked \\* are required.",
+				"**Form**: Registration (POST /signup)",
+				"- **Email \\*** (email, required)\n- **Password** (password, required)\n- **Country** (select)\n  - Germany\n  - France (selected)\n- **Plan** (radio)\n  - Free (selected)\n  - Pro\n- **I accept the terms** (checkbox)\n- **Create account** (submit button)",
+				"Sort by **Sort order** (select, options: Newest, Oldest)",
+			},
+			exclude: []string{"secret"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(html, domain.ConversionOptions{Forms: tt.forms})
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			for _, substr := range tt.exclude {
+				if contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown not to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+		})
+	}
+
+	result, err := converter.Convert(`<table><tr><th>Done</th><th>Task</th><th>Owner</th></tr>
+		<tr><td><input type="checkbox" checked></td><td>Write</td><td>Ann</td></tr></table>`, domain.ConversionOptions{})
+	if err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	if want := "|  | Write | Ann |"; !contains(result.Markdown, want) {
+		t.Errorf("Expected the emptied cell to keep its column, got:\n%s", result.Markdown)
+	}
+}
+
+func TestHTMLToMarkdownConverter_SVG(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	html := `<p>Architecture:</p>
+		<svg viewBox="0 0 100 50"><title>Request flow</title><desc>Client to server</desc><text x="1" y="1">client</text><text x="50" y="1">server</text></svg>
+		<p>Chart: <canvas aria-label="Monthly sales">Sales went up</canvas></p>
+		<p>Logo <svg><text>ACME</text></svg></p>`
+
+	tests := []struct {
+		name        string
+		svg         string
+		want        []string
+		exclude     []string
+		attachments int
+	}{
+		{
+			name:    "Alt text by default",
+			svg:     "",
+			want:    []string{"Architecture:\n\nRequest flow: Client to server", "Chart: Monthly sales", "Monthly sales\n\nLogo"},
+			exclude: []string{"client", "ACME", "Sales went up"},
+		},
+		{
+			name:    "Drop",
+			svg:     "drop",
+			want:    []string{"Architecture:\n\nChart:\n\nLogo"},
+			exclude: []string{"Request flow", "Monthly sales", "ACME"},
+		},
+		{
+			name:        "Extract",
+			svg:         "extract",
+			want:        []string{"![Request flow: Client to server](image-", ".svg)", "Chart: Monthly sales", "Logo ![](image-"},
+			exclude:     []string{"ACME"},
+			attachments: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(html, domain.ConversionOptions{SVG: tt.svg})
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			for _, substr := range tt.exclude {
+				if contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown not to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			if result.Elements.Images != 3 {
+				t.Errorf("Expected 3 images to be counted, got %d", result.Elements.Images)
+			}
+			if len(result.Attachments) != tt.attachments {
+				t.Fatalf("Expected %d attachments, got %d", tt.attachments, len(result.Attachments))
+			}
+			for _, file := range result.Attachments {
+				data, _ := base64.StdEncoding.DecodeString(file.Data)
+				if file.MediaType != "image/svg+xml" || !contains(string(data), `xmlns="http://www.w3.org/2000/svg"`) {
+					t.Errorf("Unexpected attachment %+v: %s", file, data)
+				}
+			}
+		})
+	}
+}
+
+func TestHTMLToMarkdownConverter_CJKAndBidi(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	tests := []struct {
+		name    string
+		html    string
+		options domain.ConversionOptions
+		want    []string
+		exclude []string
+	}{
+		{
+			name:    "Line breaks between CJK characters",
+			html:    "<p>日本語の\n  テキストです。\n<b>次</b>\nの行</p><p>한국어\n문장</p><pre>コード\nの中</pre>",
+			want:    []string{"日本語のテキストです。**次**の行", "한국어\n문장", "コード\nの中"},
+			exclude: []string{"の\n", "。\n"},
+		},
+		{
+			name: "Inline markup next to CJK text",
+			html: `<p>これは<strong>強調</strong>です。<a href="/x">リンク</a>と<code>x</code>、<b>「引用」</b>です</p>`,
+			want: []string{"これは**強調**です。[リンク](/x)と`x`、<strong>「引用」</strong>です"},
+		},
+		{
+			name: "Underscore emphasis inside a word",
+			html: "<p>これは<em>強調</em>です</p>",
+			want: []string{"これは*強調*です"},
+		},
+		{
+			name: "Ruby annotations",
+			html: "<p><ruby>漢<rp>（</rp><rt>かん</rt><rp>）</rp>字<rp>（</rp><rt>じ</rt><rp>）</rp></ruby>と<ruby>東京<rt>とうきょう</rt></ruby></p>",
+			want: []string{"漢（かん）字（じ）と東京(とうきょう)"},
+		},
+		{
+			name:    "Ruby base text only",
+			html:    "<p><ruby>漢<rp>(</rp><rt>かん</rt><rp>)</rp>字<rt>じ</rt></ruby>を読む</p>",
+			options: domain.ConversionOptions{Ruby: "base"},
+			want:    []string{"漢字を読む"},
+			exclude: []string{"かん"},
+		},
+		{
+			name:    "Right-to-left text",
+			html:    `<p>English <span dir="rtl">שלום עולם</span> text</p><p dir="rtl">مرحبا <span dir="rtl">بالعالم</span></p>`,
+			want:    []string{"English \u2067שלום עולם\u2069 text", "<div dir=\"rtl\">\n\nمرحبا بالعالم\n\n</div>"},
+			exclude: []string{"\u2067بالعالم"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(tt.html, tt.options)
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%q", substr, result.Markdown)
+				}
+			}
+			for _, substr := range tt.exclude {
+				if contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown not to contain %q, got:\n%q", substr, result.Markdown)
+				}
+			}
+			if contains(result.Markdown, "\uE000") {
+				t.Errorf("Expected no joiners to be left, got:\n%q", result.Markdown)
+			}
+		})
+	}
+}
+
+func TestHTMLToMarkdownConverter_Normalization(t *testing.T) {
+	converter := NewHTMLToMarkdownConverter()
+
+	tests := []struct {
+		name    string
+		html    string
+		options domain.ConversionOptions
+		want    []string
+		exclude []string
+	}{
+		{
+			name: "Nested lists under wide markers",
+			html: "<ol start=9><li>nine</li><li>ten<ol><li>nested</li></ol></li></ol>",
+			want: []string{"9. nine\n10. ten\n    1. nested"},
+		},
+		{
+			name:    "Unneeded escapes",
+			html:    `<p>snake_case, a|b, C:\Users, [note] and <code>a_b</code></p><p>_emphasis_ and [1]</p><table><tr><th>a|b</th></tr><tr><td>x</td></tr></table>`,
+			want:    []string{"snake_case, a|b, C:\\Users, [note] and `a_b`", "\\_emphasis\\_ and [1]", "| a\\|b |"},
+			exclude: []string{"snake\\_case", "C:\\\\Users"},
+		},
+		{
+			name:    "Wrapped paragraphs, lists and quotes",
+			html:    "<p>The quick brown fox jumps over the lazy dog - <code>go build ./...</code> and more.</p><ul><li>A list item with enough words to wrap</li></ul><blockquote><p>A quote with enough words to wrap it</p></blockquote><h2>A heading that is never wrapped at all</h2>",
+			options: domain.ConversionOptions{WrapWidth: 30},
+			want: []string{
+				"The quick brown fox jumps over\nthe lazy dog -\n`go build ./...` and more.",
+				"- A list item with enough\n  words to wrap",
+				"> A quote with enough words to\n> wrap it",
+				"## A heading that is never wrapped at all",
+			},
+		},
+		{
+			name:    "CJK text is measured in columns",
+			html:    "<p>日本語の文章 English text</p>",
+			options: domain.ConversionOptions{WrapWidth: 20},
+			want:    []string{"日本語の文章 English\ntext"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := converter.Convert(tt.html, tt.options)
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			for _, substr := range tt.exclude {
+				if contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown not to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			if contains(result.Markdown, " \n") || contains(result.Markdown, "\t\n") {
+				t.Errorf("Expected no trailing whitespace, got %q", result.Markdown)
+			}
+		})
+	}
+}
+
+func TestHTMLToMarkdownConverter_Parse(t *testing.T) {
+	var parser domain.HTMLParser = NewHTMLToMarkdownConverter()
+	doc, err := parser.Parse(`<h2>Intro</h2><p>Read <a href="https://go.dev">the docs</a>.</p><ul><li>One</li><li>Two</li></ul>`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if stats := doc.GetStats(); stats.Headings != 1 || stats.Links != 1 || stats.Lists != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	var renderer domain.MarkdownRenderer = document.NewMarkdownRenderer()
+	markdown, err := renderer.Render(doc, domain.ConversionOptions{HeadingStyle: "setext", BulletListMarker: "+"})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if want := "Intro\n-----\n\nRead [the docs](https://go.dev).\n\n+ One\n+ Two"; markdown != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, markdown)
+	}
+}
+
+func TestHTMLToMarkdownConverter_SourceMap(t *testing.T) {
+	html := `<html><head><title>Doc</title></head><body><div><h1>Title</h1><h2>Setup</h2>` +
+		`<p>Hello <a href="/a">world</a>, this is a long paragraph.</p><ul><li>one</li><li>two</li></ul></div>` +
+		`<pre><code>x := 1</code></pre><p>Last</p></body></html>`
+	result, err := NewHTMLToMarkdownConverter().Convert(html, domain.ConversionOptions{SourceMap: true, TOC: true, FrontMatter: "yaml", WrapWidth: 20})
+	if err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	entries := result.Document.SourceMap(result.Markdown)
+	want := []struct {
+		markdown, path, html string
+	}{
+		{"# Title", "html > body > div > h1", "<h1>Title</h1>"},
+		{"## Setup", "html > body > div > h2", "<h2>Setup</h2>"},
+		{"Hello [world](/a),\nthis is a long\nparagraph.", "html > body > div > p", `<p>Hello <a href="/a">world</a>, this is a long paragraph.</p>`},
+		{"- one\n- two", "html > body > div > ul", "<ul><li>one</li><li>two</li></ul>"},
+		{"```\nx := 1\n```", "html > body > pre", "<pre><code>x := 1</code></pre>"},
+		{"Last", "html > body > p", "<p>Last</p>"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("Expected %d entries, got %+v", len(want), entries)
+	}
+	for i, w := range want {
+		e := entries[i]
+		if got := result.Markdown[e.Offset:e.EndOffset]; got != w.markdown {
+			t.Errorf("Entry %d: expected output %q, got %q", i, w.markdown, got)
+		}
+		if e.HTML == nil || e.HTML.Path != w.path || html[e.HTML.Offset:e.HTML.EndOffset] != w.html {
+			t.Errorf("Entry %d: expected %s at %q, got %+v", i, w.path, w.html, e.HTML)
+		}
+	}
+
+	// elements closed implicitly end where the tag that closes them starts
+	unclosed := `<p>One<p>Two<ul><li>a<li>b</ul><table><tr><td>1<td>2</table>`
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(unclosed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := indexHTMLSources(unclosed, doc)
+	for selector, want := range map[string][]string{"p": {"<p>One", "<p>Two"}, "li": {"<li>a", "<li>b"}, "td": {"<td>1", "<td>2"}} {
+		doc.Find(selector).Each(func(i int, s *goquery.Selection) {
+			source := sources[s.Get(0)]
+			if source.endOffset < source.offset || unclosed[source.offset:source.endOffset] != want[i] {
+				t.Errorf("Expected %s %d at %q, got %+v", selector, i, want[i], source)
+			}
+		})
+	}
+}
+
+func TestHTMLToMarkdownConverter_Flavors(t *testing.T) {
+	html := `<h1>Doc</h1><h2>Part</h2><p>Some <mark>marked</mark> and <del>old</del> text, <a href="/a">a link</a>.</p>` +
+		`<ul><li>one<ul><li>two</li></ul></li></ul><table><tr><th>A</th></tr><tr><td>1</td></tr></table>`
+	tests := []struct {
+		flavor string
+		want   []string
+	}{
+		{"commonmark", []string{"Some <mark>marked</mark> and <del>old</del> text", "<table>\n<thead>\n<tr>\n<th>A</th>"}},
+		{"multimarkdown", []string{"-   one\n    -   two", "| A |\n| --- |\n| 1 |"}},
+		{"pandoc", []string{"Some <mark>marked</mark> and ~~old~~ text", "| A |"}},
+		{"plain", []string{"Doc\n\nPart\n\nSome marked and old text, a link (/a).", "- one\n  - two", "A\n1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flavor, func(t *testing.T) {
+			result, err := NewHTMLToMarkdownConverter().Convert(html, domain.ConversionOptions{Flavor: tt.flavor, TOC: true})
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			for _, substr := range tt.want {
+				if !contains(result.Markdown, substr) {
+					t.Errorf("Expected markdown to contain %q, got:\n%s", substr, result.Markdown)
+				}
+			}
+			if tt.flavor == "plain" && contains(result.Markdown, "](#") {
+				t.Errorf("Expected no table of contents in plain text, got:\n%s", result.Markdown)
+			}
+		})
+	}
+}
+
+func TestMarkdownFormatter(t *testing.T) {
+	source := "---\ntitle: Notes\n---\nNotes\n=====\n\nSome *text* and __bold__, see [the docs][go].\n\n" +
+		"* one\n* two\n    + nested\n\n***\n\n    code\n\n1) first\n2) second\n\n[go]: https://go.dev\n"
+	result, err := NewMarkdownFormatter().Convert(source, domain.ConversionOptions{})
+	if err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	want := "---\ntitle: Notes\n---\n\n# Notes\n\nSome _text_ and **bold**, see [the docs](https://go.dev).\n\n" +
+		"- one\n- two\n  - nested\n\n* * *\n\n```\ncode\n```\n\n1) first\n2) second"
+	if result.Markdown != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, result.Markdown)
+	}
+	if result.Elements.Headings != 1 || result.Elements.Lists != 3 || result.Elements.Links != 1 {
+		t.Errorf("Unexpected elements %+v", result.Elements)
+	}
+
+	// formatting the output again changes nothing
+	again, err := NewMarkdownFormatter().Convert(result.Markdown, domain.ConversionOptions{})
+	if err != nil || again.Markdown != result.Markdown {
+		t.Errorf("Expected formatting to be idempotent, got:\n%s", again.Markdown)
+	}
+
+	result, err = NewMarkdownFormatter().ConvertBytes([]byte("# Caf\xe9\n\n- a\n- b"), domain.ConversionOptions{
+		Charset: "windows-1252", HeadingStyle: "setext", BulletListMarker: "*", LinkStyle: "referenced",
+	})
+	if err != nil {
+		t.Fatalf("ConvertBytes() error = %v", err)
+	}
+	if result.Markdown != "Café\n=====\n\n* a\n* b" || result.Charset != "windows-1252" {
+		t.Errorf("Unexpected result %q in %s", result.Markdown, result.Charset)
+	}
+
+	// adjacent lists that only differ in their marker stay apart
+	result, err = NewMarkdownFormatter().Convert("- x\n\n+ y\n\n1. a\n\n1) b\n", domain.ConversionOptions{})
+	if want := "- x\n\n<!-- -->\n\n- y\n\n1. a\n\n1) b"; err != nil || result.Markdown != want {
+		t.Errorf("Expected %q, got %q", want, result.Markdown)
+	}
+	if again, err := NewMarkdownFormatter().Convert(result.Markdown, domain.ConversionOptions{}); err != nil || again.Markdown != result.Markdown {
+		t.Errorf("Expected formatting to be idempotent, got %q", again.Markdown)
+	}
+
+	result, err = NewMarkdownFormatter().Convert("    x := 1\n    y := 2\n\nafter\n", domain.ConversionOptions{CodeBlockStyle: "indented"})
+	if err != nil || result.Markdown != "    x := 1\n    y := 2\n\nafter" {
+		t.Errorf("Expected the leading code block to stay indented, got %q", result.Markdown)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	for key, want := range map[string]string{"html": "html", "PDF": "pdf", "application/xhtml+xml": "html", ".htm": "html", "application/pdf": "pdf", ".md": "markdown", "text/markdown; charset=utf-8": "markdown"} {
+		if c, ok := registry.Lookup(key); !ok || c.Name() != want {
+			t.Errorf("Lookup(%q) should find the %s converter", key, want)
+		}
+	}
+	if _, ok := registry.Lookup("docx"); ok {
+		t.Error("Lookup(\"docx\") should find nothing")
+	}
+
+	for data, want := range map[string]string{"%PDF-1.7\n": "pdf", "<html><body>x</body></html>": "html", "  <section>x</section>": "html", "# Title\n\nText": "markdown", "---\ntitle: x\n---\n": "markdown"} {
+		if c := registry.Detect([]byte(data)); c == nil || c.Name() != want {
+			t.Errorf("Detect(%q) should find the %s converter", data, want)
+		}
+	}
+	if c := registry.Detect([]byte("just text")); c != nil {
+		t.Errorf("Detect should not recognize plain text, got %s", c.Name())
+	}
+
+	c, _ := registry.Lookup("html")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Convert(ctx, Input{Data: []byte("<p>x</p>"), Text: true}, domain.ConversionOptions{}); err == nil {
+		t.Error("Expected a cancelled context to abort the conversion")
+	}
+
+	// the registry's converters are shared by concurrent requests
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		options, want := domain.ConversionOptions{}, "==x=="
+		if i%2 == 1 {
+			options, want = domain.ConversionOptions{Elements: map[string]string{"mark": "html"}}, "<mark>x</mark>"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := c.Convert(context.Background(), Input{Data: []byte("<p><mark>x</mark></p>"), Text: true}, options)
+			if err != nil || result.Markdown != want {
+				t.Errorf("Expected %q for %v, got %v, %v", want, options.Elements, result, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && containsHelper(s, substr)
 }
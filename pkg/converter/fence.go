@@ -0,0 +1,44 @@
+package converter
+
+import "strings"
+
+// codeFence tracks whether a line-by-line scan of Markdown is inside a
+// fenced code block, so that text transformations can leave code alone.
+type codeFence struct {
+	char   byte
+	length int
+}
+
+// Inside reports whether line belongs to a fenced code block, counting the
+// opening and closing fence lines as part of the block.
+func (f *codeFence) Inside(line string) bool {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return f.length > 0
+	}
+
+	char, length := fenceRun(trimmed)
+	if f.length == 0 {
+		if length >= 3 && !(char == '`' && strings.ContainsRune(trimmed[length:], '`')) {
+			f.char, f.length = char, length
+			return true
+		}
+		return false
+	}
+
+	if char == f.char && length >= f.length && strings.TrimSpace(trimmed[length:]) == "" {
+		f.length = 0
+	}
+	return true
+}
+
+func fenceRun(line string) (byte, int) {
+	if line == "" || (line[0] != '`' && line[0] != '~') {
+		return 0, 0
+	}
+	n := 0
+	for n < len(line) && line[n] == line[0] {
+		n++
+	}
+	return line[0], n
+}
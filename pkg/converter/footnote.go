@@ -0,0 +1,136 @@
+package converter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// attrFootnoteRef marks the placeholder that replaces a footnote reference.
+const attrFootnoteRef = "data-any2md-footnote"
+
+// footnoteContainerSelector matches the sections that hold footnote
+// definitions in Wikipedia, Pandoc, Markdown renderers and DPUB-ARIA markup.
+const footnoteContainerSelector = ".footnotes, .footnote, .footnote-list, .references, .reflist, .endnotes, #footnotes, [role=doc-endnotes], [role=doc-footnote]"
+
+// footnoteBacklinkSelector matches the "jump back" links inside definitions.
+const footnoteBacklinkSelector = ".mw-cite-backlink, .footnote-back, .footnote-backref, .reversefootnote, [role=doc-backlink]"
+
+// footnote is a definition found in the document and the label it is
+// rendered with.
+type footnote struct {
+	label      string
+	definition *goquery.Selection
+}
+
+var footnoteLabelR = regexp.MustCompile(`[^\p{L}\p{N}_-]+`)
+
+// extractFootnotes replaces footnote references with placeholders and
+// detaches their definitions from the document, returning them in the
+// order they are first referenced.
+func extractFootnotes(doc *goquery.Document) []footnote {
+	var notes []footnote
+	byTarget := make(map[string]string)
+	usedLabels := make(map[string]bool)
+
+	doc.Find(`a[href^="#"]`).Each(func(i int, link *goquery.Selection) {
+		ref := footnoteReference(link)
+		if ref == nil {
+			return
+		}
+
+		id := strings.TrimPrefix(link.AttrOr("href", ""), "#")
+		label, seen := byTarget[id]
+		if !seen {
+			definition := footnoteDefinition(doc, id)
+			if definition == nil {
+				return
+			}
+
+			label = footnoteLabelR.ReplaceAllString(strings.TrimSpace(link.Text()), "")
+			if label == "" || usedLabels[label] {
+				label = strconv.Itoa(len(notes) + 1)
+				for usedLabels[label] {
+					label += "-" + strconv.Itoa(len(notes)+1)
+				}
+			}
+			usedLabels[label] = true
+			byTarget[id] = label
+			notes = append(notes, footnote{label: label, definition: definition})
+		}
+
+		ref.ReplaceWithHtml(`<sup ` + attrFootnoteRef + `="` + label + `"></sup>`)
+	})
+
+	for _, note := range notes {
+		container := note.definition.Parent()
+		note.definition.Remove()
+		removeEmptyAncestors(container)
+	}
+
+	return notes
+}
+
+// footnoteReference returns the element that makes up a footnote reference
+// (the <sup> around the link, or the link itself), or nil if the link is an
+// ordinary in-page link.
+func footnoteReference(link *goquery.Selection) *goquery.Selection {
+	if sup := link.ParentFiltered("sup"); sup.Length() > 0 && strings.TrimSpace(sup.Text()) == strings.TrimSpace(link.Text()) {
+		return sup
+	}
+	if link.ChildrenFiltered("sup").Length() > 0 || link.Is(".footnote-ref, [role=doc-noteref], [rel=footnote]") {
+		return link
+	}
+	return nil
+}
+
+// footnoteDefinition returns the element with the given id if it looks like
+// a footnote definition: a list item or anything inside a footnotes section.
+func footnoteDefinition(doc *goquery.Document, id string) *goquery.Selection {
+	if id == "" {
+		return nil
+	}
+
+	var target *goquery.Selection
+	doc.Find("[id]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if s.AttrOr("id", "") == id {
+			target = s
+			return false
+		}
+		return true
+	})
+	if target == nil || target.Is("h1, h2, h3, h4, h5, h6, body, html") {
+		return nil
+	}
+
+	if target.Is("li") || target.Is(footnoteContainerSelector) || target.Closest(footnoteContainerSelector).Length() > 0 {
+		return target
+	}
+	return nil
+}
+
+// removeEmptyAncestors removes containers that no longer hold any content
+// once their footnote definitions are gone.
+func removeEmptyAncestors(selec *goquery.Selection) {
+	for selec.Length() > 0 && !selec.Is("body, html") {
+		if strings.TrimSpace(selec.Text()) != "" || selec.Find("img").Length() > 0 {
+			return
+		}
+		parent := selec.Parent()
+		selec.Remove()
+		selec = parent
+	}
+}
+
+// removeBacklinks removes the links from a definition back to its
+// references.
+func removeBacklinks(definition *goquery.Selection) {
+	definition.Find(footnoteBacklinkSelector).Remove()
+	definition.Find(`a[href^="#"]`).Each(func(i int, link *goquery.Selection) {
+		if text := strings.TrimSpace(link.Text()); text == "^" || text == "↑" || text == "↩" || text == "↩︎" {
+			link.Remove()
+		}
+	})
+}
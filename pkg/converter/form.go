@@ -0,0 +1,297 @@
+package converter
+
+import (
+	"html"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/atom"
+)
+
+// Form modes accepted in ConversionOptions.Forms.
+const (
+	FormsDrop     = "drop"
+	FormsDescribe = "describe"
+)
+
+const controlSelector = "input, select, textarea, button, datalist, output, progress, meter"
+
+// buttonTypes are input types rendered as buttons.
+var buttonTypes = map[string]bool{"submit": true, "reset": true, "button": true, "image": true}
+
+// prepareForms removes form controls or, in describe mode, replaces each
+// form with a readable list of its fields. Buttons outside forms are left
+// alone in both modes: they are often labelled links in disguise.
+func prepareForms(doc *goquery.Document, mode string) {
+	if mode == FormsDescribe {
+		doc.Find("form").Each(func(i int, form *goquery.Selection) {
+			describeForm(doc, form)
+		})
+		doc.Find(controlSelector).Not("button").Each(func(i int, control *goquery.Selection) {
+			if control.Is("datalist") {
+				control.Remove()
+				return
+			}
+			label, details, _ := describeControl(doc, control)
+			removeLabels(doc, control)
+			controlTarget(control).ReplaceWithHtml("<strong>" + html.EscapeString(label) + "</strong> (" + html.EscapeString(strings.Join(details, ", ")) + ")")
+		})
+		return
+	}
+
+	doc.Find(controlSelector).Each(func(i int, control *goquery.Selection) {
+		if control.Is("button") && control.Closest("form").Length() == 0 {
+			return
+		}
+		// only the control goes: an emptied cell or item keeps its place
+		removeLabels(doc, control)
+		controlTarget(control).Remove()
+	})
+	doc.Find("fieldset").Each(func(i int, fieldset *goquery.Selection) {
+		if strings.TrimSpace(fieldset.Clone().Find("legend").Remove().End().Text()) == "" {
+			fieldset.Remove()
+		}
+	})
+	doc.Find("form").Each(func(i int, form *goquery.Selection) {
+		if strings.TrimSpace(form.Text()) == "" {
+			form.Remove()
+		}
+	})
+}
+
+// describeForm replaces the controls of a form with a list describing them
+// and keeps the rest of the form's content before it.
+func describeForm(doc *goquery.Document, form *goquery.Selection) {
+	var items []string
+	radioGroups := make(map[string]*radioGroup)
+
+	form.Find(controlSelector).Each(func(i int, control *goquery.Selection) {
+		if control.Is("datalist") {
+			return
+		}
+
+		if strings.EqualFold(control.AttrOr("type", ""), "radio") {
+			name := control.AttrOr("name", "")
+			group, ok := radioGroups[name]
+			if !ok {
+				// the item is filled in once all choices are known
+				group = &radioGroup{label: radioGroupLabel(control), item: len(items)}
+				radioGroups[name] = group
+				items = append(items, "")
+			}
+			choice := html.EscapeString(controlLabel(doc, control))
+			if _, checked := control.Attr("checked"); checked {
+				choice += " (selected)"
+			}
+			group.choices = append(group.choices, choice)
+			if _, required := control.Attr("required"); required {
+				group.required = true
+			}
+			return
+		}
+
+		label, details, options := describeControl(doc, control)
+		item := "<strong>" + html.EscapeString(label) + "</strong>"
+		if len(details) > 0 {
+			item += " (" + html.EscapeString(strings.Join(details, ", ")) + ")"
+		}
+		if len(options) > 0 {
+			item += "<ul><li>" + strings.Join(options, "</li><li>") + "</li></ul>"
+		}
+		items = append(items, item)
+	})
+
+	for _, group := range radioGroups {
+		details := "radio"
+		if group.required {
+			details += ", required"
+		}
+		items[group.item] = "<strong>" + html.EscapeString(group.label) + "</strong> (" + details + ")<ul><li>" + strings.Join(group.choices, "</li><li>") + "</li></ul>"
+	}
+
+	title := "<strong>Form</strong>"
+	if name := firstNonEmpty(form.AttrOr("aria-label", ""), form.AttrOr("title", ""), form.AttrOr("name", "")); name != "" {
+		title += ": " + html.EscapeString(name)
+	}
+	if action := strings.TrimSpace(form.AttrOr("action", "")); action != "" {
+		method := strings.ToUpper(firstNonEmpty(form.AttrOr("method", ""), "get"))
+		title += " (" + method + " " + html.EscapeString(action) + ")"
+	}
+
+	form.Find(controlSelector).Each(func(i int, control *goquery.Selection) {
+		removeLabels(doc, control)
+		controlTarget(control).Remove()
+	})
+	form.Find("legend").Remove()
+	form.Find("fieldset").Each(func(i int, fieldset *goquery.Selection) {
+		fieldset.Contents().Unwrap()
+	})
+
+	description := "<p>" + title + "</p>"
+	if len(items) > 0 {
+		description += "<ul><li>" + strings.Join(items, "</li><li>") + "</li></ul>"
+	}
+	form.AppendHtml(description)
+	form.Get(0).Data = "div"
+	form.Get(0).DataAtom = atom.Div
+}
+
+type radioGroup struct {
+	label    string
+	choices  []string
+	required bool
+	item     int
+}
+
+// radioGroupLabel names a group of radio buttons after the legend of the
+// fieldset around it, falling back to the shared name attribute.
+func radioGroupLabel(radio *goquery.Selection) string {
+	if legend := collapseSpace(radio.Closest("fieldset").Find("legend").First().Text()); legend != "" {
+		return legend
+	}
+	return firstNonEmpty(radio.AttrOr("name", ""), "Choice")
+}
+
+// describeControl returns the label of a control, its type and flags, and
+// the options of a select as list item HTML.
+func describeControl(doc *goquery.Document, control *goquery.Selection) (string, []string, []string) {
+	label := controlLabel(doc, control)
+	var details []string
+	var options []string
+
+	kind := goquery.NodeName(control)
+	switch kind {
+	case "input":
+		inputType := strings.ToLower(firstNonEmpty(control.AttrOr("type", ""), "text"))
+		if buttonTypes[inputType] {
+			details = append(details, inputType+" button")
+		} else {
+			details = append(details, inputType)
+		}
+		if _, checked := control.Attr("checked"); checked {
+			details = append(details, "checked")
+		}
+		if value := strings.TrimSpace(control.AttrOr("value", "")); value != "" && inputType != "password" && !buttonTypes[inputType] && inputType != "checkbox" {
+			details = append(details, `default: "`+value+`"`)
+		}
+	case "button":
+		details = append(details, strings.ToLower(firstNonEmpty(control.AttrOr("type", ""), "submit"))+" button")
+	case "select":
+		if _, multiple := control.Attr("multiple"); multiple {
+			details = append(details, "select, multiple")
+		} else {
+			details = append(details, "select")
+		}
+		control.Find("option").Each(func(i int, option *goquery.Selection) {
+			text := collapseSpace(option.Text())
+			if text == "" {
+				return
+			}
+			if group := option.ParentFiltered("optgroup").AttrOr("label", ""); group != "" {
+				text = group + ": " + text
+			}
+			text = html.EscapeString(text)
+			if _, selected := option.Attr("selected"); selected {
+				text += " (selected)"
+			}
+			options = append(options, text)
+		})
+	default:
+		details = append(details, kind)
+	}
+
+	if _, required := control.Attr("required"); required {
+		details = append(details, "required")
+	}
+	if _, disabled := control.Attr("disabled"); disabled {
+		details = append(details, "disabled")
+	}
+	if placeholder := strings.TrimSpace(control.AttrOr("placeholder", "")); placeholder != "" && placeholder != label {
+		details = append(details, `placeholder: "`+placeholder+`"`)
+	}
+
+	if kind == "select" && len(options) > 0 && !isInForm(control) {
+		// outside a form the options are listed inline
+		plain := make([]string, len(options))
+		for i, option := range options {
+			plain[i] = html.UnescapeString(option)
+		}
+		details = append(details, "options: "+strings.Join(plain, ", "))
+		options = nil
+	}
+
+	return label, details, options
+}
+
+func isInForm(control *goquery.Selection) bool {
+	return control.Closest("form").Length() > 0
+}
+
+// controlLabel finds the text that names a control.
+func controlLabel(doc *goquery.Document, control *goquery.Selection) string {
+	if id := control.AttrOr("id", ""); id != "" {
+		var label string
+		doc.Find("label[for]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+			if s.AttrOr("for", "") == id {
+				label = collapseSpace(s.Text())
+				return false
+			}
+			return true
+		})
+		if label != "" {
+			return label
+		}
+	}
+	if wrapper := control.Closest("label"); wrapper.Length() > 0 {
+		clone := wrapper.Clone()
+		clone.Find("select, textarea, datalist").Remove()
+		if label := collapseSpace(clone.Text()); label != "" {
+			return label
+		}
+	}
+	if label := accessibleName(doc, control); label != "" {
+		return label
+	}
+	if control.Is("button") {
+		if label := collapseSpace(control.Text()); label != "" {
+			return label
+		}
+	}
+	return firstNonEmpty(
+		control.AttrOr("value", ""),
+		control.AttrOr("placeholder", ""),
+		control.AttrOr("name", ""),
+		goquery.NodeName(control),
+	)
+}
+
+// controlTarget is the element that stands for a control in the document:
+// the label wrapping it, or the control itself.
+func controlTarget(control *goquery.Selection) *goquery.Selection {
+	if wrapper := control.Closest("label"); wrapper.Length() > 0 {
+		return wrapper
+	}
+	return control
+}
+
+// removeLabels removes the labels that point at a control by id.
+func removeLabels(doc *goquery.Document, control *goquery.Selection) {
+	id := control.AttrOr("id", "")
+	if id == "" {
+		return
+	}
+	doc.Find("label[for]").Each(func(i int, s *goquery.Selection) {
+		if s.AttrOr("for", "") == id && s.Find(controlSelector).Length() == 0 {
+			s.Remove()
+		}
+	})
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,185 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"any2md/internal/domain"
+)
+
+// Front matter formats accepted in ConversionOptions.FrontMatter.
+const (
+	FrontMatterYAML = "yaml"
+	FrontMatterTOML = "toml"
+	FrontMatterJSON = "json"
+)
+
+// frontMatterField is a top-level scalar or list field in a fixed order.
+type frontMatterField struct {
+	key   string
+	value interface{}
+}
+
+func frontMatterFields(meta *domain.Metadata) []frontMatterField {
+	fields := []frontMatterField{
+		{"title", meta.Title},
+		{"description", meta.Description},
+		{"author", meta.Author},
+		{"subject", meta.Subject},
+		{"keywords", meta.Keywords},
+		{"language", meta.Language},
+		{"canonical_url", meta.CanonicalURL},
+		{"creator", meta.Creator},
+		{"producer", meta.Producer},
+		{"created", meta.Created},
+		{"modified", meta.Modified},
+		{"pages", meta.Pages},
+	}
+
+	var present []frontMatterField
+	for _, field := range fields {
+		switch v := field.value.(type) {
+		case string:
+			if v == "" {
+				continue
+			}
+		case []string:
+			if len(v) == 0 {
+				continue
+			}
+		case int:
+			if v == 0 {
+				continue
+			}
+		}
+		present = append(present, field)
+	}
+	return present
+}
+
+// quote returns s as a double-quoted string that is valid in JSON, YAML
+// and TOML alike.
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = quote(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func scalar(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return quote(v)
+	case []string:
+		return quoteList(v)
+	case int:
+		return strconv.Itoa(v)
+	}
+	return quote(fmt.Sprint(value))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// renderFrontMatter serializes the metadata as a YAML, TOML or JSON front
+// matter block including its delimiters.
+func renderFrontMatter(meta *domain.Metadata, format string) (string, error) {
+	var b strings.Builder
+
+	switch format {
+	case FrontMatterYAML:
+		b.WriteString("---\n")
+		for _, field := range frontMatterFields(meta) {
+			b.WriteString(field.key + ": " + scalar(field.value) + "\n")
+		}
+		for _, table := range []struct {
+			key    string
+			values map[string]string
+		}{{"open_graph", meta.OpenGraph}, {"twitter", meta.Twitter}} {
+			if len(table.values) == 0 {
+				continue
+			}
+			b.WriteString(table.key + ":\n")
+			for _, k := range sortedKeys(table.values) {
+				b.WriteString("  " + quote(k) + ": " + quote(table.values[k]) + "\n")
+			}
+		}
+		if len(meta.JSONLD) > 0 {
+			// JSON is valid YAML flow syntax
+			data, err := json.Marshal(meta.JSONLD)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString("json_ld: " + string(data) + "\n")
+		}
+		b.WriteString("---")
+
+	case FrontMatterTOML:
+		b.WriteString("+++\n")
+		for _, field := range frontMatterFields(meta) {
+			b.WriteString(field.key + " = " + scalar(field.value) + "\n")
+		}
+		if len(meta.JSONLD) > 0 {
+			// TOML has no equivalent for arbitrary JSON, keep it as a string
+			data, err := json.Marshal(meta.JSONLD)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString("json_ld = " + quote(string(data)) + "\n")
+		}
+		for _, table := range []struct {
+			key    string
+			values map[string]string
+		}{{"open_graph", meta.OpenGraph}, {"twitter", meta.Twitter}} {
+			if len(table.values) == 0 {
+				continue
+			}
+			b.WriteString("\n[" + table.key + "]\n")
+			for _, k := range sortedKeys(table.values) {
+				b.WriteString(quote(k) + " = " + quote(table.values[k]) + "\n")
+			}
+		}
+		b.WriteString("+++")
+
+	case FrontMatterJSON:
+		data, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		b.Write(data)
+
+	default:
+		return "", fmt.Errorf("unsupported front matter format: %s", format)
+	}
+
+	return b.String(), nil
+}
+
+// prependFrontMatter puts the metadata block in front of the Markdown.
+// Without metadata or a format the Markdown is returned unchanged.
+func prependFrontMatter(markdown string, meta *domain.Metadata, format string) (string, error) {
+	if format == "" || meta == nil {
+		return markdown, nil
+	}
+
+	block, err := renderFrontMatter(meta, format)
+	if err != nil {
+		return "", err
+	}
+	return block + "\n\n" + markdown, nil
+}
@@ -0,0 +1,85 @@
+package converter
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"any2md/internal/domain"
+)
+
+// hiddenSelector matches elements that are never rendered. hidden="until-found"
+// content is searchable by the reader and is kept.
+const hiddenSelector = `template, [hidden]:not([hidden="until-found" i]), [aria-hidden="true" i], input[type="hidden" i]`
+
+// srOnlySelector matches the visually hidden helpers of common CSS
+// frameworks (Bootstrap, Tailwind, WordPress, HTML5 Boilerplate) that
+// carry text meant for screen readers only.
+const srOnlySelector = ".sr-only, .visually-hidden, .visuallyhidden, .screen-reader-text, .screenreader-only, .a11y-hidden, .assistive-text, .element-invisible"
+
+var hiddenStyleR = regexp.MustCompile(`(?i)(^|;)\s*(display\s*:\s*none|visibility\s*:\s*(hidden|collapse))\s*(!important)?\s*(;|$)`)
+
+// pruneHidden removes content a sighted reader wouldn't see: hidden and
+// aria-hidden elements, templates, inline display:none or visibility:hidden
+// styles and, unless KeepSROnly is set, screen-reader-only text. With
+// PreferAriaLabel, links and buttons left without text are labelled from
+// their aria-label, aria-labelledby or title.
+func pruneHidden(doc *goquery.Document, options domain.ConversionOptions) {
+	body := doc.Find("body")
+
+	// labels may point at hidden text, so resolve them first
+	labels := make(map[*goquery.Selection]string)
+	if options.PreferAriaLabel {
+		body.Find("a, button, [role=button], [role=link]").Each(func(i int, s *goquery.Selection) {
+			if label := accessibleName(doc, s); label != "" {
+				labels[s] = label
+			}
+		})
+	}
+
+	body.Find(hiddenSelector).Remove()
+	body.Find("[style]").Each(func(i int, s *goquery.Selection) {
+		if hiddenStyleR.MatchString(s.AttrOr("style", "")) {
+			s.Remove()
+		}
+	})
+	if !options.KeepSROnly {
+		body.Find(srOnlySelector).Remove()
+	}
+
+	for s, label := range labels {
+		if strings.TrimSpace(s.Text()) == "" {
+			s.SetText(label)
+		}
+	}
+}
+
+// accessibleName returns the explicit label of a control: its aria-label,
+// the text of the elements named by aria-labelledby, or, for controls
+// without an image, its title.
+func accessibleName(doc *goquery.Document, s *goquery.Selection) string {
+	if label := strings.TrimSpace(s.AttrOr("aria-label", "")); label != "" {
+		return label
+	}
+
+	var parts []string
+	for _, id := range strings.Fields(s.AttrOr("aria-labelledby", "")) {
+		doc.Find("[id]").EachWithBreak(func(i int, ref *goquery.Selection) bool {
+			if ref.AttrOr("id", "") != id {
+				return true
+			}
+			if text := collapseSpace(ref.Text()); text != "" {
+				parts = append(parts, text)
+			}
+			return false
+		})
+	}
+	if len(parts) > 0 {
+		return strings.Join(parts, " ")
+	}
+
+	if s.Find("img").Length() > 0 {
+		return ""
+	}
+	return strings.TrimSpace(s.AttrOr("title", ""))
+}
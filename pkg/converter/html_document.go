@@ -0,0 +1,730 @@
+package converter
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"any2md/internal/domain"
+	"any2md/pkg/document"
+)
+
+// blockElements are the elements that hold blocks: their content starts a
+// new paragraph and the text around them ends the previous one.
+var blockElements = map[string]bool{
+	"html": true, "body": true, "title": true, "p": true, "div": true, "section": true, "article": true,
+	"main": true, "center": true, "address": true, "hgroup": true, "search": true, "dialog": true,
+	"form": true, "fieldset": true, "legend": true, "figcaption": true, "summary": true, "li": true,
+	"dt": true, "dd": true, "caption": true, "thead": true, "tbody": true, "tfoot": true, "tr": true,
+	"td": true, "th": true, "nav": true, "aside": true, "header": true, "footer": true, "details": true,
+}
+
+// skippedElements have no content to convert.
+var skippedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "textarea": true, "template": true,
+}
+
+// lineMode is how the line breaks of inline content are written.
+type lineMode int
+
+const (
+	// linesKept keeps line breaks: source newlines as soft breaks, <br> as
+	// hard ones.
+	linesKept lineMode = iota
+	// linesJoined turns every break into a space, as in headings.
+	linesJoined
+	// linesInCell keeps the breaks between blocks and <br> only, which a
+	// table cell writes as <br>.
+	linesInCell
+)
+
+// whitespace is the whitespace waiting to be written before the next text,
+// from the weakest to the strongest.
+type whitespace int
+
+const (
+	noSpace whitespace = iota
+	spaceBreak
+	sourceBreak
+	blockBreak
+)
+
+// domFrame is the state of the container being converted.
+type domFrame struct {
+	blocks    []document.Block
+	paragraph []document.Inline
+	// target receives the inlines: the paragraph, or the content of the
+	// inline element being converted, inside the targets of outer
+	target *[]document.Inline
+	outer  []*[]document.Inline
+	// origin is the innermost element with a known source, which implicit
+	// paragraphs are located at
+	origin *html.Node
+	// depth counts the inline elements around the content; blocks inside
+	// them are flattened into their text
+	depth   int
+	lines   lineMode
+	space   whitespace
+	started bool
+	strong  bool
+	em      bool
+}
+
+// domBuilder builds the document tree of a page from its DOM, once the
+// conversion steps have prepared it. Whitespace is collapsed the way
+// browsers do, except that the line breaks of the source are kept.
+// Rendering options are left to the renderer; only the options that
+// choose what the content is (element modes, code) are used here.
+type domBuilder struct {
+	domFrame
+	options domain.ConversionOptions
+	modes   map[string]string
+	sources htmlSources
+}
+
+// buildHTMLDocument converts the prepared DOM of a page and the footnote
+// definitions extracted from it.
+func buildHTMLDocument(doc *goquery.Document, notes []footnote, options domain.ConversionOptions, sources htmlSources) *document.Document {
+	b := &domBuilder{options: options, modes: elementModes(options), sources: sources}
+	tree := &document.Document{}
+	for _, node := range doc.Nodes {
+		tree.Blocks = append(tree.Blocks, b.blocksOf(node)...)
+	}
+	for _, note := range notes {
+		removeBacklinks(note.definition)
+		var blocks []document.Block
+		for _, node := range note.definition.Nodes {
+			blocks = append(blocks, b.blocksOf(node)...)
+		}
+		tree.Footnotes = append(tree.Footnotes, &document.Footnote{Label: note.label, Blocks: blocks})
+	}
+	return tree
+}
+
+// blocksOf converts the content of n into blocks of their own.
+func (b *domBuilder) blocksOf(n *html.Node) []document.Block {
+	return b.collect(n, func() { b.children(n) })
+}
+
+// collect converts the nodes walked by fn into a new list of blocks.
+func (b *domBuilder) collect(n *html.Node, fn func()) []document.Block {
+	saved := b.domFrame
+	b.domFrame = domFrame{origin: b.originOf(n, saved.origin)}
+	b.target = &b.paragraph
+	fn()
+	b.flush()
+	blocks := b.blocks
+	b.domFrame = saved
+	return blocks
+}
+
+// inlinesOf converts the content of n into inline content of its own, such
+// as the text of a heading or a table cell.
+func (b *domBuilder) inlinesOf(n *html.Node, lines lineMode) []document.Inline {
+	saved := b.domFrame
+	var content []document.Inline
+	b.domFrame = domFrame{target: &content, origin: saved.origin, depth: 1, lines: lines}
+	b.children(n)
+	b.domFrame = saved
+	return trimInlines(content)
+}
+
+func (b *domBuilder) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.node(child)
+	}
+}
+
+func (b *domBuilder) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		b.text(n.Data)
+	case html.DocumentNode:
+		b.children(n)
+	case html.ElementNode:
+		b.element(n)
+	}
+}
+
+func (b *domBuilder) element(n *html.Node) {
+	if label, ok := attr(n, attrFootnoteRef); ok {
+		b.add(&document.FootnoteReference{Label: label})
+		return
+	}
+	if id, ok := attr(n, attrHeadingAnchor); ok {
+		b.html(n, `<a id="`+strings.ReplaceAll(id, `"`, "&quot;")+`"></a>`)
+		return
+	}
+	if dir, ok := attr(n, attrBidiDir); ok {
+		b.wrap(n, &document.HTMLBlock{HTML: `<div dir="` + dir + `">`}, &document.HTMLBlock{HTML: "</div>"})
+		return
+	}
+	if element, ok := semanticTags[n.Data]; ok {
+		b.semantic(n, element)
+		return
+	}
+
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		b.heading(n)
+	case "ul", "ol", "menu":
+		b.list(n)
+	case "pre":
+		b.codeBlock(n)
+	case "blockquote":
+		b.blockQuote(n)
+	case "table":
+		b.table(n)
+	case "figure":
+		b.figure(n)
+	case "dl":
+		b.definitions(n)
+	case "hr":
+		if b.depth == 0 {
+			b.addBlock(&document.ThematicBreak{}, n)
+		}
+	case "br":
+		b.lineBreak()
+	case "a":
+		b.link(n)
+	case "img":
+		b.image(n)
+	case "strong", "b":
+		b.emphasis(n, true)
+	case "em", "i":
+		b.emphasis(n, false)
+	case "code", "samp", "tt":
+		b.code(n)
+	case "video", "audio":
+		b.media(n)
+	case "iframe":
+		b.iframe(n)
+	case "summary":
+		if n.Parent != nil && n.Parent.Data == "details" && b.modes["details"] != ElementText {
+			// the summary is part of the details tag
+			return
+		}
+		b.container(n)
+	default:
+		if skippedElements[n.Data] {
+			return
+		}
+		if blockElements[n.Data] {
+			b.container(n)
+			return
+		}
+		b.children(n)
+	}
+}
+
+// container converts an element that holds blocks into the blocks of the
+// current container. Inside inline content its blocks become lines.
+func (b *domBuilder) container(n *html.Node) {
+	if b.depth > 0 {
+		b.pending(blockBreak)
+		b.children(n)
+		b.pending(blockBreak)
+		return
+	}
+	b.flush()
+	outer := b.origin
+	b.origin = b.originOf(n, outer)
+	b.children(n)
+	b.flush()
+	b.origin = outer
+}
+
+// wrap converts n into its blocks between open and close, or into nothing
+// when it has no content.
+func (b *domBuilder) wrap(n *html.Node, open, close document.Block) {
+	if b.depth > 0 {
+		b.container(n)
+		return
+	}
+	blocks := b.blocksOf(n)
+	if len(blocks) == 0 {
+		return
+	}
+	b.flush()
+	open.SetPosition(b.position(n))
+	close.SetPosition(b.position(n))
+	b.blocks = append(append(append(b.blocks, open), blocks...), close)
+}
+
+// wrapInline converts n into its inline content between open and close.
+func (b *domBuilder) wrapInline(n *html.Node, open, close document.Inline) {
+	content := b.span(n)
+	if len(content) == 0 {
+		return
+	}
+	b.push(open)
+	b.push(content...)
+	b.push(close)
+}
+
+// html adds raw HTML: a block of its own, or inline HTML inside inline
+// content.
+func (b *domBuilder) html(n *html.Node, raw string) {
+	if b.depth > 0 {
+		b.add(&document.RawHTML{Value: raw})
+		return
+	}
+	b.addBlock(&document.HTMLBlock{HTML: raw}, n)
+}
+
+// flush ends the paragraph being built.
+func (b *domBuilder) flush() {
+	if content := trimInlines(b.paragraph); len(content) > 0 {
+		paragraph := &document.Paragraph{Content: content}
+		paragraph.Pos = b.position(b.origin)
+		b.blocks = append(b.blocks, paragraph)
+	}
+	b.paragraph = nil
+	b.space, b.started = noSpace, false
+}
+
+// addBlock ends the paragraph being built and adds block, located at n.
+func (b *domBuilder) addBlock(block document.Block, n *html.Node) {
+	b.flush()
+	block.SetPosition(b.position(n))
+	b.blocks = append(b.blocks, block)
+}
+
+func (b *domBuilder) originOf(n, outer *html.Node) *html.Node {
+	if _, ok := b.sources[n]; ok {
+		return n
+	}
+	return outer
+}
+
+// position returns where n is in the HTML, when it was there before
+// conversion.
+func (b *domBuilder) position(n *html.Node) document.Position {
+	source, ok := b.sources[n]
+	if !ok {
+		return document.Position{}
+	}
+	return document.Position{Path: source.path, Offset: source.offset, EndOffset: source.endOffset}
+}
+
+// text adds the text of a text node, collapsing whitespace. Whitespace is
+// only written once text follows it, so that it never ends a block or
+// starts an element's content.
+func (b *domBuilder) text(text string) {
+	var buf strings.Builder
+	for _, r := range text {
+		switch r {
+		case ' ', '\t', '\f', '\r':
+			b.pending(spaceBreak)
+		case '\n':
+			b.pending(sourceBreak)
+		default:
+			if b.space != noSpace {
+				b.appendText(buf.String())
+				buf.Reset()
+				b.writeSpace()
+			}
+			buf.WriteRune(r)
+			b.started = true
+		}
+	}
+	b.appendText(buf.String())
+}
+
+func (b *domBuilder) pending(space whitespace) {
+	if space > b.space {
+		b.space = space
+	}
+}
+
+// writeSpace writes the pending whitespace, unless nothing was written
+// before it. Whitespace at the start of an element's content is written
+// before the element instead.
+func (b *domBuilder) writeSpace() {
+	space := b.space
+	b.space = noSpace
+	if space == noSpace || !b.started {
+		return
+	}
+	target := b.target
+	for i := len(b.outer) - 1; i >= 0 && len(*target) == 0; i-- {
+		target = b.outer[i]
+	}
+	if space == blockBreak && b.lines != linesJoined || space == sourceBreak && b.lines == linesKept {
+		if !endsWith(*target, isLineBreak) {
+			*target = append(*target, &document.LineBreak{})
+		}
+		return
+	}
+	if last, ok := lastInline(*target).(*document.Text); ok && last.Raw == "" {
+		if !strings.HasSuffix(last.Value, " ") {
+			last.Value += " "
+		}
+		return
+	}
+	*target = append(*target, &document.Text{Value: " "})
+}
+
+func (b *domBuilder) appendText(text string) {
+	if text == "" {
+		return
+	}
+	if last, ok := lastInline(*b.target).(*document.Text); ok && last.Raw == "" {
+		last.Value += text
+		return
+	}
+	*b.target = append(*b.target, &document.Text{Value: text})
+}
+
+// add adds an inline after the pending whitespace.
+func (b *domBuilder) add(inline document.Inline) {
+	b.writeSpace()
+	b.push(inline)
+}
+
+// push adds inlines right after the previous ones.
+func (b *domBuilder) push(inlines ...document.Inline) {
+	*b.target = append(*b.target, inlines...)
+	b.started = true
+}
+
+// span converts the content of an inline element.
+func (b *domBuilder) span(n *html.Node) []document.Inline {
+	var content []document.Inline
+	b.outer = append(b.outer, b.target)
+	b.target = &content
+	b.depth++
+	b.children(n)
+	b.depth--
+	b.target = b.outer[len(b.outer)-1]
+	b.outer = b.outer[:len(b.outer)-1]
+	return content
+}
+
+// lineBreak converts <br>. Two in a row end the paragraph.
+func (b *domBuilder) lineBreak() {
+	if b.lines != linesKept {
+		b.pending(blockBreak)
+		return
+	}
+	if endsWith(*b.target, isHardBreak) {
+		if b.depth == 0 {
+			b.paragraph = b.paragraph[:len(b.paragraph)-1]
+			b.flush()
+		}
+		return
+	}
+	if !b.started {
+		return
+	}
+	*b.target = append(*b.target, &document.LineBreak{Hard: true})
+	b.space, b.started = noSpace, false
+}
+
+func (b *domBuilder) heading(n *html.Node) {
+	if b.depth > 0 {
+		b.container(n)
+		return
+	}
+	content := b.inlinesOf(n, linesJoined)
+	if len(content) == 0 {
+		return
+	}
+	b.addBlock(&document.Heading{Level: int(n.Data[1] - '0'), Content: content}, n)
+}
+
+func (b *domBuilder) list(n *html.Node) {
+	if b.depth > 0 {
+		b.container(n)
+		return
+	}
+	list := &document.List{Ordered: n.Data == "ol", Start: 1, Tight: true}
+	if start, err := strconv.Atoi(strings.TrimSpace(attrOr(n, "start", ""))); err == nil && list.Ordered && start >= 0 {
+		list.Start = start
+	}
+
+	var item *document.ListItem
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == "li" {
+			item = &document.ListItem{Blocks: b.blocksOf(child)}
+			if len(item.Blocks) == 0 {
+				item = nil
+				continue
+			}
+			list.Items = append(list.Items, item)
+			continue
+		}
+		// content outside items, such as a nested list written directly
+		// in the list, belongs to the previous item
+		child := child
+		blocks := b.collect(n, func() { b.node(child) })
+		if len(blocks) == 0 {
+			continue
+		}
+		if item == nil {
+			item = &document.ListItem{}
+			list.Items = append(list.Items, item)
+		}
+		item.Blocks = append(item.Blocks, blocks...)
+	}
+	if len(list.Items) == 0 {
+		return
+	}
+
+	// blocks that follow each other in an item need a blank line, except
+	// for a nested list
+	for _, item := range list.Items {
+		for _, block := range item.Blocks[1:] {
+			if _, ok := block.(*document.List); !ok {
+				list.Tight = false
+			}
+		}
+	}
+	b.addBlock(list, n)
+}
+
+func (b *domBuilder) blockQuote(n *html.Node) {
+	if b.depth > 0 {
+		b.container(n)
+		return
+	}
+	if blocks := b.blocksOf(n); len(blocks) > 0 {
+		b.addBlock(&document.BlockQuote{Blocks: blocks}, n)
+	}
+}
+
+// figure converts a figure with its captions after its content, in italics.
+func (b *domBuilder) figure(n *html.Node) {
+	if b.depth > 0 {
+		b.container(n)
+		return
+	}
+	b.flush()
+	outer := b.origin
+	b.origin = b.originOf(n, outer)
+	var captions []*html.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == "figcaption" {
+			captions = append(captions, child)
+			continue
+		}
+		b.node(child)
+	}
+	b.flush()
+	for _, caption := range captions {
+		content := b.inlinesOf(caption, linesKept)
+		if len(content) == 0 {
+			continue
+		}
+		paragraph := &document.Paragraph{Content: []document.Inline{&document.Emphasis{Content: content}}}
+		paragraph.Pos = b.position(b.originOf(caption, b.origin))
+		b.blocks = append(b.blocks, paragraph)
+	}
+	b.origin = outer
+}
+
+// definitions converts a description list into a paragraph with a line
+// per term, in bold, and per description, after a colon.
+func (b *domBuilder) definitions(n *html.Node) {
+	if b.depth > 0 {
+		b.container(n)
+		return
+	}
+	var content []document.Inline
+	var walk func(*html.Node)
+	walk = func(parent *html.Node) {
+		for child := parent.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode || child.Data == "dl" {
+				continue
+			}
+			if child.Data != "dt" && child.Data != "dd" {
+				walk(child)
+				continue
+			}
+			text := b.inlinesOf(child, linesJoined)
+			if len(text) == 0 {
+				continue
+			}
+			if len(content) > 0 {
+				content = append(content, &document.LineBreak{})
+			}
+			if child.Data == "dt" {
+				content = append(content, &document.Emphasis{Strong: true, Content: text})
+			} else {
+				content = append(append(content, &document.Text{Value: ": "}), text...)
+			}
+		}
+	}
+	walk(n)
+	if len(content) > 0 {
+		b.addBlock(&document.Paragraph{Content: content}, n)
+	}
+}
+
+func (b *domBuilder) link(n *html.Node) {
+	href := strings.TrimSpace(attrOr(n, "href", ""))
+	if href == "" || href == "#" {
+		b.children(n)
+		return
+	}
+	title := strings.Join(strings.Fields(attrOr(n, "title", "")), " ")
+	content := b.span(n)
+	if len(content) == 0 {
+		label := firstNonEmpty(title, strings.TrimSpace(attrOr(n, "aria-label", "")))
+		if label == "" {
+			return
+		}
+		b.add(&document.Link{Destination: href, Title: title, Content: []document.Inline{&document.Text{Value: label}}})
+		return
+	}
+	b.push(&document.Link{Destination: href, Title: title, Content: content})
+}
+
+func (b *domBuilder) image(n *html.Node) {
+	src := strings.TrimSpace(attrOr(n, "src", ""))
+	if src == "" {
+		return
+	}
+	image := &document.Image{Source: src, Title: strings.Join(strings.Fields(attrOr(n, "title", "")), " ")}
+	if alt := strings.Join(strings.Fields(attrOr(n, "alt", "")), " "); alt != "" {
+		image.Alt = []document.Inline{&document.Text{Value: alt}}
+	}
+	b.add(image)
+}
+
+// emphasis converts bold and italic elements; the same emphasis nested in
+// itself is only kept once.
+func (b *domBuilder) emphasis(n *html.Node, strong bool) {
+	if strong && b.strong || !strong && b.em {
+		b.children(n)
+		return
+	}
+	outerStrong, outerEm := b.strong, b.em
+	if strong {
+		b.strong = true
+	} else {
+		b.em = true
+	}
+	content := b.span(n)
+	b.strong, b.em = outerStrong, outerEm
+	if len(content) > 0 {
+		b.push(&document.Emphasis{Strong: strong, Content: content})
+	}
+}
+
+// code converts inline code. With PreformattedCode, multi-line code keeps
+// its whitespace as a code block.
+func (b *domBuilder) code(n *html.Node) {
+	code := codeText(selection(n), true)
+	if b.options.PreformattedCode && b.depth == 0 && strings.Contains(strings.TrimSpace(code), "\n") {
+		b.addBlock(&document.CodeBlock{Language: codeLanguage(selection(n)), Code: code}, n)
+		return
+	}
+	code = strings.ReplaceAll(strings.Trim(code, "\n"), "\n", " ")
+	if strings.TrimSpace(code) != "" {
+		b.add(&document.Code{Value: code})
+	}
+}
+
+// media converts a video or audio element into a link to its source.
+func (b *domBuilder) media(n *html.Node) {
+	src, ok := attr(n, "src")
+	if !ok {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode && child.Data == "source" {
+				src = attrOr(child, "src", "")
+				break
+			}
+		}
+	}
+	if src == "" {
+		b.add(&document.Text{Value: "[" + n.Data + "]"})
+		return
+	}
+	b.add(&document.Link{Destination: src, Content: []document.Inline{&document.Text{Value: n.Data}}})
+}
+
+// iframe converts an iframe into a link to the embedded page.
+func (b *domBuilder) iframe(n *html.Node) {
+	src, ok := attr(n, "src")
+	if !ok {
+		return
+	}
+	title := attrOr(n, "title", "Embedded content")
+	b.add(&document.Link{Destination: src, Content: []document.Inline{&document.Text{Value: title}}})
+}
+
+// trimInlines drops the line breaks and spaces at the edges of inline
+// content.
+func trimInlines(inlines []document.Inline) []document.Inline {
+	for len(inlines) > 0 && isLineBreak(inlines[0]) {
+		inlines = inlines[1:]
+	}
+	for len(inlines) > 0 && isLineBreak(inlines[len(inlines)-1]) {
+		inlines = inlines[:len(inlines)-1]
+	}
+	if last, ok := lastInline(inlines).(*document.Text); ok && last.Raw == "" {
+		last.Value = strings.TrimRight(last.Value, " ")
+		if last.Value == "" {
+			inlines = inlines[:len(inlines)-1]
+		}
+	}
+	return inlines
+}
+
+func lastInline(inlines []document.Inline) document.Inline {
+	if len(inlines) == 0 {
+		return nil
+	}
+	return inlines[len(inlines)-1]
+}
+
+func endsWith(inlines []document.Inline, is func(document.Inline) bool) bool {
+	last := lastInline(inlines)
+	return last != nil && is(last)
+}
+
+func isLineBreak(inline document.Inline) bool {
+	_, ok := inline.(*document.LineBreak)
+	return ok
+}
+
+func isHardBreak(inline document.Inline) bool {
+	lineBreak, ok := inline.(*document.LineBreak)
+	return ok && lineBreak.Hard
+}
+
+func attr(n *html.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func attrOr(n *html.Node, name, fallback string) string {
+	if value, ok := attr(n, name); ok {
+		return value
+	}
+	return fallback
+}
+
+// nodeText returns the text of a node and its descendants.
+func nodeText(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+	var b strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(nodeText(child))
+	}
+	return b.String()
+}
+
+// selection wraps a node for the helpers that work on selections. The
+// node may be detached from the page, like footnote definitions.
+func selection(n *html.Node) *goquery.Selection {
+	return goquery.NewDocumentFromNode(n).Selection
+}
@@ -0,0 +1,146 @@
+package converter
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"any2md/internal/domain"
+)
+
+// Image policies accepted in ConversionOptions.Images.
+const (
+	ImagesKeep    = "keep"
+	ImagesRemove  = "remove"
+	ImagesAltOnly = "alt_only"
+	ImagesExtract = "extract"
+)
+
+// imageExtensions maps the media types of common images to the extension
+// used for their attachment filename.
+var imageExtensions = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+	"image/bmp":     ".bmp",
+	"image/avif":    ".avif",
+	"image/x-icon":  ".ico",
+	"image/tiff":    ".tiff",
+}
+
+// attachments collects extracted files, keeping one copy of identical data.
+type attachments struct {
+	files []domain.Attachment
+	seen  map[string]bool
+}
+
+// Add stores data and returns its filename, which is derived from a hash
+// of the content so the same image always gets the same name.
+func (a *attachments) Add(data []byte, mediaType string) string {
+	sum := sha256.Sum256(data)
+	ext, ok := imageExtensions[mediaType]
+	if !ok {
+		ext = ".bin"
+	}
+	name := "image-" + hex.EncodeToString(sum[:])[:16] + ext
+
+	if a.seen == nil {
+		a.seen = make(map[string]bool)
+	}
+	if !a.seen[name] {
+		a.seen[name] = true
+		a.files = append(a.files, domain.Attachment{
+			Filename:  name,
+			MediaType: mediaType,
+			Size:      len(data),
+			Data:      base64.StdEncoding.EncodeToString(data),
+		})
+	}
+	return name
+}
+
+// decodeDataURI returns the payload and media type of a data: URI.
+func decodeDataURI(uri string) ([]byte, string, bool) {
+	if !strings.HasPrefix(strings.ToLower(uri), "data:") {
+		return nil, "", false
+	}
+	header, payload, found := strings.Cut(uri[len("data:"):], ",")
+	if !found {
+		return nil, "", false
+	}
+
+	isBase64 := strings.HasSuffix(strings.ToLower(header), ";base64")
+	if isBase64 {
+		header = header[:len(header)-len(";base64")]
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if isBase64 {
+		payload = strings.Join(strings.Fields(payload), "")
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		}
+		if err != nil {
+			return nil, "", false
+		}
+		return data, mediaType, true
+	}
+
+	data, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, "", false
+	}
+	return []byte(data), mediaType, true
+}
+
+// prepareImages applies the image policy to the document. With remove and
+// alt_only the images are dropped or replaced by their alt text; with
+// extract, images embedded as data: URIs are returned as attachments and
+// their src is pointed at the attachment filename.
+func prepareImages(doc *goquery.Document, policy string, files *attachments) {
+	doc.Find("img").Each(func(i int, img *goquery.Selection) {
+		switch policy {
+		case ImagesRemove:
+			removeImage(img)
+		case ImagesAltOnly:
+			if alt := strings.TrimSpace(img.AttrOr("alt", "")); alt != "" {
+				img.ReplaceWithNodes(textNode(alt))
+			} else {
+				removeImage(img)
+			}
+		case ImagesExtract:
+			if data, mediaType, ok := decodeDataURI(strings.TrimSpace(img.AttrOr("src", ""))); ok {
+				img.SetAttr("src", files.Add(data, mediaType))
+			}
+		}
+	})
+
+	if policy == ImagesRemove || policy == ImagesAltOnly {
+		doc.Find("picture source").Remove()
+	}
+}
+
+// removeImage deletes an image along with the link around it when the image
+// was the only thing in it.
+func removeImage(img *goquery.Selection) {
+	link := img.Closest("a")
+	img.Remove()
+	if link.Length() > 0 && strings.TrimSpace(link.Text()) == "" && link.Find("img, svg, video, audio").Length() == 0 {
+		link.Remove()
+	}
+}
+
+func textNode(text string) *html.Node {
+	return &html.Node{Type: html.TextNode, Data: text}
+}
@@ -0,0 +1,140 @@
+package converter
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"any2md/internal/domain"
+	"any2md/pkg/document"
+)
+
+func init() {
+	Register(func() Converter { return &markdownConverter{NewMarkdownFormatter()} })
+}
+
+// markdownConverter registers the Markdown formatter.
+type markdownConverter struct {
+	*MarkdownFormatter
+}
+
+func (c *markdownConverter) Name() string { return "markdown" }
+
+func (c *markdownConverter) MIMETypes() []string { return []string{"text/markdown", "text/x-markdown"} }
+
+func (c *markdownConverter) Extensions() []string {
+	return []string{".md", ".markdown", ".mdown", ".mkd"}
+}
+
+func (c *markdownConverter) Binary() bool { return false }
+
+func (c *markdownConverter) MaxSize() int { return 10 * 1024 * 1024 }
+
+// Detect recognizes documents that start with front matter or an ATX
+// heading. Other Markdown reads like plain text and has to be sent with
+// its type.
+func (c *markdownConverter) Detect(data []byte) bool {
+	return markdownStartR.Match(data)
+}
+
+var markdownStartR = regexp.MustCompile(`^(?:\xef\xbb\xbf)?(?:---\r?\n|\+\+\+\r?\n|#{1,6}[ \t]+\S)`)
+
+func (c *markdownConverter) Convert(ctx context.Context, input Input, options domain.ConversionOptions) (*Result, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	if input.Text {
+		return c.MarkdownFormatter.Convert(string(input.Data), options)
+	}
+	return c.ConvertBytes(input.Data, options)
+}
+
+// MarkdownFormatter reads Markdown and renders it again with the
+// conversion options, like the Markdown of the other converters: it
+// formats hand-written Markdown the way converted documents are.
+type MarkdownFormatter struct {
+	renderer *document.MarkdownRenderer
+}
+
+func NewMarkdownFormatter() *MarkdownFormatter {
+	return &MarkdownFormatter{
+		renderer: document.NewMarkdownRenderer(),
+	}
+}
+
+// ConvertBytes formats Markdown in any charset, detected as for HTML
+// unless options.Charset is set.
+func (c *MarkdownFormatter) ConvertBytes(data []byte, options domain.ConversionOptions) (*Result, error) {
+	markdown, charset, err := decodeHTML(data, options.Charset)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.Convert(markdown, options)
+	if err != nil {
+		return nil, err
+	}
+	result.Charset = charset
+	return result, nil
+}
+
+// Convert formats Markdown. The style of the source is not kept: headings,
+// bullets and thematic breaks get the style of the options or the
+// defaults, and links the link style. Front matter is kept as it is.
+func (c *MarkdownFormatter) Convert(markdown string, options domain.ConversionOptions) (*Result, error) {
+	frontMatter, body := splitFrontMatter(strings.TrimPrefix(markdown, "\ufeff"))
+
+	tree := document.ParseMarkdown(body)
+	document.Walk(tree, func(block document.Block) {
+		switch b := block.(type) {
+		case *document.Heading:
+			b.Setext = false
+		case *document.List:
+			if !b.Ordered {
+				b.Marker = 0
+			}
+		case *document.ThematicBreak:
+			b.Marker = ""
+		}
+	})
+
+	markdown, err := c.renderer.Render(tree, options)
+	if err != nil {
+		return nil, err
+	}
+	markdown = normalizeMarkdown(markdown, options.WrapWidth)
+	if options.TOC && options.Flavor != document.FlavorPlain {
+		markdown = insertTableOfContents(markdown, options.BulletListMarker)
+	}
+	if frontMatter != "" {
+		markdown = frontMatter + "\n\n" + markdown
+	}
+
+	return &Result{
+		Markdown: markdown,
+		Document: tree,
+		Elements: tree.GetStats(),
+		Charset:  "utf-8",
+	}, nil
+}
+
+// splitFrontMatter separates a YAML (---) or TOML (+++) front matter
+// block from the Markdown that follows it.
+func splitFrontMatter(markdown string) (string, string) {
+	text := strings.ReplaceAll(markdown, "\r\n", "\n")
+	for _, fence := range []string{"---", "+++"} {
+		if !strings.HasPrefix(text, fence+"\n") {
+			continue
+		}
+		end := strings.Index(text[len(fence):], "\n"+fence+"\n")
+		if end < 0 {
+			if strings.HasSuffix(text, "\n"+fence) {
+				return text, ""
+			}
+			continue
+		}
+		end += len(fence) + len("\n"+fence)
+		return text[:end], text[end+1:]
+	}
+	return "", markdown
+}
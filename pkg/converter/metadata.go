@@ -0,0 +1,96 @@
+package converter
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"any2md/internal/domain"
+)
+
+// extractHTMLMetadata reads the document head: title, description and
+// author meta tags, Open Graph and Twitter cards, the canonical URL, the
+// document language and JSON-LD blocks. It returns nil when none is present.
+func extractHTMLMetadata(doc *goquery.Document) *domain.Metadata {
+	meta := &domain.Metadata{
+		Title:        collapseSpace(doc.Find("head title, title").First().Text()),
+		Description:  metaContent(doc, `meta[name="description" i]`),
+		Author:       metaContent(doc, `meta[name="author" i]`),
+		Language:     strings.TrimSpace(doc.Find("html").AttrOr("lang", "")),
+		CanonicalURL: strings.TrimSpace(doc.Find(`link[rel~="canonical" i]`).AttrOr("href", "")),
+		Created:      normalizeDate(metaContent(doc, `meta[property="article:published_time"], meta[name="dcterms.created" i], meta[name="date" i]`)),
+		Modified:     normalizeDate(metaContent(doc, `meta[property="article:modified_time"], meta[name="dcterms.modified" i], meta[name="last-modified" i]`)),
+	}
+
+	for _, keyword := range strings.Split(metaContent(doc, `meta[name="keywords" i]`), ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			meta.Keywords = append(meta.Keywords, keyword)
+		}
+	}
+
+	doc.Find("meta[property], meta[name]").Each(func(i int, s *goquery.Selection) {
+		key := strings.ToLower(strings.TrimSpace(s.AttrOr("property", s.AttrOr("name", ""))))
+		content := strings.TrimSpace(s.AttrOr("content", ""))
+		if content == "" {
+			return
+		}
+		switch {
+		case strings.HasPrefix(key, "og:"):
+			if meta.OpenGraph == nil {
+				meta.OpenGraph = make(map[string]string)
+			}
+			meta.OpenGraph[strings.TrimPrefix(key, "og:")] = content
+		case strings.HasPrefix(key, "twitter:"):
+			if meta.Twitter == nil {
+				meta.Twitter = make(map[string]string)
+			}
+			meta.Twitter[strings.TrimPrefix(key, "twitter:")] = content
+		}
+	})
+
+	doc.Find(`script[type="application/ld+json" i]`).Each(func(i int, s *goquery.Selection) {
+		var data interface{}
+		if err := json.Unmarshal([]byte(s.Text()), &data); err == nil {
+			meta.JSONLD = append(meta.JSONLD, data)
+		}
+	})
+
+	// fall back to the social cards for the basics
+	if meta.Title == "" {
+		meta.Title = meta.OpenGraph["title"]
+	}
+	if meta.Description == "" {
+		meta.Description = meta.OpenGraph["description"]
+	}
+	if meta.CanonicalURL == "" {
+		meta.CanonicalURL = meta.OpenGraph["url"]
+	}
+
+	if meta.IsEmpty() {
+		return nil
+	}
+	return meta
+}
+
+func metaContent(doc *goquery.Document, selector string) string {
+	return strings.TrimSpace(doc.Find(selector).First().AttrOr("content", ""))
+}
+
+func collapseSpace(text string) string {
+	return strings.TrimSpace(whitespaceR.ReplaceAllString(text, " "))
+}
+
+// normalizeDate converts the common date formats found in meta tags to
+// RFC 3339, leaving anything it can't parse untouched.
+func normalizeDate(value string) string {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02", time.RFC1123, time.RFC1123Z} {
+		if t, err := time.Parse(layout, value); err == nil {
+			if layout == "2006-01-02" {
+				return t.Format(layout)
+			}
+			return t.Format(time.RFC3339)
+		}
+	}
+	return value
+}
@@ -0,0 +1,429 @@
+package converter
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/width"
+)
+
+var (
+	listItemR     = regexp.MustCompile(`^( *)([-+*]|\d{1,9}[.)])( +|$)`)
+	delimiterRowR = regexp.MustCompile(`^ *\|? *:?-+:? *(\| *:?-+:? *)+\|? *$`)
+	definitionR   = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:`)
+	htmlStartR    = regexp.MustCompile(`^<[A-Za-z/!?]`)
+	bracketsR     = regexp.MustCompile(`\\\[([^\[\]\\]*)\\\]`)
+	wrapPrefixR   = regexp.MustCompile(`^((?:> ?)*)( *)((?:[-+*]|\d{1,9}[.)]) +(?:\[[ xX]\] +)?|\[\^[^\]]+\]: )?`)
+	blockStartR   = regexp.MustCompile("^(?:[-+*]|\\d{1,9}[.)]|#{1,6}|=+|-+|_+|\\*+|[>|<].*|[`~]{3}.*)$")
+)
+
+// listItem is an open list item during normalization: the indentation of
+// its marker and of its content in the source, and of its content after
+// re-indentation.
+type listItem struct {
+	indent        int
+	sourceContent int
+	content       int
+}
+
+// normalizeMarkdown is the last pass over the Markdown of both converters.
+// It keeps the output of successive conversions of a document stable:
+// trailing whitespace is removed, blank lines are collapsed and put around
+// headings and code blocks, nested list items are indented to the content
+// of their parent, escapes that Markdown doesn't need are dropped and, with
+// a positive wrapWidth, long lines are wrapped. Code blocks and HTML blocks
+// are left alone.
+func normalizeMarkdown(markdown string, wrapWidth int) string {
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+
+	definitions := make(map[string]bool)
+	for _, line := range lines {
+		if m := definitionR.FindStringSubmatch(line); m != nil {
+			definitions[strings.ToLower(m[1])] = true
+		}
+	}
+
+	var out []string
+	var fence codeFence
+	var items []listItem
+	fenceIndent, fenceShift := 0, 0
+	inHTML, inCode, needBlank, inTable := false, false, false, false
+
+	lastBlank := func() bool {
+		return len(out) == 0 || out[len(out)-1] == ""
+	}
+	emit := func(line string) {
+		if needBlank && !lastBlank() {
+			out = append(out, "")
+		}
+		needBlank = false
+		out = append(out, line)
+	}
+
+	for i, line := range lines {
+		if fence.length > 0 {
+			fence.Inside(strip(line, fenceIndent))
+			out = append(out, shift(line, fenceShift))
+			if fence.length == 0 && fenceIndent == 0 {
+				needBlank = true
+			}
+			continue
+		}
+
+		line = strings.TrimRight(expandIndent(line), " \t")
+		if line == "" {
+			inHTML, inTable = false, false
+			if !lastBlank() {
+				out = append(out, "")
+			}
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		if inCode || inHTML {
+			if inCode && indent < 4 {
+				inCode = false
+			} else {
+				emit(line)
+				continue
+			}
+		}
+		if len(items) == 0 && indent >= 4 && lastBlank() {
+			// an indented code block
+			inCode = true
+			emit(line)
+			continue
+		}
+
+		// re-indent list items to the content of their parent
+		shiftBy := 0
+		if m := listItemR.FindStringSubmatch(line); m != nil {
+			for len(items) > 0 && items[len(items)-1].indent >= indent {
+				items = items[:len(items)-1]
+			}
+			newIndent := 0
+			if len(items) > 0 {
+				newIndent = items[len(items)-1].content
+			}
+			// the spaces after the marker are kept, up to four, for the
+			// flavors that indent list content by four
+			space := m[3]
+			if len(space) == 0 || len(space) > 4 {
+				space = " "
+			}
+			items = append(items, listItem{
+				indent:        indent,
+				sourceContent: len(m[0]),
+				content:       newIndent + len(m[2]) + len(space),
+			})
+			rest := line[len(m[0]):]
+			line = strings.Repeat(" ", newIndent) + m[2]
+			if rest != "" {
+				line += space + rest
+			}
+		} else if indent > 0 && len(items) > 0 {
+			for len(items) > 0 && items[len(items)-1].indent >= indent {
+				items = items[:len(items)-1]
+			}
+			if len(items) > 0 {
+				item := items[len(items)-1]
+				shiftBy = item.content - item.sourceContent
+				if indent+shiftBy < item.content {
+					shiftBy = item.content - indent
+				}
+				line = shift(line, shiftBy)
+			}
+		} else if indent == 0 {
+			items = nil
+		}
+
+		if (indent < 4 || len(items) > 0) && fence.Inside(strings.TrimLeft(line, " ")) {
+			fenceIndent, fenceShift = indent, shiftBy
+			if fenceIndent == 0 && !lastBlank() {
+				needBlank = true
+			}
+			emit(line)
+			continue
+		}
+
+		trimmed := strings.TrimLeft(line, " ")
+		next := ""
+		if i+1 < len(lines) {
+			next = lines[i+1]
+		}
+		switch {
+		case htmlStartR.MatchString(trimmed):
+			inHTML = true
+			emit(line)
+			continue
+		case indent == 0 && atxHeadingR.MatchString(line):
+			needBlank = true
+			emit(minimizeEscapes(line, false, definitions))
+			needBlank = true
+			continue
+		}
+
+		quoted := strings.TrimLeft(trimmed, "> ")
+		if strings.HasPrefix(quoted, "|") || delimiterRowR.MatchString(next) {
+			inTable = true
+		}
+		line = minimizeEscapes(line, inTable, definitions)
+
+		if wrapWidth > 0 && !inTable && !setextUnderline.MatchString(next) && !definitionR.MatchString(line) {
+			for _, wrapped := range wrapLine(line, wrapWidth) {
+				emit(wrapped)
+			}
+			continue
+		}
+		emit(line)
+	}
+
+	return strings.Trim(strings.Join(out, "\n"), "\n")
+}
+
+// expandIndent replaces the tabs in the indentation of a line with spaces.
+func expandIndent(line string) string {
+	trimmed := strings.TrimLeft(line, " \t")
+	indent := line[:len(line)-len(trimmed)]
+	if !strings.Contains(indent, "\t") {
+		return line
+	}
+	return strings.ReplaceAll(indent, "\t", "    ") + trimmed
+}
+
+// strip removes up to n spaces from the start of line.
+func strip(line string, n int) string {
+	for n > 0 && strings.HasPrefix(line, " ") {
+		line = line[1:]
+		n--
+	}
+	return line
+}
+
+// shift indents line by n more spaces, or removes up to -n spaces.
+func shift(line string, n int) string {
+	if line == "" {
+		return line
+	}
+	if n >= 0 {
+		return strings.Repeat(" ", n) + line
+	}
+	return strip(line, -n)
+}
+
+// minimizeEscapes drops the backslashes the converter put before characters
+// that can't be read as Markdown where they are: underscores inside words,
+// pipes outside tables, brackets that don't form a link and backslashes
+// before characters that can't be escaped. Code spans are left alone.
+func minimizeEscapes(line string, inTable bool, definitions map[string]bool) string {
+	var b strings.Builder
+	for _, part := range splitCodeSpans(line) {
+		if part.code {
+			b.WriteString(part.text)
+			continue
+		}
+		b.WriteString(unescapeBrackets(unescape(part.text, inTable), definitions))
+	}
+	return b.String()
+}
+
+type span struct {
+	text string
+	code bool
+}
+
+// splitCodeSpans splits a line into text and `code` spans.
+func splitCodeSpans(line string) []span {
+	var spans []span
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '`':
+			n := 1
+			for i+n < len(line) && line[i+n] == '`' {
+				n++
+			}
+			closing := closingRun(line[i+n:], n)
+			if closing < 0 {
+				i += n - 1
+				continue
+			}
+			end := i + n + closing + n
+			if i > start {
+				spans = append(spans, span{text: line[start:i]})
+			}
+			spans = append(spans, span{text: line[i:end], code: true})
+			start = end
+			i = end - 1
+		}
+	}
+	if start < len(line) {
+		spans = append(spans, span{text: line[start:]})
+	}
+	return spans
+}
+
+// closingRun finds a run of exactly n backticks in s.
+func closingRun(s string, n int) int {
+	for i := 0; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(s) && s[j] == '`' {
+			j++
+		}
+		if j-i == n {
+			return i
+		}
+		i = j
+	}
+	return -1
+}
+
+func unescape(text string, inTable bool) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' || i+1 >= len(text) {
+			b.WriteByte(text[i])
+			continue
+		}
+		c := text[i+1]
+		switch {
+		case c == '\\' && i+2 < len(text) && !isASCIIPunct(text[i+2]) && text[i+2] != ' ':
+			b.WriteByte('\\')
+		case c == '_' && isWordRune(lastRune(b.String())) && isWordRune(firstRune(text[i+2:])):
+			b.WriteByte('_')
+		case c == '|' && !inTable:
+			b.WriteByte('|')
+		default:
+			b.WriteString(text[i : i+2])
+		}
+		i++
+	}
+	return b.String()
+}
+
+// unescapeBrackets drops the escapes of [text] that is not followed by a
+// link destination, reference or definition colon and is not a footnote
+// reference, a task list checkbox or a defined reference label.
+func unescapeBrackets(text string, definitions map[string]bool) string {
+	return replaceAllSubmatchFunc(bracketsR, text, func(match string, groups []string, end int) string {
+		inner := groups[1]
+		label := strings.ToLower(strings.TrimSpace(inner))
+		if label == "" || label == "x" || strings.HasPrefix(inner, "^") || definitions[label] {
+			return match
+		}
+		if end < len(text) && strings.ContainsRune("([:", rune(text[end])) {
+			return match
+		}
+		return "[" + inner + "]"
+	})
+}
+
+func replaceAllSubmatchFunc(re *regexp.Regexp, text string, replace func(match string, groups []string, end int) string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(text, -1) {
+		groups := make([]string, len(loc)/2)
+		for g := range groups {
+			if loc[2*g] >= 0 {
+				groups[g] = text[loc[2*g]:loc[2*g+1]]
+			}
+		}
+		b.WriteString(text[last:loc[0]])
+		b.WriteString(replace(text[loc[0]:loc[1]], groups, loc[1]))
+		last = loc[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func isASCIIPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+// wrapLine breaks a line at spaces so that its parts are at most width
+// columns wide, repeating the blockquote markers of the line and indenting
+// the parts under the content of a list item. It never starts a part with
+// something that would begin a new block, and keeps code spans whole.
+func wrapLine(line string, width int) []string {
+	prefix := wrapPrefixR.FindString(line)
+	quote := wrapPrefixR.FindStringSubmatch(line)[1]
+	continuation := quote + strings.Repeat(" ", displayWidth(prefix)-displayWidth(quote))
+	if displayWidth(line) <= width || atxHeadingR.MatchString(line[len(quote):]) || strings.HasPrefix(strings.TrimLeft(line[len(quote):], " "), "|") {
+		return []string{line}
+	}
+
+	// words are separated by spaces outside code spans
+	var words []string
+	glued := false
+	for _, part := range splitCodeSpans(line[len(prefix):]) {
+		fields := []string{part.text}
+		if !part.code {
+			fields = strings.Split(part.text, " ")
+		}
+		for j, field := range fields {
+			if j == 0 && glued {
+				words[len(words)-1] += field
+			} else {
+				words = append(words, field)
+			}
+		}
+		glued = !strings.HasSuffix(part.text, " ")
+	}
+
+	var wrapped []string
+	current := prefix
+	empty := true
+	for _, word := range words {
+		if word == "" {
+			continue
+		}
+		if !empty && displayWidth(current)+1+displayWidth(word) > width && !blockStartR.MatchString(word) && !strings.HasSuffix(current, "\\") {
+			wrapped = append(wrapped, current)
+			current = continuation
+			empty = true
+		}
+		if !empty {
+			current += " "
+		}
+		current += word
+		empty = false
+	}
+	return append(wrapped, current)
+}
+
+// displayWidth counts the columns text takes in a monospace font, where
+// East Asian wide characters take two.
+func displayWidth(text string) int {
+	n := 0
+	for _, r := range text {
+		switch width.LookupRune(r).Kind() {
+		case width.EastAsianWide, width.EastAsianFullwidth:
+			n += 2
+		default:
+			n++
+		}
+	}
+	return n
+}
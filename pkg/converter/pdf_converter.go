@@ -2,32 +2,87 @@ package converter
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"image/png"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/unidoc/unipdf/v3/core"
 	"github.com/unidoc/unipdf/v3/extractor"
 	"github.com/unidoc/unipdf/v3/model"
 	"any2md/internal/domain"
+	"any2md/pkg/document"
 	"any2md/pkg/errors"
 )
 
-type PDFToMarkdownConverter struct{}
+func init() {
+	Register(func() Converter { return &pdfConverter{NewPDFToMarkdownConverter()} })
+}
+
+// pdfConverter registers the PDF converter.
+type pdfConverter struct {
+	*PDFToMarkdownConverter
+}
+
+func (c *pdfConverter) Name() string { return "pdf" }
+
+func (c *pdfConverter) MIMETypes() []string { return []string{"application/pdf"} }
+
+func (c *pdfConverter) Extensions() []string { return []string{".pdf"} }
+
+func (c *pdfConverter) Binary() bool { return true }
+
+func (c *pdfConverter) MaxSize() int { return 50 * 1024 * 1024 }
+
+// Detect looks for the %PDF- header, which readers accept anywhere in the
+// first kilobyte.
+func (c *pdfConverter) Detect(data []byte) bool {
+	if len(data) > 1024 {
+		data = data[:1024]
+	}
+	return bytes.Contains(data, []byte("%PDF-"))
+}
+
+func (c *pdfConverter) Convert(ctx context.Context, input Input, options domain.ConversionOptions) (*Result, error) {
+	return c.ConvertContext(ctx, input.Data, options)
+}
+
+type PDFToMarkdownConverter struct {
+	renderer *document.MarkdownRenderer
+}
 
 func NewPDFToMarkdownConverter() *PDFToMarkdownConverter {
-	return &PDFToMarkdownConverter{}
+	return &PDFToMarkdownConverter{
+		renderer: document.NewMarkdownRenderer(),
+	}
 }
 
-func (c *PDFToMarkdownConverter) Convert(pdfData []byte, options domain.ConversionOptions) (string, domain.ElementsCount, error) {
+func (c *PDFToMarkdownConverter) Convert(pdfData []byte, options domain.ConversionOptions) (*Result, error) {
+	return c.ConvertContext(context.Background(), pdfData, options)
+}
+
+// ConvertContext converts a PDF, giving up between pages once ctx is done.
+func (c *PDFToMarkdownConverter) ConvertContext(ctx context.Context, pdfData []byte, options domain.ConversionOptions) (*Result, error) {
 	if len(pdfData) == 0 {
-		return "", domain.ElementsCount{}, errors.NewValidationError("PDF content cannot be empty")
+		return nil, errors.NewValidationError("PDF content cannot be empty")
+	}
+	if options.Images == ImagesKeep || options.Images == ImagesAltOnly {
+		// PDF images have neither a URL nor alt text: they are dropped or
+		// extracted
+		allowed := []string{ImagesRemove, ImagesExtract}
+		err := errors.NewValidationError(fmt.Sprintf("invalid value %q for option images with PDF content, expected one of: %s", options.Images, strings.Join(allowed, ", ")))
+		err.Details["option"] = "images"
+		err.Details["allowed"] = allowed
+		return nil, err
 	}
 
 	// Parse PDF
 	reader := bytes.NewReader(pdfData)
 	pdfReader, err := model.NewPdfReader(reader)
 	if err != nil {
-		return "", domain.ElementsCount{}, errors.NewParsingError("Failed to parse PDF", map[string]interface{}{
+		return nil, errors.NewParsingError("Failed to parse PDF", map[string]interface{}{
 			"error": err.Error(),
 		})
 	}
@@ -35,14 +90,14 @@ func (c *PDFToMarkdownConverter) Convert(pdfData []byte, options domain.Conversi
 	// Check if PDF is encrypted
 	isEncrypted, err := pdfReader.IsEncrypted()
 	if err != nil {
-		return "", domain.ElementsCount{}, errors.NewInternalError("Failed to check PDF encryption: " + err.Error())
+		return nil, errors.NewInternalError("Failed to check PDF encryption: " + err.Error())
 	}
 
 	if isEncrypted {
 		// Try to decrypt with empty password
 		success, err := pdfReader.Decrypt([]byte(""))
 		if err != nil || !success {
-			return "", domain.ElementsCount{}, errors.NewParsingError("PDF is encrypted and cannot be read", map[string]interface{}{
+			return nil, errors.NewParsingError("PDF is encrypted and cannot be read", map[string]interface{}{
 				"encrypted": true,
 			})
 		}
@@ -50,14 +105,17 @@ func (c *PDFToMarkdownConverter) Convert(pdfData []byte, options domain.Conversi
 
 	numPages, err := pdfReader.GetNumPages()
 	if err != nil {
-		return "", domain.ElementsCount{}, errors.NewInternalError("Failed to get PDF page count: " + err.Error())
+		return nil, errors.NewInternalError("Failed to get PDF page count: " + err.Error())
 	}
 
-	var textContent strings.Builder
-	stats := domain.ElementsCount{}
+	tree := &document.Document{}
+	var files attachments
 
 	// Extract text from each page
 	for pageNum := 1; pageNum <= numPages; pageNum++ {
+		if err := checkContext(ctx); err != nil {
+			return nil, err
+		}
 		page, err := pdfReader.GetPage(pageNum)
 		if err != nil {
 			continue // Skip problematic pages
@@ -68,59 +126,208 @@ func (c *PDFToMarkdownConverter) Convert(pdfData []byte, options domain.Conversi
 			continue
 		}
 
-		text, err := ex.ExtractText()
+		pageText, _, _, err := ex.ExtractPageText()
 		if err != nil {
 			continue
 		}
+		text := pageText.Text()
 
 		// Process page text
-		processedText := c.processPageText(text, &stats)
-		textContent.WriteString(processedText)
+		blocks := c.processPageText(text, options, pageNum)
+		if options.SourceMap {
+			locatePageBlocks(blocks, text, pageText.Marks())
+		}
+		if options.Images == ImagesExtract {
+			blocks = append(blocks, c.extractPageImages(ex, &files, pageNum)...)
+		}
 		
 		// Add page separator for multi-page documents
-		if pageNum < numPages && strings.TrimSpace(processedText) != "" {
-			textContent.WriteString("\n\n---\n\n")
+		if len(tree.Blocks) > 0 && len(blocks) > 0 {
+			separator := &document.ThematicBreak{Marker: "---"}
+			separator.Pos.Page = pageNum
+			tree.Blocks = append(tree.Blocks, separator)
 		}
+		tree.Blocks = append(tree.Blocks, blocks...)
+	}
+
+	tree.Metadata = pdfMetadata(pdfReader, numPages)
+	markdown, err := c.renderer.Render(tree, options)
+	if err != nil {
+		return nil, err
 	}
+	markdown = c.postProcess(markdown, options)
+	markdown, err = prependFrontMatter(markdown, tree.Metadata, options.FrontMatter)
+	if err != nil {
+		return nil, errors.NewInternalError("Failed to render front matter: " + err.Error())
+	}
+	
+	return &Result{
+		Markdown:    markdown,
+		Document:    tree,
+		Elements:    tree.GetStats(),
+		Metadata:    tree.Metadata,
+		Attachments: files.files,
+	}, nil
+}
 
-	markdown := c.postProcess(textContent.String(), options)
+// extractPageImages stores the image XObjects drawn on a page as PNG
+// attachments and returns the paragraphs that reference them.
+func (c *PDFToMarkdownConverter) extractPageImages(ex *extractor.Extractor, files *attachments, pageNum int) []document.Block {
+	pageImages, err := ex.ExtractPageImages(nil)
+	if err != nil {
+		return nil
+	}
 	
-	return markdown, stats, nil
+	var blocks []document.Block
+	for _, mark := range pageImages.Images {
+		img, err := mark.Image.ToGoImage()
+		if err != nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			continue
+		}
+		paragraph := &document.Paragraph{Content: []document.Inline{&document.Image{Source: files.Add(buf.Bytes(), "image/png")}}}
+		paragraph.Pos.Page = pageNum
+		paragraph.Pos.BBox = []float64{mark.X, mark.Y, mark.X + mark.Width, mark.Y + mark.Height}
+		blocks = append(blocks, paragraph)
+	}
+	return blocks
 }
 
-func (c *PDFToMarkdownConverter) processPageText(text string, stats *domain.ElementsCount) string {
+// processPageText turns the text of a page into blocks: code for indented
+// runs when asked to, and headings, list items and paragraphs guessed from
+// the shape of each line. Consecutive list items form a single list.
+func (c *PDFToMarkdownConverter) processPageText(text string, options domain.ConversionOptions, pageNum int) []document.Block {
 	lines := strings.Split(text, "\n")
-	var processedLines []string
+	var blocks []document.Block
+	var list *document.List
+	add := func(block document.Block) {
+		blocks = append(blocks, block)
+		list = nil
+	}
 	
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+	for i := 0; i < len(lines); i++ {
+		// Keep indented runs verbatim as code when asked to
+		if options.PreformattedCode && isIndentedLine(lines[i]) {
+			end := i + 1
+			for end < len(lines) && (isIndentedLine(lines[end]) || strings.TrimSpace(lines[end]) == "" && end+1 < len(lines) && isIndentedLine(lines[end+1])) {
+				end++
+			}
+			code := &document.CodeBlock{Code: dedentLines(lines[i:end])}
+			code.Pos = document.Position{Page: pageNum, Line: i + 1, EndLine: end}
+			add(code)
+			i = end - 1
+			continue
+		}
+		
+		line := strings.TrimSpace(lines[i])
 		if line == "" {
 			continue
 		}
+		pos := document.Position{Page: pageNum, Line: i + 1, EndLine: i + 1}
 		
 		// Try to identify headings based on formatting cues
 		if c.isLikelyHeading(line) {
 			// Determine heading level based on text characteristics
-			level := c.determineHeadingLevel(line)
-			line = strings.Repeat("#", level) + " " + line
-			stats.Headings++
+			heading := &document.Heading{Level: c.determineHeadingLevel(line), Content: textWithLinks(line)}
+			heading.Pos = pos
+			add(heading)
 		} else if c.isLikelyListItem(line) {
 			// Convert to markdown list item
-			line = "- " + strings.TrimLeft(line, "•·-*▪▫‣⁃")
-			line = strings.TrimSpace(line)
-			if !strings.HasPrefix(line, "- ") {
-				line = "- " + line
+			line = strings.TrimSpace(strings.TrimLeft(line, "•·-*▪▫‣⁃"))
+			if list == nil {
+				list = &document.List{}
+				list.Pos = pos
+				blocks = append(blocks, list)
 			}
-			stats.Lists++
+			list.Pos.EndLine = pos.EndLine
+			paragraph := &document.Paragraph{Content: textWithLinks(line)}
+			paragraph.Pos = pos
+			list.Items = append(list.Items, &document.ListItem{Blocks: []document.Block{paragraph}})
 		} else {
 			// Regular paragraph
-			stats.Paragraphs++
+			paragraph := &document.Paragraph{Content: textWithLinks(line)}
+			paragraph.Pos = pos
+			add(paragraph)
+		}
+	}
+	
+	return blocks
+}
+
+// locatePageBlocks sets the bounding box of the blocks of a page from the
+// marks of the characters of the lines they were made of.
+func locatePageBlocks(blocks []document.Block, text string, marks *extractor.TextMarkArray) {
+	lines := strings.Split(text, "\n")
+	starts := make([]int, len(lines)+1)
+	for i, line := range lines {
+		starts[i+1] = starts[i] + len(line) + 1
+	}
+
+	for _, block := range blocks {
+		pos := block.Position()
+		if pos.Line == 0 || pos.EndLine > len(lines) {
+			continue
+		}
+		span, err := marks.RangeOffset(starts[pos.Line-1], starts[pos.EndLine]-1)
+		if err != nil {
+			continue
+		}
+		if box, ok := span.BBox(); ok {
+			pos.BBox = []float64{box.Llx, box.Lly, box.Urx, box.Ury}
+			block.SetPosition(pos)
+		}
+	}
+}
+
+// textWithLinks splits a line of extracted text around the URLs it
+// contains, which become bare links.
+func textWithLinks(line string) []document.Inline {
+	var inlines []document.Inline
+	last := 0
+	for _, loc := range urlRegex.FindAllStringIndex(line, -1) {
+		if loc[0] > last {
+			inlines = append(inlines, &document.Text{Value: line[last:loc[0]]})
+		}
+		inlines = append(inlines, &document.Link{Destination: line[loc[0]:loc[1]], Bare: true})
+		last = loc[1]
+	}
+	if last < len(line) {
+		inlines = append(inlines, &document.Text{Value: line[last:]})
+	}
+	return inlines
+}
+
+// isIndentedLine reports whether an extracted line starts with a tab or at
+// least four spaces, which in PDF text usually means preformatted code.
+func isIndentedLine(line string) bool {
+	return strings.TrimSpace(line) != "" && (strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "    "))
+}
+
+// dedentLines joins lines after removing the indentation they all share.
+func dedentLines(lines []string) string {
+	indent := -1
+	for _, line := range lines {
+		line = strings.ReplaceAll(line, "\t", "    ")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if n := len(line) - len(strings.TrimLeft(line, " ")); indent < 0 || n < indent {
+			indent = n
 		}
-		
-		processedLines = append(processedLines, line)
 	}
 	
-	return strings.Join(processedLines, "\n\n")
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		line = strings.TrimRight(strings.ReplaceAll(line, "\t", "    "), " ")
+		if len(line) >= indent && indent > 0 {
+			line = line[indent:]
+		}
+		result[i] = line
+	}
+	return strings.Join(result, "\n")
 }
 
 func (c *PDFToMarkdownConverter) isLikelyHeading(text string) bool {
@@ -185,85 +392,55 @@ func (c *PDFToMarkdownConverter) isLikelyListItem(text string) bool {
 	return false
 }
 
+// postProcess normalizes the rendered Markdown and adds the table of
+// contents.
 func (c *PDFToMarkdownConverter) postProcess(markdown string, options domain.ConversionOptions) string {
-	// Clean up multiple consecutive newlines
-	re := regexp.MustCompile(`\n{3,}`)
-	markdown = re.ReplaceAllString(markdown, "\n\n")
-	
-	// Apply custom options if specified
-	if options.HeadingStyle == "setext" {
-		markdown = c.convertToSetextHeadings(markdown)
-	}
+	markdown = strings.TrimSpace(normalizeMarkdown(markdown, options.WrapWidth))
 	
-	if options.BulletListMarker != "" && options.BulletListMarker != "-" {
-		re = regexp.MustCompile(`^- `)
-		lines := strings.Split(markdown, "\n")
-		for i, line := range lines {
-			if re.MatchString(line) {
-				lines[i] = options.BulletListMarker + " " + line[2:]
-			}
-		}
-		markdown = strings.Join(lines, "\n")
+	if options.TOC && options.Flavor != document.FlavorPlain {
+		markdown = insertTableOfContents(markdown, options.BulletListMarker)
 	}
 	
-	// Remove excessive whitespace
-	markdown = strings.TrimSpace(markdown)
-	
 	return markdown
 }
 
-func (c *PDFToMarkdownConverter) convertToSetextHeadings(markdown string) string {
-	lines := strings.Split(markdown, "\n")
-	var result []string
-	
-	for _, line := range lines {
-		if strings.HasPrefix(line, "# ") {
-			// H1 -> Setext style with =
-			title := strings.TrimPrefix(line, "# ")
-			result = append(result, title)
-			result = append(result, strings.Repeat("=", len(title)))
-		} else if strings.HasPrefix(line, "## ") {
-			// H2 -> Setext style with -
-			title := strings.TrimPrefix(line, "## ")
-			result = append(result, title)
-			result = append(result, strings.Repeat("-", len(title)))
-		} else {
-			result = append(result, line)
-		}
-	}
-	
-	return strings.Join(result, "\n")
-}
+var urlRegex = regexp.MustCompile(`https?://[^\s<>()\[\]]+[^\s<>()\[\].,;:!?'"]`)
 
-// PDFInfo extracts basic information about the PDF
-func (c *PDFToMarkdownConverter) PDFInfo(pdfData []byte) (map[string]interface{}, error) {
-	reader := bytes.NewReader(pdfData)
-	pdfReader, err := model.NewPdfReader(reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse PDF: %w", err)
-	}
+// pdfMetadata reads the document Info dictionary. Page count is included
+// so the metadata is never empty for a readable PDF.
+func pdfMetadata(pdfReader *model.PdfReader, numPages int) *domain.Metadata {
+	meta := &domain.Metadata{Pages: numPages}
 
-	numPages, _ := pdfReader.GetNumPages()
-	isEncrypted, _ := pdfReader.IsEncrypted()
-	
-	info := map[string]interface{}{
-		"pages":     numPages,
-		"encrypted": isEncrypted,
-		"size":      len(pdfData),
+	pdfInfo, err := pdfReader.GetPdfInfo()
+	if err != nil || pdfInfo == nil {
+		return meta
 	}
-	
-	// Try to get PDF metadata
-	if pdfInfo, err := pdfReader.GetPdfInfo(); err == nil && pdfInfo != nil {
-		if title := pdfInfo.Title; title != nil {
-			info["title"] = title.String()
+
+	text := func(s *core.PdfObjectString) string {
+		if s == nil {
+			return ""
 		}
-		if author := pdfInfo.Author; author != nil {
-			info["author"] = author.String()
+		return strings.TrimSpace(s.Decoded())
+	}
+	date := func(d *model.PdfDate) string {
+		if d == nil {
+			return ""
 		}
-		if subject := pdfInfo.Subject; subject != nil {
-			info["subject"] = subject.String()
+		return d.ToGoTime().Format(time.RFC3339)
+	}
+
+	meta.Title = text(pdfInfo.Title)
+	meta.Author = text(pdfInfo.Author)
+	meta.Subject = text(pdfInfo.Subject)
+	meta.Creator = text(pdfInfo.Creator)
+	meta.Producer = text(pdfInfo.Producer)
+	meta.Created = date(pdfInfo.CreationDate)
+	meta.Modified = date(pdfInfo.ModifiedDate)
+	for _, keyword := range strings.FieldsFunc(text(pdfInfo.Keywords), func(r rune) bool { return r == ',' || r == ';' }) {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			meta.Keywords = append(meta.Keywords, keyword)
 		}
 	}
-	
-	return info, nil
-}
\ No newline at end of file
+
+	return meta
+}
@@ -0,0 +1,192 @@
+package converter
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"any2md/internal/domain"
+	"any2md/pkg/document"
+)
+
+// Element modes accepted in ConversionOptions.Elements.
+const (
+	ElementDrop     = "drop"
+	ElementText     = "text"
+	ElementHTML     = "html"
+	ElementExtended = "extended"
+)
+
+// Profiles accepted in ConversionOptions.Profile.
+const (
+	ProfileStrictCommonMark = "strict-commonmark"
+	ProfileGFM              = "gfm"
+	ProfilePandoc           = "pandoc"
+	ProfileObsidian         = "obsidian"
+)
+
+// profiles choose how each semantic element is rendered for a Markdown
+// dialect. Elements a profile doesn't list use the extended syntax.
+var profiles = map[string]map[string]string{
+	ProfileStrictCommonMark: {
+		"mark": ElementHTML, "ins": ElementHTML, "del": ElementHTML, "sub": ElementHTML, "sup": ElementHTML,
+		"kbd": ElementHTML, "nav": ElementText, "aside": ElementText, "header": ElementText, "footer": ElementText,
+	},
+	ProfileGFM: {
+		"mark": ElementHTML, "ins": ElementHTML, "sub": ElementHTML, "sup": ElementHTML,
+		"kbd": ElementHTML, "nav": ElementText, "aside": ElementText, "header": ElementText, "footer": ElementText,
+	},
+	ProfilePandoc: {
+		"mark": ElementHTML, "ins": ElementHTML,
+		"nav": ElementText, "aside": ElementText, "header": ElementText, "footer": ElementText,
+	},
+	ProfileObsidian: {
+		"ins": ElementHTML, "sub": ElementHTML, "sup": ElementHTML,
+		"nav": ElementText, "aside": ElementText, "header": ElementText, "footer": ElementText,
+	},
+}
+
+// flavorProfiles are the profiles used for the flavors when none is asked
+// for. Plain text renders every element as text.
+var flavorProfiles = map[string]string{
+	document.FlavorCommonMark:    ProfileStrictCommonMark,
+	document.FlavorMultiMarkdown: ProfileStrictCommonMark,
+	document.FlavorPandoc:        ProfilePandoc,
+}
+
+// semanticElement is an element whose rendering can be chosen per request.
+// extended converts it with the Markdown extension syntax used for it by
+// default.
+type semanticElement struct {
+	name     string
+	tags     []string
+	block    bool
+	extended func(b *domBuilder, n *html.Node)
+}
+
+// wrapWith writes the content of an element between two markers.
+func wrapWith(marker string) func(b *domBuilder, n *html.Node) {
+	return func(b *domBuilder, n *html.Node) {
+		b.wrapInline(n, &document.Text{Value: marker, Raw: marker}, &document.Text{Value: marker, Raw: marker})
+	}
+}
+
+// sectionRule sets the content of a section apart between thematic breaks.
+func sectionRule(b *domBuilder, n *html.Node) {
+	b.wrap(n, &document.ThematicBreak{Marker: "---"}, &document.ThematicBreak{Marker: "---"})
+}
+
+var semanticElements = []semanticElement{
+	{name: "nav", tags: []string{"nav"}, block: true, extended: sectionRule},
+	{name: "aside", tags: []string{"aside"}, block: true, extended: sectionRule},
+	{name: "header", tags: []string{"header"}, block: true, extended: sectionRule},
+	{name: "footer", tags: []string{"footer"}, block: true, extended: sectionRule},
+	{name: "abbr", tags: []string{"abbr", "acronym"}, extended: func(b *domBuilder, n *html.Node) {
+		b.children(n)
+		if title := strings.TrimSpace(attrOr(n, "title", "")); title != "" {
+			b.text(" (" + title + ")")
+		}
+	}},
+	{name: "details", tags: []string{"details"}, block: true, extended: func(b *domBuilder, n *html.Node) {
+		b.wrap(n, &document.HTMLBlock{HTML: "<details>" + summaryTag(n)}, &document.HTMLBlock{HTML: "</details>"})
+	}},
+	{name: "mark", tags: []string{"mark"}, extended: wrapWith("==")},
+	{name: "ins", tags: []string{"ins"}, extended: wrapWith("++")},
+	{name: "del", tags: []string{"del", "s", "strike"}, extended: wrapWith("~~")},
+	{name: "sub", tags: []string{"sub"}, extended: wrapWith("~")},
+	{name: "sup", tags: []string{"sup"}, extended: wrapWith("^")},
+	{name: "kbd", tags: []string{"kbd"}, extended: func(b *domBuilder, n *html.Node) {
+		if text := strings.Join(strings.Fields(nodeText(n)), " "); text != "" {
+			b.add(&document.Code{Value: text})
+		}
+	}},
+}
+
+// semanticTags maps the tags of the semantic elements to them.
+var semanticTags = make(map[string]semanticElement)
+
+func init() {
+	for _, element := range semanticElements {
+		for _, tag := range element.tags {
+			semanticTags[tag] = element
+		}
+	}
+}
+
+// summaryTag renders the summary of a details element on a line of its
+// own, or nothing when it has none.
+func summaryTag(details *html.Node) string {
+	for child := details.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == "summary" {
+			if text := strings.Join(strings.Fields(nodeText(child)), " "); text != "" {
+				return "\n<summary>" + html.EscapeString(text) + "</summary>"
+			}
+		}
+	}
+	return ""
+}
+
+// elementModes resolves the mode of every semantic element: extended
+// syntax, or text for plain text, then the profile, which defaults to the
+// one of the flavor, then per-element overrides.
+func elementModes(options domain.ConversionOptions) map[string]string {
+	modes := make(map[string]string, len(semanticElements))
+	for _, element := range semanticElements {
+		modes[element.name] = ElementExtended
+		if options.Flavor == document.FlavorPlain {
+			modes[element.name] = ElementText
+		}
+	}
+	profile := options.Profile
+	if profile == "" {
+		profile = flavorProfiles[options.Flavor]
+	}
+	for name, mode := range profiles[profile] {
+		modes[name] = mode
+	}
+	for name, mode := range options.Elements {
+		if _, ok := modes[name]; ok {
+			modes[name] = mode
+		}
+	}
+	return modes
+}
+
+// semantic converts a semantic element in the mode chosen for it.
+func (b *domBuilder) semantic(n *html.Node, element semanticElement) {
+	switch b.modes[element.name] {
+	case ElementDrop:
+	case ElementText:
+		if element.block {
+			b.container(n)
+		} else {
+			b.children(n)
+		}
+	case ElementHTML:
+		open, close := openTag(n), "</"+n.Data+">"
+		if element.block {
+			if n.Data == "details" {
+				open += summaryTag(n)
+			}
+			b.wrap(n, &document.HTMLBlock{HTML: open}, &document.HTMLBlock{HTML: close})
+		} else {
+			b.wrapInline(n, &document.RawHTML{Value: open}, &document.RawHTML{Value: close})
+		}
+	default:
+		element.extended(b, n)
+	}
+}
+
+// openTag renders the start tag of an element with its source attributes,
+// leaving out the ones added during conversion.
+func openTag(n *html.Node) string {
+	var b strings.Builder
+	b.WriteString("<" + n.Data)
+	for _, attr := range n.Attr {
+		if strings.HasPrefix(attr.Key, "data-any2md-") || attr.Key == "data-index" || strings.HasPrefix(attr.Key, "data-converter-") {
+			continue
+		}
+		b.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+	}
+	b.WriteString(">")
+	return b.String()
+}
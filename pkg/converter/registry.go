@@ -0,0 +1,146 @@
+package converter
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"any2md/internal/domain"
+	"any2md/pkg/errors"
+)
+
+// Input is a document handed to a Converter.
+type Input struct {
+	Data []byte
+	// Text is set when Data is already UTF-8 text (a JSON string) rather
+	// than raw bytes in the document's own encoding
+	Text   bool
+	Source Source
+}
+
+// Converter turns one input format into Markdown. Converters are
+// registered with Register and looked up by name, MIME type, file
+// extension or content.
+type Converter interface {
+	// Name is the request type the converter handles, e.g. "html".
+	Name() string
+	MIMETypes() []string
+	// Extensions are the file extensions of the format, with their dot.
+	Extensions() []string
+	// Detect reports whether data looks like the format, from its first bytes.
+	Detect(data []byte) bool
+	// Binary formats can't be sent as JSON strings and always arrive
+	// base64 encoded.
+	Binary() bool
+	// MaxSize is the largest input accepted, in bytes.
+	MaxSize() int
+	Convert(ctx context.Context, input Input, options domain.ConversionOptions) (*Result, error)
+}
+
+var (
+	factoriesMu sync.Mutex
+	factories   []func() Converter
+)
+
+// Register makes a converter available to every registry created
+// afterwards. Converters call it from an init function, so adding a
+// format takes no change outside its own file.
+func Register(factory func() Converter) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories = append(factories, factory)
+}
+
+// Registry holds one instance of every registered converter.
+type Registry struct {
+	converters []Converter
+	byKey      map[string]Converter
+}
+
+// NewRegistry instantiates the registered converters. Names, MIME types
+// and extensions are matched case-insensitively; the first converter
+// registered for one of them wins.
+func NewRegistry() *Registry {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	r := &Registry{byKey: make(map[string]Converter)}
+	for _, factory := range factories {
+		c := factory()
+		r.converters = append(r.converters, c)
+		keys := append([]string{c.Name()}, c.MIMETypes()...)
+		for _, key := range append(keys, c.Extensions()...) {
+			key = strings.ToLower(key)
+			if _, ok := r.byKey[key]; !ok {
+				r.byKey[key] = c
+			}
+		}
+	}
+	return r
+}
+
+// Lookup finds a converter by name, MIME type or file extension.
+func (r *Registry) Lookup(key string) (Converter, bool) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if i := strings.IndexByte(key, ';'); i >= 0 {
+		// MIME type parameters
+		key = strings.TrimSpace(key[:i])
+	}
+	c, ok := r.byKey[key]
+	return c, ok
+}
+
+// Detect returns the first converter that recognizes data, or nil.
+func (r *Registry) Detect(data []byte) Converter {
+	for _, c := range r.converters {
+		if c.Detect(data) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Converters returns the converters in registration order.
+func (r *Registry) Converters() []Converter {
+	return append([]Converter(nil), r.converters...)
+}
+
+// Names returns the sorted names of the converters.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.converters))
+	for _, c := range r.converters {
+		names = append(names, c.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Capabilities describes the converters for clients.
+func (r *Registry) Capabilities() []domain.Capability {
+	capabilities := make([]domain.Capability, 0, len(r.converters))
+	for _, c := range r.converters {
+		capabilities = append(capabilities, Describe(c))
+	}
+	return capabilities
+}
+
+// Describe returns the capability of a single converter.
+func Describe(c Converter) domain.Capability {
+	return domain.Capability{
+		Type:       c.Name(),
+		MIMETypes:  c.MIMETypes(),
+		Extensions: c.Extensions(),
+		Binary:     c.Binary(),
+		MaxSize:    c.MaxSize(),
+	}
+}
+
+// checkContext turns a cancelled or expired context into a conversion
+// error.
+func checkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return errors.NewInternalError("Conversion aborted: " + err.Error())
+	}
+	return nil
+}
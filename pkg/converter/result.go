@@ -0,0 +1,21 @@
+package converter
+
+import (
+	"any2md/internal/domain"
+	"any2md/pkg/document"
+)
+
+// Result is everything a converter produces for a single document.
+type Result struct {
+	Markdown string
+	// Document is the tree the Markdown was rendered from
+	Document *document.Document
+	Elements domain.ElementsCount
+	Tables   []domain.TableReport
+	Metadata *domain.Metadata
+	Charset  string
+	// Attachments are the files extracted with the "extract" image policy
+	Attachments []domain.Attachment
+	// Recipe is the name of the site recipe that was applied, if any
+	Recipe string
+}
@@ -0,0 +1,153 @@
+package converter
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// sourceSelector matches the elements a block of the output can come from.
+const sourceSelector = "h1, h2, h3, h4, h5, h6, p, ul, ol, dl, menu, pre, table, blockquote, " +
+	"div, section, article, main, aside, header, footer, nav, figure, figcaption, details, summary, " +
+	"li, dd, dt, td, th, address, center"
+
+// htmlSource is where an element is in the HTML.
+type htmlSource struct {
+	path      string
+	offset    int
+	endOffset int
+}
+
+type htmlSources map[*html.Node]htmlSource
+
+// indexHTMLSources records where the elements of doc, freshly parsed from
+// source, are: their CSS path, and their offsets found by tokenizing the
+// source again, the n-th start tag of a name being the n-th element of
+// that name. It runs before the document is modified, so paths and
+// offsets refer to the HTML as it was sent.
+func indexHTMLSources(source string, doc *goquery.Document) htmlSources {
+	type span struct{ start, end int }
+	spans := make(map[string][]span)
+	type element struct {
+		name  string
+		index int
+	}
+	var open []element
+	// closeFrom ends the open elements from i at offset: elements closed
+	// implicitly end where the tag that closes them starts
+	closeFrom := func(i, offset int) {
+		for _, e := range open[i:] {
+			spans[e.name][e.index].end = offset
+		}
+		open = open[:i]
+	}
+
+	z := html.NewTokenizer(strings.NewReader(source))
+	offset := 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		raw := len(z.Raw())
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			for len(open) > 0 && impliedEnds[open[len(open)-1].name][tag] {
+				closeFrom(len(open)-1, offset)
+			}
+			spans[tag] = append(spans[tag], span{start: offset})
+			if tt == html.StartTagToken && !voidElements[tag] {
+				open = append(open, element{tag, len(spans[tag]) - 1})
+			} else {
+				spans[tag][len(spans[tag])-1].end = offset + raw
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i].name == string(name) {
+					closeFrom(i+1, offset)
+					spans[open[i].name][open[i].index].end = offset + raw
+					open = open[:i]
+					break
+				}
+			}
+		}
+		offset += raw
+	}
+	closeFrom(0, offset)
+
+	sources := make(htmlSources)
+	seen := make(map[string]int)
+	doc.Find(sourceSelector).Each(func(i int, s *goquery.Selection) {
+		node := s.Get(0)
+		n := seen[node.Data]
+		seen[node.Data]++
+		if n >= len(spans[node.Data]) {
+			return
+		}
+		sources[node] = htmlSource{path: cssPath(node), offset: spans[node.Data][n].start, endOffset: spans[node.Data][n].end}
+	})
+	return sources
+}
+
+// impliedEnds lists, for the elements whose end tag may be omitted, the
+// start tags that close them.
+var impliedEnds = func() map[string]map[string]bool {
+	set := func(tags string) map[string]bool {
+		m := make(map[string]bool)
+		for _, tag := range strings.Fields(tags) {
+			m[tag] = true
+		}
+		return m
+	}
+	sections := "tbody thead tfoot"
+	return map[string]map[string]bool{
+		"p": set("address article aside blockquote center details dialog dir div dl dd dt fieldset figcaption figure " +
+			"footer form h1 h2 h3 h4 h5 h6 header hgroup hr li main menu nav ol p pre section summary table ul"),
+		"li":       set("li"),
+		"dt":       set("dt dd"),
+		"dd":       set("dt dd"),
+		"td":       set("td th tr " + sections),
+		"th":       set("td th tr " + sections),
+		"tr":       set("tr " + sections),
+		"thead":    set(sections),
+		"tbody":    set(sections),
+		"tfoot":    set(sections),
+		"option":   set("option optgroup"),
+		"optgroup": set("optgroup"),
+	}
+}()
+
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// cssPath returns a selector that matches node only, such as
+// "html > body > div:nth-of-type(2) > p".
+func cssPath(node *html.Node) string {
+	var parts []string
+	for n := node; n != nil && n.Type == html.ElementNode; n = n.Parent {
+		part := n.Data
+		index, count := 0, 0
+		if n.Parent != nil {
+			for sibling := n.Parent.FirstChild; sibling != nil; sibling = sibling.NextSibling {
+				if sibling.Type == html.ElementNode && sibling.Data == n.Data {
+					count++
+					if sibling == n {
+						index = count
+					}
+				}
+			}
+		}
+		if count > 1 {
+			part += ":nth-of-type(" + strconv.Itoa(index) + ")"
+		}
+		parts = append([]string{part}, parts...)
+	}
+	return strings.Join(parts, " > ")
+}
@@ -0,0 +1,76 @@
+package converter
+
+import (
+	"html"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SVG modes accepted in ConversionOptions.SVG.
+const (
+	SVGDrop    = "drop"
+	SVGAlt     = "alt"
+	SVGExtract = "extract"
+)
+
+const svgNamespace = "http://www.w3.org/2000/svg"
+
+// graphics returns the inline SVGs (not those nested in another SVG) and
+// canvases of the document.
+func graphics(doc *goquery.Document) *goquery.Selection {
+	return doc.Find("svg, canvas").FilterFunction(func(i int, s *goquery.Selection) bool {
+		return s.ParentsFiltered("svg").Length() == 0
+	})
+}
+
+// prepareGraphics keeps the text inside inline SVGs and canvases out of
+// the surrounding paragraphs. In alt mode (the default) a graphic is
+// replaced by its accessible name or its <title> and <desc>; in extract
+// mode SVGs become image attachments linked from the Markdown; in drop
+// mode they are removed. Canvases have no markup to extract and fall
+// back to alt text.
+func prepareGraphics(doc *goquery.Document, mode string, files *attachments) {
+	graphics(doc).Each(func(i int, graphic *goquery.Selection) {
+		alt := graphicAlt(doc, graphic)
+
+		switch {
+		case mode == SVGDrop:
+			graphic.Remove()
+		case mode == SVGExtract && graphic.Is("svg"):
+			if _, ok := graphic.Attr("xmlns"); !ok {
+				graphic.SetAttr("xmlns", svgNamespace)
+			}
+			markup, err := goquery.OuterHtml(graphic)
+			if err != nil {
+				graphic.Remove()
+				return
+			}
+			name := files.Add([]byte(markup), "image/svg+xml")
+			graphic.ReplaceWithHtml(`<img src="` + html.EscapeString(name) + `" alt="` + html.EscapeString(alt) + `">`)
+		case alt != "":
+			graphic.ReplaceWithNodes(textNode(alt))
+		default:
+			graphic.Remove()
+		}
+	})
+}
+
+// graphicAlt is the text that stands in for a graphic: its aria-label or
+// the <title> and <desc> of an SVG, or the fallback content of a canvas.
+func graphicAlt(doc *goquery.Document, graphic *goquery.Selection) string {
+	if label := accessibleName(doc, graphic); label != "" {
+		return label
+	}
+	if graphic.Is("canvas") {
+		return collapseSpace(graphic.Text())
+	}
+
+	var parts []string
+	for _, selector := range []string{"title", "desc"} {
+		if text := collapseSpace(graphic.ChildrenFiltered(selector).First().Text()); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, ": ")
+}
@@ -0,0 +1,357 @@
+package converter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"any2md/internal/domain"
+	"any2md/pkg/document"
+)
+
+// Table strategies accepted in ConversionOptions.TableStrategy.
+const (
+	TableStrategyGFM      = "gfm"
+	TableStrategyExpand   = "expand"
+	TableStrategyHTML     = "html"
+	TableStrategyKeyValue = "key_value"
+)
+
+// attrTableStrategy marks a table with the strategy picked for it so the
+// table is converted accordingly.
+const attrTableStrategy = "data-any2md-table"
+
+// tableBlockSelector matches content that a GFM table cell cannot hold.
+const tableBlockSelector = "table, ul, ol, pre, blockquote, h1, h2, h3, h4, h5, h6, hr"
+
+// tableGrid is a table with every merged cell resolved to the grid
+// positions it covers.
+type tableGrid struct {
+	rows       [][]*goquery.Selection
+	headerRows int
+	columns    int
+	merged     int
+}
+
+// tableRows returns the rows that belong to the table itself, skipping the
+// rows of nested tables.
+func tableRows(table *goquery.Selection) []*goquery.Selection {
+	var rows []*goquery.Selection
+	table.Find("tr").Each(func(i int, row *goquery.Selection) {
+		if row.Closest("table").IsSelection(table) {
+			rows = append(rows, row)
+		}
+	})
+	return rows
+}
+
+func newTableGrid(table *goquery.Selection) *tableGrid {
+	grid := &tableGrid{}
+	rows := tableRows(table)
+
+	for r, row := range rows {
+		for len(grid.rows) <= r {
+			grid.rows = append(grid.rows, nil)
+		}
+
+		col := 0
+		row.ChildrenFiltered("td, th").Each(func(i int, cell *goquery.Selection) {
+			for col < len(grid.rows[r]) && grid.rows[r][col] != nil {
+				col++
+			}
+
+			colspan := spanAttr(cell, "colspan")
+			rowspan := spanAttr(cell, "rowspan")
+			if colspan > 1 || rowspan > 1 {
+				grid.merged++
+			}
+			if r+rowspan > len(rows) {
+				rowspan = len(rows) - r
+			}
+
+			for dr := 0; dr < rowspan; dr++ {
+				for len(grid.rows) <= r+dr {
+					grid.rows = append(grid.rows, nil)
+				}
+				for dc := 0; dc < colspan; dc++ {
+					for len(grid.rows[r+dr]) <= col+dc {
+						grid.rows[r+dr] = append(grid.rows[r+dr], nil)
+					}
+					grid.rows[r+dr][col+dc] = cell
+				}
+			}
+			col += colspan
+		})
+
+		if goquery.NodeName(row.Parent()) == "thead" || grid.headerRows == r && row.ChildrenFiltered("td").Length() == 0 && row.ChildrenFiltered("th").Length() > 0 {
+			grid.headerRows = r + 1
+		}
+	}
+
+	for _, row := range grid.rows {
+		if len(row) > grid.columns {
+			grid.columns = len(row)
+		}
+	}
+	return grid
+}
+
+func spanAttr(cell *goquery.Selection, name string) int {
+	value := strings.TrimSpace(cell.AttrOr(name, "1"))
+	n := 0
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			break
+		}
+		n = n*10 + int(r-'0')
+		if n > 1000 {
+			break
+		}
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// headerText returns the label of a column, joining the texts of all header
+// rows ("2024 Q1") when the table has a multi-row header.
+func (g *tableGrid) headerText(col int) string {
+	var parts []string
+	var last *goquery.Selection
+	for r := 0; r < g.headerRows; r++ {
+		if col >= len(g.rows[r]) || g.rows[r][col] == nil || g.rows[r][col] == last {
+			continue
+		}
+		last = g.rows[r][col]
+		if text := cellText(last); text != "" && (len(parts) == 0 || parts[len(parts)-1] != text) {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+var whitespaceR = regexp.MustCompile(`\s+`)
+
+func cellText(cell *goquery.Selection) string {
+	return strings.TrimSpace(whitespaceR.ReplaceAllString(cell.Text(), " "))
+}
+
+// prepareTables picks a rendering strategy for every table in the document,
+// rewriting the DOM where needed, and reports what was done. Tables with
+// merged cells are expanded unless the html or key_value strategy is
+// asked for, so no table is rendered as GFM with its cells out of place.
+func prepareTables(doc *goquery.Document, strategy string) []domain.TableReport {
+	var reports []domain.TableReport
+
+	doc.Find("table").Each(func(i int, table *goquery.Selection) {
+		grid := newTableGrid(table)
+		merged := grid.merged > 0 || grid.headerRows > 1
+		hasBlocks := table.Find(tableBlockSelector).Length() > 0
+
+		used := TableStrategyGFM
+		switch strategy {
+		case TableStrategyHTML:
+			if merged || hasBlocks {
+				used = TableStrategyHTML
+			}
+		case TableStrategyKeyValue:
+			used = TableStrategyKeyValue
+		default:
+			// GFM can't span cells: they are duplicated, or the cells
+			// after them would shift into the wrong columns
+			if merged {
+				grid.expand(table)
+				used = TableStrategyExpand
+			}
+		}
+		table.SetAttr(attrTableStrategy, used)
+
+		reports = append(reports, domain.TableReport{
+			Index:       i,
+			Strategy:    used,
+			Rows:        len(grid.rows),
+			Columns:     grid.columns,
+			MergedCells: grid.merged,
+		})
+	})
+
+	return reports
+}
+
+// expand rewrites the table so that every merged cell is duplicated into
+// the positions it spans and a multi-row header collapses into one row.
+func (g *tableGrid) expand(table *goquery.Selection) {
+	rows := tableRows(table)
+
+	if g.headerRows > 1 {
+		labels := make([]string, g.columns)
+		for col := range labels {
+			labels[col] = g.headerText(col)
+		}
+
+		header := rows[0]
+		header.Empty()
+		for _, label := range labels {
+			header.AppendHtml("<th></th>")
+			header.Children().Last().SetText(label)
+		}
+		for _, row := range rows[1:g.headerRows] {
+			row.Remove()
+		}
+	}
+
+	start := 0
+	if g.headerRows > 1 {
+		start = g.headerRows
+	}
+	for r := start; r < len(rows); r++ {
+		row := rows[r]
+		cells := g.rows[r]
+		row.Empty()
+		for col := 0; col < g.columns; col++ {
+			if col >= len(cells) || cells[col] == nil {
+				row.AppendHtml("<td></td>")
+				continue
+			}
+			cell := cells[col].Clone()
+			cell.RemoveAttr("colspan")
+			cell.RemoveAttr("rowspan")
+			row.AppendSelection(cell)
+		}
+	}
+}
+
+// table converts a table with the strategy picked for it: GFM tables by
+// default, raw HTML or one list item per cell. The caption follows the
+// table.
+func (b *domBuilder) table(n *html.Node) {
+	if b.depth > 0 {
+		b.container(n)
+		return
+	}
+	table := selection(n)
+	switch table.AttrOr(attrTableStrategy, "") {
+	case TableStrategyHTML:
+		b.addBlock(&document.HTMLBlock{HTML: rawTableHTML(table)}, n)
+	case TableStrategyKeyValue:
+		if list := b.keyValueList(table); list != nil {
+			b.addBlock(list, n)
+		}
+	default:
+		if grid := b.gfmTable(table); grid != nil {
+			b.addBlock(grid, n)
+		}
+	}
+	table.ChildrenFiltered("caption").Each(func(i int, caption *goquery.Selection) {
+		b.container(caption.Get(0))
+	})
+}
+
+// gfmTable converts a table into a GFM table. Its first row is the header
+// if it is in <thead> or only has header cells; otherwise the header is
+// left empty.
+func (b *domBuilder) gfmTable(table *goquery.Selection) *document.Table {
+	selections := tableRows(table)
+	var rows [][]*html.Node
+	columns := 0
+	for _, row := range selections {
+		cells := row.ChildrenFiltered("td, th").Nodes
+		if len(cells) > columns {
+			columns = len(cells)
+		}
+		rows = append(rows, cells)
+	}
+	if columns == 0 {
+		return nil
+	}
+
+	cells := func(row []*html.Node) []document.Cell {
+		converted := make([]document.Cell, len(row))
+		for i, cell := range row {
+			converted[i] = b.inlinesOf(cell, linesInCell)
+		}
+		return converted
+	}
+	grid := &document.Table{Header: make([]document.Cell, columns), Align: make([]document.Alignment, columns)}
+	if first := selections[0]; first.Parent().Is("thead") || first.ChildrenFiltered("td").Length() == 0 && len(rows[0]) > 0 {
+		copy(grid.Header, cells(rows[0]))
+		for i, cell := range rows[0] {
+			switch strings.ToLower(attrOr(cell, "align", "")) {
+			case "left":
+				grid.Align[i] = document.AlignLeft
+			case "center":
+				grid.Align[i] = document.AlignCenter
+			case "right":
+				grid.Align[i] = document.AlignRight
+			}
+		}
+		rows = rows[1:]
+	}
+	for _, row := range rows {
+		grid.Rows = append(grid.Rows, cells(row))
+	}
+	return grid
+}
+
+var blankLinesR = regexp.MustCompile(`\n\s*\n`)
+
+// rawTableHTML returns the table as HTML without the attributes added
+// during conversion and without blank lines, which would end the HTML block.
+func rawTableHTML(table *goquery.Selection) string {
+	clone := table.Clone()
+	for _, selec := range []*goquery.Selection{clone, clone.Find("*")} {
+		selec.RemoveAttr(attrTableStrategy)
+		selec.RemoveAttr("data-index")
+		selec.RemoveAttr("data-converter-list-prefix")
+	}
+
+	html, err := goquery.OuterHtml(clone)
+	if err != nil {
+		return cellText(table)
+	}
+	return blankLinesR.ReplaceAllString(strings.TrimSpace(html), "\n")
+}
+
+// keyValueList flattens a table into a list of its cells, pairing every
+// value with its column header. The items of a row are kept together and
+// rows are separated by a blank line.
+func (b *domBuilder) keyValueList(table *goquery.Selection) *document.List {
+	grid := newTableGrid(table)
+
+	var headers []string
+	for col := 0; col < grid.columns; col++ {
+		header := grid.headerText(col)
+		if header == "" {
+			header = "Column " + strconv.Itoa(col+1)
+		}
+		headers = append(headers, header)
+	}
+
+	list := &document.List{Start: 1}
+	for r := grid.headerRows; r < len(grid.rows); r++ {
+		attached := false
+		for col, cell := range grid.rows[r] {
+			if cell == nil {
+				continue
+			}
+			value := b.inlinesOf(cell.Get(0), linesJoined)
+			if len(value) == 0 {
+				continue
+			}
+			key := &document.Emphasis{Strong: true, Content: []document.Inline{&document.Text{Value: headers[col]}}}
+			content := append([]document.Inline{key, &document.Text{Value: ": "}}, value...)
+			list.Items = append(list.Items, &document.ListItem{
+				Blocks:   []document.Block{&document.Paragraph{Content: content}},
+				Attached: attached,
+			})
+			attached = true
+		}
+	}
+	if len(list.Items) == 0 {
+		return nil
+	}
+	return list
+}
@@ -0,0 +1,209 @@
+package converter
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"any2md/pkg/document"
+)
+
+// Heading id modes accepted in ConversionOptions.HeadingIDs.
+const (
+	HeadingIDsPreserve = "preserve"
+	HeadingIDsGitHub   = "github"
+)
+
+// attrHeadingAnchor marks the placeholder that carries a preserved id.
+const attrHeadingAnchor = "data-any2md-anchor"
+
+const headingSelector = "h1, h2, h3, h4, h5, h6"
+
+// headingIDs returns the ids that point at a heading: its own, those of
+// anchors inside or right before it, and those of sections it introduces.
+func headingIDs(heading *goquery.Selection) []string {
+	var ids []string
+	add := func(id string) {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			return
+		}
+		for _, existing := range ids {
+			if existing == id {
+				return
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	add(heading.AttrOr("id", ""))
+	heading.Find("[id], a[name]").Each(func(i int, s *goquery.Selection) {
+		add(s.AttrOr("id", s.AttrOr("name", "")))
+	})
+	if prev := heading.Prev(); prev.Is("a") && strings.TrimSpace(prev.Text()) == "" {
+		add(prev.AttrOr("id", prev.AttrOr("name", "")))
+	}
+	for parent := heading.Parent(); parent.Length() > 0 && !parent.Is("body, html"); parent = parent.Parent() {
+		if !parent.Find(headingSelector).First().IsSelection(heading) {
+			break
+		}
+		add(parent.AttrOr("id", ""))
+	}
+
+	return ids
+}
+
+// prepareHeadingIDs keeps in-document links working after conversion.
+// In preserve mode every heading that had an id gets an HTML anchor with
+// that id; in github mode links are rewritten to GitHub's generated slugs.
+func prepareHeadingIDs(doc *goquery.Document, mode string) {
+	if mode != HeadingIDsPreserve && mode != HeadingIDsGitHub {
+		return
+	}
+
+	slugs := document.NewSlugger()
+	targets := make(map[string]string)
+
+	doc.Find(headingSelector).Each(func(i int, heading *goquery.Selection) {
+		text := strings.TrimSpace(whitespaceR.ReplaceAllString(heading.Text(), " "))
+		if text == "" {
+			return
+		}
+		slug := slugs.Slug(text)
+		ids := headingIDs(heading)
+
+		if mode == HeadingIDsPreserve {
+			for _, id := range ids {
+				heading.BeforeHtml("<a></a>")
+				heading.Prev().SetAttr(attrHeadingAnchor, id)
+			}
+			return
+		}
+		for _, id := range ids {
+			targets[id] = slug
+		}
+	})
+
+	if mode == HeadingIDsGitHub {
+		doc.Find(`a[href^="#"]`).Each(func(i int, link *goquery.Selection) {
+			if slug, ok := targets[strings.TrimPrefix(link.AttrOr("href", ""), "#")]; ok {
+				link.SetAttr("href", "#"+slug)
+			}
+		})
+	}
+}
+
+var (
+	atxHeadingR     = regexp.MustCompile(`^ {0,3}(#{1,6})\s+(.*?)(\s+#+)?\s*$`)
+	setextUnderline = regexp.MustCompile(`^ {0,3}(=+|-+)\s*$`)
+	anchorLineR     = regexp.MustCompile(`^<a id="([^"]+)"></a>$`)
+	mdLinkR         = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)|\[([^\]]*)\]\[[^\]]*\]`)
+	mdEscapeR       = regexp.MustCompile("\\\\([!-/:-@\\[-`{-~])")
+)
+
+// tocEntry is a heading found in the Markdown output.
+type tocEntry struct {
+	level  int
+	text   string
+	anchor string
+	line   int
+}
+
+// markdownHeadings lists the ATX and setext headings outside code blocks.
+func markdownHeadings(lines []string) []tocEntry {
+	var headings []tocEntry
+	var fence codeFence
+	slugs := document.NewSlugger()
+
+	for i, line := range lines {
+		if fence.Inside(line) {
+			continue
+		}
+
+		var level int
+		var text string
+		if m := atxHeadingR.FindStringSubmatch(line); m != nil {
+			level, text = len(m[1]), m[2]
+		} else if i > 0 && strings.TrimSpace(lines[i-1]) != "" && setextUnderline.MatchString(line) && !atxHeadingR.MatchString(lines[i-1]) && !isMarkdownBlockStart(lines[i-1]) {
+			level, text = 2, strings.TrimSpace(lines[i-1])
+			if strings.Contains(line, "=") {
+				level = 1
+			}
+			i--
+		} else {
+			continue
+		}
+
+		plain := headingPlainText(text)
+		anchor := slugs.Slug(plain)
+		if i > 1 && strings.TrimSpace(lines[i-1]) == "" {
+			if m := anchorLineR.FindStringSubmatch(strings.TrimSpace(lines[i-2])); m != nil {
+				anchor = m[1]
+			}
+		}
+		headings = append(headings, tocEntry{level: level, text: plain, anchor: anchor, line: i})
+	}
+
+	return headings
+}
+
+// isMarkdownBlockStart reports whether a line opens a block that cannot be
+// the text of a setext heading, such as a list item or a table row.
+func isMarkdownBlockStart(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* ") || strings.HasPrefix(trimmed, "+ ") ||
+		strings.HasPrefix(trimmed, "|") || strings.HasPrefix(trimmed, ">") || strings.HasPrefix(trimmed, "<")
+}
+
+// headingPlainText strips inline Markdown from heading text.
+func headingPlainText(text string) string {
+	text = mdLinkR.ReplaceAllString(text, "$1$2")
+	text = strings.NewReplacer("**", "", "__", "", "`", "").Replace(text)
+	text = strings.Trim(text, "*_")
+	text = mdEscapeR.ReplaceAllString(text, "$1")
+	return strings.TrimSpace(text)
+}
+
+// insertTableOfContents adds a nested list of links to every heading after
+// the first one, right below that first heading.
+func insertTableOfContents(markdown string, marker string) string {
+	lines := strings.Split(markdown, "\n")
+	headings := markdownHeadings(lines)
+	if len(headings) < 2 {
+		return markdown
+	}
+	if marker == "" {
+		marker = "-"
+	}
+
+	entries := headings[1:]
+	var toc []string
+	var levels []int
+	for _, entry := range entries {
+		for len(levels) > 0 && levels[len(levels)-1] >= entry.level {
+			levels = levels[:len(levels)-1]
+		}
+		indent := strings.Repeat("  ", len(levels))
+		levels = append(levels, entry.level)
+		toc = append(toc, indent+marker+" ["+escapeLinkText(entry.text)+"](#"+entry.anchor+")")
+	}
+
+	// insert after the first heading (and its setext underline)
+	at := headings[0].line + 1
+	if at < len(lines) && setextUnderline.MatchString(lines[at]) && !atxHeadingR.MatchString(lines[headings[0].line]) {
+		at++
+	}
+
+	result := append([]string{}, lines[:at]...)
+	result = append(result, "")
+	result = append(result, toc...)
+	if at < len(lines) && strings.TrimSpace(lines[at]) != "" {
+		result = append(result, "")
+	}
+	result = append(result, lines[at:]...)
+	return strings.Join(result, "\n")
+}
+
+func escapeLinkText(text string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`).Replace(text)
+}
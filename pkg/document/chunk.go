@@ -0,0 +1,653 @@
+package document
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"any2md/internal/domain"
+	"any2md/pkg/tokenizer"
+)
+
+// Chunks splits the document into chunks for retrieval. A heading always
+// starts a new chunk, unless the chunk holds nothing but headings yet, and
+// the content of a section is packed into chunks that stay within the
+// limits of options.Chunking. Tables, lists, code and quotes are never
+// split; a block that is larger than the limit gets a chunk of its own,
+// except paragraphs, which are split between sentences. Chunks that
+// continue a section start with the last blocks of the previous chunk, up
+// to the overlap. Links are inlined and the definitions of the footnotes
+// a chunk refers to are appended to it, so that every chunk stands on its
+// own. Tokens are counted with the tokenizer of the options.
+func (d *Document) Chunks(options domain.ConversionOptions) ([]domain.Chunk, error) {
+	tokens, err := tokenizer.Get(options.Tokenizer)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunking domain.ChunkingOptions
+	if options.Chunking != nil {
+		chunking = *options.Chunking
+	}
+	if chunking.MaxTokens == 0 && chunking.MaxChars == 0 {
+		chunking.MaxTokens = domain.DefaultChunkTokens
+	}
+	options.LinkStyle = "inlined"
+
+	c := &chunker{renderer: newRenderer(options), options: chunking, tokens: tokens, footnotes: make(map[string]chunkPart)}
+	c.noteSeparator = "\n"
+	if options.Flavor == FlavorCommonMark || options.Flavor == FlavorPlain {
+		c.noteSeparator = "\n\n"
+	}
+	c.blank, c.noteGap, c.space = c.measure("\n\n"), c.measure(c.noteSeparator), c.measure(" ")
+	for _, note := range d.Footnotes {
+		markdown := c.renderer.footnote(note)
+		c.footnotes[note.Label] = chunkPart{markdown: markdown, notes: footnoteLabels(note.Blocks), size: c.measure(markdown)}
+	}
+	c.reset(nil)
+
+	for _, block := range d.Blocks {
+		switch b := block.(type) {
+		case *Heading:
+			c.heading(b)
+		case *ThematicBreak:
+			// chunks are separated anyway
+		default:
+			c.add(block)
+		}
+	}
+	c.flush(false)
+	return c.chunks, nil
+}
+
+// chunkPart is a rendered block of the chunk being built, with the labels
+// of the footnotes it needs and its size.
+type chunkPart struct {
+	markdown string
+	page     int
+	kind     int
+	notes    []string
+	size     chunkSize
+}
+
+const (
+	partHeading = iota
+	partOverlap
+	partContent
+)
+
+// chunkSize is the size of some Markdown in the units of the limits.
+// Tokens are only counted when the chunks are limited in tokens.
+type chunkSize struct {
+	tokens int
+	chars  int
+}
+
+func (s chunkSize) plus(other chunkSize) chunkSize {
+	return chunkSize{tokens: s.tokens + other.tokens, chars: s.chars + other.chars}
+}
+
+type chunkHeading struct {
+	level int
+	text  string
+}
+
+// chunker builds the chunks of a document block by block. path holds the
+// headings of the current section, outermost first. The size of the
+// current chunk is kept up to date as parts are added: it is the sum of
+// the sizes of its parts, its footnotes and the separators in between,
+// which can only overestimate the tokens of the whole, so text is
+// measured once rather than with all of the chunk every time.
+type chunker struct {
+	renderer *renderer
+	options  domain.ChunkingOptions
+	tokens   tokenizer.Tokenizer
+	chunks   []domain.Chunk
+	path     []chunkHeading
+	parts    []chunkPart
+
+	size      chunkSize
+	notes     []string
+	noteSet   map[string]bool
+	notesSize chunkSize
+
+	footnotes             map[string]chunkPart
+	noteSeparator         string
+	blank, noteGap, space chunkSize
+}
+
+func (c *chunker) heading(heading *Heading) {
+	if c.hasContent() {
+		c.flush(false)
+	}
+	for len(c.path) > 0 && c.path[len(c.path)-1].level >= heading.Level {
+		c.path = c.path[:len(c.path)-1]
+	}
+	c.path = append(c.path, chunkHeading{level: heading.Level, text: plainText(heading.Content)})
+	c.push(c.part(c.renderer.block(heading), heading.Position().Page, partHeading, footnoteLabels([]Block{heading})))
+}
+
+func (c *chunker) add(block Block) {
+	markdown := c.renderer.block(block)
+	if markdown == "" {
+		return
+	}
+	part := c.part(markdown, block.Position().Page, partContent, footnoteLabels([]Block{block}))
+
+	if c.fits(part) {
+		c.push(part)
+		return
+	}
+	if c.hasContent() {
+		c.flush(true)
+		if c.fits(part) {
+			c.push(part)
+			return
+		}
+		c.dropOverlap()
+	}
+
+	paragraph, ok := block.(*Paragraph)
+	if !ok {
+		// too large on its own, but never split
+		c.push(part)
+		return
+	}
+	for _, piece := range c.splitParagraph(paragraph, part.page) {
+		if c.hasContent() && !c.fits(piece) {
+			c.flush(true)
+			if !c.fits(piece) {
+				c.dropOverlap()
+			}
+		}
+		c.push(piece)
+	}
+}
+
+// part measures the Markdown of a part.
+func (c *chunker) part(markdown string, page, kind int, labels []string) chunkPart {
+	return chunkPart{markdown: markdown, page: page, kind: kind, notes: c.referenced(labels), size: c.measure(markdown)}
+}
+
+func (c *chunker) measure(markdown string) chunkSize {
+	size := chunkSize{chars: utf8.RuneCountInString(markdown)}
+	if c.options.MaxTokens > 0 {
+		size.tokens = c.tokens.Count(markdown)
+	}
+	return size
+}
+
+// referenced returns the labels of the footnotes defined in the document
+// among labels, with those their definitions refer to in turn, once each.
+func (c *chunker) referenced(labels []string) []string {
+	var notes []string
+	seen := make(map[string]bool)
+	queue := append([]string(nil), labels...)
+	for i := 0; i < len(queue); i++ {
+		note, ok := c.footnotes[queue[i]]
+		if !ok || seen[queue[i]] {
+			continue
+		}
+		seen[queue[i]] = true
+		notes = append(notes, queue[i])
+		queue = append(queue, note.notes...)
+	}
+	return notes
+}
+
+// push adds a part to the current chunk.
+func (c *chunker) push(part chunkPart) {
+	if len(c.parts) > 0 {
+		c.size = c.size.plus(c.blank)
+	}
+	c.size = c.size.plus(part.size)
+	c.parts = append(c.parts, part)
+	for _, label := range part.notes {
+		if c.noteSet[label] {
+			continue
+		}
+		if len(c.notes) > 0 {
+			c.notesSize = c.notesSize.plus(c.noteGap)
+		}
+		c.notesSize = c.notesSize.plus(c.footnotes[label].size)
+		c.notes = append(c.notes, label)
+		c.noteSet[label] = true
+	}
+}
+
+// reset makes parts the content of the current chunk.
+func (c *chunker) reset(parts []chunkPart) {
+	c.parts, c.size = nil, chunkSize{}
+	c.notes, c.noteSet, c.notesSize = nil, make(map[string]bool), chunkSize{}
+	for _, part := range parts {
+		c.push(part)
+	}
+}
+
+// fits reports whether part can be added to the current chunk.
+func (c *chunker) fits(part chunkPart) bool {
+	return c.withinLimits(c.sizeWith(part, false))
+}
+
+// fitsAlone reports whether part fits in a chunk of its own.
+func (c *chunker) fitsAlone(part chunkPart) bool {
+	return c.withinLimits(c.sizeWith(part, true))
+}
+
+// sizeWith returns the size of the current chunk with part added, or of a
+// chunk holding part alone.
+func (c *chunker) sizeWith(part chunkPart, alone bool) chunkSize {
+	size, notesSize, notes := part.size, chunkSize{}, 0
+	if !alone {
+		if len(c.parts) > 0 {
+			size = size.plus(c.blank).plus(c.size)
+		}
+		notesSize, notes = c.notesSize, len(c.notes)
+	}
+	for _, label := range part.notes {
+		if !alone && c.noteSet[label] {
+			continue
+		}
+		if notes > 0 {
+			notesSize = notesSize.plus(c.noteGap)
+		}
+		notesSize = notesSize.plus(c.footnotes[label].size)
+		notes++
+	}
+	if notes > 0 {
+		size = size.plus(c.blank).plus(notesSize)
+	}
+	return size
+}
+
+func (c *chunker) withinLimits(size chunkSize) bool {
+	if c.options.MaxTokens > 0 && size.tokens > c.options.MaxTokens {
+		return false
+	}
+	if c.options.MaxChars > 0 && size.chars > c.options.MaxChars {
+		return false
+	}
+	return true
+}
+
+func (c *chunker) hasContent() bool {
+	for _, part := range c.parts {
+		if part.kind == partContent {
+			return true
+		}
+	}
+	return false
+}
+
+// markdown joins the parts of the current chunk, followed by the
+// definitions of the footnotes they refer to.
+func (c *chunker) markdown() string {
+	texts := make([]string, len(c.parts))
+	for i, part := range c.parts {
+		texts[i] = part.markdown
+	}
+	markdown := strings.Join(texts, "\n\n")
+	if len(c.notes) == 0 {
+		return markdown
+	}
+	notes := make([]string, len(c.notes))
+	for i, label := range c.notes {
+		notes[i] = c.footnotes[label].markdown
+	}
+	return markdown + "\n\n" + strings.Join(notes, c.noteSeparator)
+}
+
+// flush ends the current chunk. When the next chunk continues the same
+// section, it starts with the overlap of this one.
+func (c *chunker) flush(continued bool) {
+	if len(c.parts) == 0 {
+		return
+	}
+
+	chunk := domain.Chunk{Index: len(c.chunks), Markdown: c.markdown()}
+	for _, heading := range c.path {
+		chunk.Breadcrumb = append(chunk.Breadcrumb, heading.text)
+	}
+	overlap := 0
+	for _, part := range c.parts {
+		if part.kind == partOverlap {
+			overlap += utf8.RuneCountInString(part.markdown) + len("\n\n")
+		}
+		if part.page == 0 {
+			continue
+		}
+		if chunk.PageStart == 0 || part.page < chunk.PageStart {
+			chunk.PageStart = part.page
+		}
+		if part.page > chunk.PageEnd {
+			chunk.PageEnd = part.page
+		}
+	}
+	chunk.Overlap = overlap
+	chunk.Tokens = c.tokens.Count(chunk.Markdown)
+	chunk.Characters = utf8.RuneCountInString(chunk.Markdown)
+	c.chunks = append(c.chunks, chunk)
+
+	parts := c.parts
+	c.reset(nil)
+	if !continued || c.options.Overlap == 0 {
+		return
+	}
+	// the last blocks that fit in the overlap, oldest first
+	var kept []chunkPart
+	size := 0
+	for i := len(parts) - 1; i >= 0 && parts[i].kind == partContent; i-- {
+		n := c.overlapSize(parts[i].size)
+		if size+n > c.options.Overlap {
+			break
+		}
+		size += n
+		part := parts[i]
+		part.kind = partOverlap
+		kept = append([]chunkPart{part}, kept...)
+	}
+	if len(kept) < len(parts) {
+		c.reset(kept)
+	}
+}
+
+// overlapSize is size in the unit of the overlap: tokens when the chunks
+// are limited in tokens, characters otherwise.
+func (c *chunker) overlapSize(size chunkSize) int {
+	if c.options.MaxTokens > 0 {
+		return size.tokens
+	}
+	return size.chars
+}
+
+// dropOverlap removes the overlap from the current chunk, to make room
+// for a block that doesn't fit with it.
+func (c *chunker) dropOverlap() {
+	var parts []chunkPart
+	for _, part := range c.parts {
+		if part.kind != partOverlap {
+			parts = append(parts, part)
+		}
+	}
+	c.reset(parts)
+}
+
+// splitParagraph splits a paragraph between sentences, or between words
+// when a sentence is too long. The first piece fits in the current chunk,
+// the others in an empty one. Only the text is cut: emphasis, links, code,
+// inline HTML elements and the extended syntax kept in text read from
+// Markdown (==mark==, ~sub~...) stay whole, so every piece is valid
+// Markdown on its own. A piece grows by sentences or words measured on
+// their own, and is only rendered and measured whole once complete.
+func (c *chunker) splitParagraph(paragraph *Paragraph, page int) []chunkPart {
+	s := newInlineSplitter(paragraph.Content)
+	piece := func(start, end int) chunkPart {
+		p := &Paragraph{Content: s.slice(start, end)}
+		return c.part(strings.TrimSpace(c.renderer.block(p)), page, partContent, footnoteLabels([]Block{p}))
+	}
+
+	var pieces []chunkPart
+	start, end := 0, 0
+	var current chunkPart // text[start:end], as estimated
+	fits := c.fits
+	// extend estimates the piece text[start:to] from current and the text
+	// added, with a space in between
+	extend := func(to int) chunkPart {
+		next := piece(end, to)
+		if end > start {
+			next.size = current.size.plus(c.space).plus(next.size)
+			next.notes = c.referenced(append(append([]string(nil), current.notes...), next.notes...))
+		}
+		return next
+	}
+	emit := func() {
+		pieces = append(pieces, piece(start, end))
+		start, current = end, chunkPart{}
+		fits = c.fitsAlone
+	}
+	for _, sentence := range s.split(0, len(s.text), isSentenceEnd) {
+		next := extend(sentence)
+		if end > start && !fits(next) {
+			emit()
+			next = extend(sentence)
+		}
+		if end == start && !fits(next) {
+			for _, word := range s.split(start, sentence, isSpace) {
+				next := extend(word)
+				if end > start && !fits(next) {
+					emit()
+					next = extend(word)
+				}
+				end, current = word, next
+			}
+			continue
+		}
+		end, current = sentence, next
+	}
+	if end > start {
+		if last := piece(start, end); last.markdown != "" {
+			pieces = append(pieces, last)
+		}
+	}
+	return pieces
+}
+
+// footnoteLabels returns the labels of the footnote references of blocks.
+func footnoteLabels(blocks []Block) []string {
+	var labels []string
+	var collect func([]Inline)
+	collect = func(inlines []Inline) {
+		for _, inline := range inlines {
+			switch n := inline.(type) {
+			case *FootnoteReference:
+				labels = append(labels, n.Label)
+			case *Emphasis:
+				collect(n.Content)
+			case *Link:
+				collect(n.Content)
+			}
+		}
+	}
+	walkInlines(blocks, collect)
+	return labels
+}
+
+// inlineSplitter finds where the inlines of a paragraph can be cut. text
+// stands for the inlines: the Markdown of raw text, the value of other
+// text, a newline for a line break and a single opaque byte for any other
+// inline. protected marks the bytes inside inline syntax.
+type inlineSplitter struct {
+	inlines   []Inline
+	starts    []int
+	text      string
+	protected []bool
+}
+
+// extendedDelimiters are the delimiters of the extended inline syntax
+// that text read from Markdown may hold, longest first.
+var extendedDelimiters = []string{"==", "++", "~~", "~", "^"}
+
+func newInlineSplitter(inlines []Inline) *inlineSplitter {
+	s := &inlineSplitter{inlines: inlines}
+	var b strings.Builder
+	var raw []bool
+	for _, inline := range inlines {
+		s.starts = append(s.starts, b.Len())
+		isRaw := false
+		switch n := inline.(type) {
+		case *Text:
+			if n.Raw != "" {
+				b.WriteString(n.Raw)
+				isRaw = true
+			} else {
+				b.WriteString(n.Value)
+			}
+		case *LineBreak:
+			b.WriteByte('\n')
+		default:
+			b.WriteByte('x')
+		}
+		for len(raw) < b.Len() {
+			raw = append(raw, isRaw)
+		}
+	}
+	s.starts = append(s.starts, b.Len())
+	s.text = b.String()
+	s.protected = make([]bool, len(s.text))
+	s.protectDelimiters(raw)
+	s.protectHTML()
+	return s
+}
+
+// protectDelimiters protects the text between the extended delimiters
+// that open and close a span in raw text.
+func (s *inlineSplitter) protectDelimiters(raw []bool) {
+	open := make(map[string]int)
+	for i := 0; i < len(s.text); i++ {
+		if !raw[i] {
+			continue
+		}
+		if s.text[i] == '\\' {
+			i++
+			continue
+		}
+		for _, d := range extendedDelimiters {
+			if !strings.HasPrefix(s.text[i:], d) {
+				continue
+			}
+			before, after := byte(' '), byte(' ')
+			if i > 0 {
+				before = s.text[i-1]
+			}
+			if i+len(d) < len(s.text) {
+				after = s.text[i+len(d)]
+			}
+			if start, ok := open[d]; ok && !isSpaceByte(before) {
+				for j := start; j < i+len(d); j++ {
+					s.protected[j] = true
+				}
+				delete(open, d)
+			} else if !isSpaceByte(after) {
+				open[d] = i
+			}
+			i += len(d) - 1
+			break
+		}
+	}
+}
+
+// protectHTML protects the content of the inline HTML elements whose start
+// and end tags are both in the paragraph.
+func (s *inlineSplitter) protectHTML() {
+	type tag struct {
+		name  string
+		start int
+	}
+	var open []tag
+	for i, inline := range s.inlines {
+		html, ok := inline.(*RawHTML)
+		if !ok {
+			continue
+		}
+		name, closing := htmlTagName(html.Value)
+		if name == "" {
+			continue
+		}
+		if !closing {
+			if !strings.HasSuffix(html.Value, "/>") {
+				open = append(open, tag{name, s.starts[i]})
+			}
+			continue
+		}
+		for j := len(open) - 1; j >= 0; j-- {
+			if open[j].name == name {
+				for k := open[j].start; k < s.starts[i+1]; k++ {
+					s.protected[k] = true
+				}
+				open = open[:j]
+				break
+			}
+		}
+	}
+}
+
+// htmlTagName returns the lowercased name of an HTML tag, and whether it
+// is an end tag.
+func htmlTagName(tag string) (string, bool) {
+	tag = strings.TrimPrefix(tag, "<")
+	closing := strings.HasPrefix(tag, "/")
+	tag = strings.TrimPrefix(tag, "/")
+	end := strings.IndexFunc(tag, func(r rune) bool { return !isAlphanumeric(r) && r != '-' })
+	if end < 0 {
+		end = len(tag)
+	}
+	return strings.ToLower(tag[:end]), closing
+}
+
+// split returns the ends of the pieces of text[start:end] cut after the
+// runs of unprotected whitespace for which boundary reports true.
+func (s *inlineSplitter) split(start, end int, boundary func(text string, space int) bool) []int {
+	var ends []int
+	for i := start; i < end; i++ {
+		if !isSpaceByte(s.text[i]) || s.protected[i] || !boundary(s.text[:end], i) {
+			continue
+		}
+		for i < end && isSpaceByte(s.text[i]) {
+			i++
+		}
+		ends = append(ends, i)
+		i--
+	}
+	if len(ends) == 0 || ends[len(ends)-1] < end {
+		ends = append(ends, end)
+	}
+	return ends
+}
+
+// slice returns the inlines of text[start:end]. Text is cut where needed;
+// a piece that starts inside raw text has its start escaped as the start
+// of a line, where it now is. Trailing line breaks are dropped.
+func (s *inlineSplitter) slice(start, end int) []Inline {
+	var inlines []Inline
+	for i, inline := range s.inlines {
+		from, to := s.starts[i], s.starts[i+1]
+		if to <= start || from >= end {
+			continue
+		}
+		text, ok := inline.(*Text)
+		if !ok {
+			inlines = append(inlines, inline)
+			continue
+		}
+		lo, hi := max(start, from)-from, min(end, to)-from
+		if text.Raw == "" {
+			inlines = append(inlines, &Text{Value: text.Value[lo:hi]})
+			continue
+		}
+		piece := text.Raw[lo:hi]
+		markdown := piece
+		if len(inlines) == 0 && start > 0 {
+			markdown = escapeLineStart(piece)
+		}
+		inlines = append(inlines, &Text{Value: unescape([]byte(piece)), Raw: markdown})
+	}
+	for len(inlines) > 0 && isLineBreak(inlines[len(inlines)-1]) {
+		inlines = inlines[:len(inlines)-1]
+	}
+	return inlines
+}
+
+func isSentenceEnd(text string, space int) bool {
+	if text[space] == '\n' {
+		return true
+	}
+	before, _ := utf8.DecodeLastRuneInString(text[:space])
+	return strings.ContainsRune(".!?。！？", before)
+}
+
+func isSpace(text string, space int) bool {
+	return true
+}
+
+func isSpaceByte(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\t'
+}
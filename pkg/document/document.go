@@ -0,0 +1,272 @@
+// Package document is the format-neutral tree that sits between the
+// parsers and the Markdown renderer. Every converter turns its input into
+// a Document, and a single renderer turns the Document into Markdown, so
+// rendering options behave the same whatever the source format.
+package document
+
+import "any2md/internal/domain"
+
+// Position is where a node comes from in its source. Fields are zero when
+// unknown: Page is set for paged formats such as PDF, Line and EndLine
+// (1-based, inclusive) when the node was read from text. When a source
+// map is asked for, Path, Offset and EndOffset locate the HTML element of
+// a block (its CSS path and the bytes from its start tag to the end of its
+// end tag) and BBox the area of a PDF block on its page.
+type Position struct {
+	Page      int       `json:"page,omitempty"`
+	Line      int       `json:"line,omitempty"`
+	EndLine   int       `json:"end_line,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Offset    int       `json:"offset,omitempty"`
+	EndOffset int       `json:"end_offset,omitempty"`
+	BBox      []float64 `json:"bbox,omitempty"`
+}
+
+// Block is a block-level node: a heading, a paragraph, a list...
+type Block interface {
+	Position() Position
+	SetPosition(pos Position)
+	attached() bool
+}
+
+// Inline is a node of the text of a block.
+type Inline interface {
+	inline()
+}
+
+// blockBase is embedded in every block. Attached records that the block
+// followed the previous one without a blank line in the source; the
+// renderer keeps them together where Markdown reads them the same way.
+type blockBase struct {
+	Pos      Position
+	Attached bool
+}
+
+// Position returns where the block comes from.
+func (b *blockBase) Position() Position { return b.Pos }
+
+func (b *blockBase) SetPosition(pos Position) { b.Pos = pos }
+
+func (b *blockBase) attached() bool { return b.Attached }
+
+// Heading is a section heading of level 1 to 6. Setext records that the
+// source used an underlined heading, which is only a hint for rendering.
+type Heading struct {
+	blockBase
+	Level   int
+	Setext  bool
+	Content []Inline
+}
+
+type Paragraph struct {
+	blockBase
+	Content []Inline
+}
+
+// List is a bullet or ordered list. Marker is the bullet character or, for
+// ordered lists, the delimiter after the number ('.' or ')'); zero leaves
+// the choice to the renderer. Tight lists have no blank lines between
+// their items.
+type List struct {
+	blockBase
+	Ordered bool
+	Start   int
+	Marker  byte
+	Tight   bool
+	Items   []*ListItem
+}
+
+// ListItem is an item of a list. In a loose list, Attached items follow the
+// previous item without a blank line in between.
+type ListItem struct {
+	Blocks   []Block
+	Attached bool
+}
+
+// CodeBlock is preformatted code, with its language when known.
+type CodeBlock struct {
+	blockBase
+	Language string
+	Code     string
+}
+
+type BlockQuote struct {
+	blockBase
+	Blocks []Block
+}
+
+// Alignment is the alignment of a table column.
+type Alignment int
+
+const (
+	AlignNone Alignment = iota
+	AlignLeft
+	AlignCenter
+	AlignRight
+)
+
+// Table is a table with one header row. Rows may have fewer cells than
+// the header.
+type Table struct {
+	blockBase
+	Align  []Alignment
+	Header []Cell
+	Rows   [][]Cell
+}
+
+// Cell is the content of a table cell.
+type Cell []Inline
+
+// ThematicBreak separates sections. Marker is the source line ("---",
+// "* * *"), used as a rendering hint when set.
+type ThematicBreak struct {
+	blockBase
+	Marker string
+}
+
+// HTMLBlock is raw HTML kept as is in the output.
+type HTMLBlock struct {
+	blockBase
+	HTML string
+}
+
+// Text is plain text. Value is the text itself; Raw, when set, is the
+// Markdown it was read from, escapes included, and is written unchanged.
+type Text struct {
+	Value string
+	Raw   string
+}
+
+// LineBreak ends a line inside a block. Soft breaks are rendered as a
+// newline, hard breaks force one in the rendered output too.
+type LineBreak struct {
+	Hard bool
+}
+
+// Emphasis is italic or, when Strong, bold text.
+type Emphasis struct {
+	Strong  bool
+	Content []Inline
+}
+
+// Code is inline code.
+type Code struct {
+	Value string
+}
+
+// Link is a hyperlink. Autolink marks `<https://...>` links and Bare marks
+// URLs found in plain text, which are only turned into Markdown links
+// when the link style asks for references.
+type Link struct {
+	Destination string
+	Title       string
+	Content     []Inline
+	Autolink    bool
+	Bare        bool
+}
+
+type Image struct {
+	Source string
+	Title  string
+	Alt    []Inline
+}
+
+// RawHTML is inline HTML kept as is in the output.
+type RawHTML struct {
+	Value string
+}
+
+// FootnoteReference points to the footnote with the same label.
+type FootnoteReference struct {
+	Label string
+}
+
+func (*Text) inline()              {}
+func (*LineBreak) inline()         {}
+func (*Emphasis) inline()          {}
+func (*Code) inline()              {}
+func (*Link) inline()              {}
+func (*Image) inline()             {}
+func (*RawHTML) inline()           {}
+func (*FootnoteReference) inline() {}
+
+// Footnote is the definition of a footnote.
+type Footnote struct {
+	Label  string
+	Blocks []Block
+}
+
+// Document is a whole converted document. It implements
+// domain.ParsedDocument.
+type Document struct {
+	Blocks    []Block
+	Footnotes []*Footnote
+	Metadata  *domain.Metadata
+}
+
+// GetStats counts the elements of the document.
+func (d *Document) GetStats() domain.ElementsCount {
+	var stats domain.ElementsCount
+	var inlines func([]Inline)
+	inlines = func(nodes []Inline) {
+		for _, node := range nodes {
+			switch n := node.(type) {
+			case *Emphasis:
+				inlines(n.Content)
+			case *Link:
+				stats.Links++
+				inlines(n.Content)
+			case *Image:
+				stats.Images++
+			}
+		}
+	}
+	Walk(d, func(block Block) {
+		switch b := block.(type) {
+		case *Heading:
+			stats.Headings++
+			inlines(b.Content)
+		case *Paragraph:
+			stats.Paragraphs++
+			inlines(b.Content)
+		case *List:
+			stats.Lists++
+		case *CodeBlock:
+			stats.CodeBlocks++
+		case *Table:
+			stats.Tables++
+			for _, cell := range b.Header {
+				inlines(cell)
+			}
+			for _, row := range b.Rows {
+				for _, cell := range row {
+					inlines(cell)
+				}
+			}
+		}
+	})
+	return stats
+}
+
+// Walk calls fn for every block of the document, footnotes included,
+// parents before their children.
+func Walk(d *Document, fn func(Block)) {
+	var walk func([]Block)
+	walk = func(blocks []Block) {
+		for _, block := range blocks {
+			fn(block)
+			switch b := block.(type) {
+			case *List:
+				for _, item := range b.Items {
+					walk(item.Blocks)
+				}
+			case *BlockQuote:
+				walk(b.Blocks)
+			}
+		}
+	}
+	walk(d.Blocks)
+	for _, note := range d.Footnotes {
+		walk(note.Blocks)
+	}
+}
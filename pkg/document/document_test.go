@@ -0,0 +1,377 @@
+package document
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+	"any2md/internal/domain"
+)
+
+const sample = `Title
+=====
+
+Some _text_ with **bold**, ` + "`code`" + ` and a [link](https://go.dev "Go").
+
+- one
+- two
+  - nested
+
+1) first
+2) second
+
+> quoted
+
+` + "```go\nfmt.Println(\"hi\")\n```" + `
+
+---
+
+| Name | Size |
+| :-- | --: |
+| a\|b | 1 |
+
+See the note[^n].
+
+[^n]: A note.
+
+    Second paragraph.`
+
+func render(t *testing.T, doc *Document, options domain.ConversionOptions) string {
+	t.Helper()
+	markdown, err := NewMarkdownRenderer().Render(doc, options)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	return markdown
+}
+
+func TestParseMarkdownRoundTrip(t *testing.T) {
+	doc := ParseMarkdown(sample)
+	if got := render(t, doc, domain.ConversionOptions{}); got != sample {
+		t.Errorf("Expected the Markdown back unchanged, got:\n%s", got)
+	}
+
+	want := domain.ElementsCount{Headings: 1, Paragraphs: 10, Links: 1, Lists: 3, CodeBlocks: 1, Tables: 1}
+	if stats := doc.GetStats(); stats != want {
+		t.Errorf("Expected stats %+v, got %+v", want, stats)
+	}
+	if pos := doc.Blocks[1].Position(); pos.Line != 4 || pos.EndLine != 4 {
+		t.Errorf("Expected the paragraph on line 4, got %+v", pos)
+	}
+	table := doc.Blocks[7].(*Table)
+	if cell := table.Rows[0][0][0].(*Text); cell.Value != "a|b" || table.Align[1] != AlignRight {
+		t.Errorf("Unexpected table %+v", table)
+	}
+}
+
+func TestMarkdownRendererOptions(t *testing.T) {
+	doc := ParseMarkdown(sample)
+	got := render(t, doc, domain.ConversionOptions{
+		HeadingStyle:     "atx",
+		BulletListMarker: "*",
+		CodeBlockStyle:   "indented",
+		EmDelimiter:      "*",
+		StrongDelimiter:  "__",
+		LinkStyle:        "referenced",
+	})
+
+	for _, substr := range []string{
+		"# Title",
+		"Some *text* with __bold__, `code` and a [link][1].",
+		"* one\n* two\n  * nested",
+		"1) first\n2) second",
+		"    fmt.Println(\"hi\")",
+		"[1]: https://go.dev \"Go\"\n\n[^n]: A note.",
+	} {
+		if !strings.Contains(got, substr) {
+			t.Errorf("Expected markdown to contain %q, got:\n%s", substr, got)
+		}
+	}
+}
+
+func TestMarkdownRendererBuiltTree(t *testing.T) {
+	heading := &Heading{Level: 2, Content: []Inline{&Text{Value: "Intro"}}}
+	heading.Pos.Page = 1
+	doc := &Document{Blocks: []Block{
+		heading,
+		&Paragraph{Content: []Inline{
+			&Text{Value: "1. Not a list, *not* emphasis and snake_case; see "},
+			&Link{Destination: "https://example.com/a", Bare: true},
+			&Text{Value: " and "},
+			&Emphasis{Strong: true, Content: []Inline{&Text{Value: "bold"}}},
+			&Text{Value: "text."},
+		}},
+		&List{Items: []*ListItem{
+			{Blocks: []Block{&Paragraph{Content: []Inline{&Text{Value: "a"}}}}},
+			{Blocks: []Block{&Paragraph{Content: []Inline{&Text{Value: "b"}}}}},
+		}},
+		&ThematicBreak{},
+	}}
+
+	got := render(t, doc, domain.ConversionOptions{HeadingStyle: "setext", StrongDelimiter: "__"})
+	want := "Intro\n-----\n\n" +
+		"1\\. Not a list, \\*not\\* emphasis and snake_case; see https://example.com/a and **bold**text.\n\n" +
+		"- a\n\n- b\n\n* * *"
+	if got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	got = render(t, doc, domain.ConversionOptions{LinkStyle: "referenced", LinkReferenceStyle: "shortcut"})
+	if !strings.Contains(got, "see [https://example.com/a] and") || !strings.HasSuffix(got, "[https://example.com/a]: https://example.com/a") {
+		t.Errorf("Expected a shortcut reference for the bare URL, got:\n%s", got)
+	}
+
+	// a text that already labels another target gets a numbered reference
+	link := func(text, url string) *Link {
+		return &Link{Destination: url, Content: []Inline{&Text{Value: text}}}
+	}
+	links := &Document{Blocks: []Block{&Paragraph{Content: []Inline{
+		link("here", "http://a"), &Text{Value: " and "}, link("Here", "http://b"), &Text{Value: ", "},
+		link("2", "http://c"), &Text{Value: " and "}, link("here", "http://a"), &Text{Value: "."},
+	}}}}
+	for style, want := range map[string]string{
+		"shortcut":  "[here] and [Here][2], [2][3] and [here].\n\n[here]: http://a\n[2]: http://b\n[3]: http://c",
+		"collapsed": "[here][] and [Here][2], [2][3] and [here][].\n\n[here]: http://a\n[2]: http://b\n[3]: http://c",
+	} {
+		if got := render(t, links, domain.ConversionOptions{LinkStyle: "referenced", LinkReferenceStyle: style}); got != want {
+			t.Errorf("Expected %s references:\n%s\ngot:\n%s", style, want, got)
+		}
+	}
+
+	if _, err := NewMarkdownRenderer().Render(nil, domain.ConversionOptions{}); err == nil {
+		t.Error("Expected an error for a document of another type")
+	}
+}
+
+// TestMarkdownRendererFlavors renders the sample in every flavor and reads
+// it back with goldmark: plain CommonMark for the commonmark and plain
+// flavors, with the table and footnote syntax MultiMarkdown and Pandoc
+// share for theirs.
+func TestMarkdownRendererFlavors(t *testing.T) {
+	doc := ParseMarkdown(sample + "\n\nCosts $5 for @alice, see https://go.dev.")
+	doc.Blocks = append(doc.Blocks, &Paragraph{Content: []Inline{
+		&Text{Value: "2^10 is $1024 at "},
+		&Link{Destination: "https://example.com/a", Bare: true},
+	}})
+	unsafe := goldmark.WithRendererOptions(html.WithUnsafe())
+	commonMark := goldmark.New(unsafe)
+	extended := goldmark.New(unsafe, goldmark.WithExtensions(extension.Table, extension.Footnote))
+
+	tests := []struct {
+		flavor  string
+		parser  goldmark.Markdown
+		source  string
+		want    []string
+		exclude []string
+	}{
+		{
+			flavor: "commonmark",
+			parser: commonMark,
+			want: []string{
+				"<h1>Title</h1>",
+				`<th style="text-align: right">Size</th>`,
+				"<td style=\"text-align: left\">a|b</td>",
+				`<sup><a href="#fn-n">n</a></sup>`,
+				`<p><sup id="fn-n">n</sup> A note.</p>`,
+				`<a href="https://example.com/a">https://example.com/a</a>`,
+			},
+			exclude: []string{"[^n]", "| Name"},
+		},
+		{
+			flavor: "multimarkdown",
+			parser: extended,
+			source: "2\\^10 is \\$1024 at <https://example.com/a>",
+			want: []string{
+				"<h1>Title</h1>",
+				"<ul>\n<li>one</li>\n<li>two\n<ul>\n<li>nested</li>",
+				"<ol>\n<li>first</li>",
+				`<th style="text-align:right">Size</th>`,
+				`<a href="#fn:1" class="footnote-ref" role="doc-noteref">1</a>`,
+				"<p>Second paragraph.",
+				"2^10 is $1024 at",
+				"Costs $5 for @alice",
+			},
+		},
+		{
+			flavor: "pandoc",
+			parser: extended,
+			source: "Costs \\$5 for \\@alice",
+			want: []string{
+				"<h1>Title</h1>",
+				"<ol>\n<li>first</li>",
+				`<td style="text-align:left">a|b</td>`,
+				`<a href="#fn:1" class="footnote-ref" role="doc-noteref">1</a>`,
+				"<p>Second paragraph.",
+				"Costs $5 for @alice",
+				`<a href="https://example.com/a">https://example.com/a</a>`,
+			},
+		},
+		{
+			flavor:  "plain",
+			parser:  commonMark,
+			want:    []string{"<p>Title</p>", "Some text with bold, code and a link (https://go.dev).", "Name\tSize\na|b\t1", "[n] A note."},
+			exclude: []string{"<h1>", "<em>", "<strong>", "<code>", "<a ", "<table>", "<blockquote>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flavor, func(t *testing.T) {
+			markdown := render(t, doc, domain.ConversionOptions{Flavor: tt.flavor})
+			if !strings.Contains(markdown, tt.source) {
+				t.Errorf("Expected markdown to contain %q, got:\n%s", tt.source, markdown)
+			}
+			var b bytes.Buffer
+			if err := tt.parser.Convert([]byte(markdown), &b); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			got := b.String()
+			for _, substr := range tt.want {
+				if !strings.Contains(got, substr) {
+					t.Errorf("Expected HTML to contain %q, got:\n%s\nfrom:\n%s", substr, got, markdown)
+				}
+			}
+			for _, substr := range tt.exclude {
+				if strings.Contains(got, substr) {
+					t.Errorf("Expected HTML not to contain %q, got:\n%s\nfrom:\n%s", substr, got, markdown)
+				}
+			}
+		})
+	}
+}
+
+func TestDocumentStructure(t *testing.T) {
+	doc := ParseMarkdown("Intro with ![logo](logo.png).\n\n" +
+		"# Guide\n\nSee [the docs](https://go.dev).\n\n" +
+		"## Setup\n\n- one\n- two\n\n| Key | Value |\n| --- | --- |\n| a | `1` |\n\n" +
+		"## Setup\n\n```sh\nmake\n```\n\n" +
+		"# Appendix\n\nDone.")
+	structure := doc.Structure(domain.ConversionOptions{LinkStyle: "referenced"})
+
+	if structure.Title != "Guide" || len(structure.Sections) != 3 {
+		t.Fatalf("Expected the preamble and two top level sections titled Guide, got %+v", structure)
+	}
+	preamble, guide := structure.Sections[0], structure.Sections[1]
+	if preamble.ID != "preamble" || preamble.Level != 0 || len(preamble.Images) != 1 || preamble.Images[0].Alt != "logo" {
+		t.Errorf("Unexpected preamble %+v", preamble)
+	}
+	if guide.ID != "guide" || len(guide.Links) != 1 || guide.Blocks[0].Markdown != "See [the docs](https://go.dev)." {
+		t.Errorf("Expected an inlined link in the guide section, got %+v", guide)
+	}
+
+	if len(guide.Sections) != 2 || guide.Sections[0].ID != "setup" || guide.Sections[1].ID != "setup-1" {
+		t.Fatalf("Expected two numbered setup subsections, got %+v", guide.Sections)
+	}
+	setup := guide.Sections[0]
+	if list := setup.Blocks[0]; list.ID != "setup/1" || list.Type != "list" || strings.Join(list.Items, ",") != "one,two" {
+		t.Errorf("Unexpected list block %+v", list)
+	}
+	if table := setup.Blocks[1]; table.Type != "table" || strings.Join(table.Header, ",") != "Key,Value" || table.Rows[0][1] != "1" {
+		t.Errorf("Unexpected table block %+v", table)
+	}
+	if code := guide.Sections[1].Blocks[0]; code.Type != "code" || code.Language != "sh" || code.Text != "make" {
+		t.Errorf("Unexpected code block %+v", code)
+	}
+}
+
+func TestDocumentChunks(t *testing.T) {
+	table := "| Key | Value |\n| --- | --- |\n| alpha | one |\n| beta | two |\n| gamma | three |"
+	doc := ParseMarkdown("# Guide\n\n## Install\n\nFirst paragraph here.\n\nSecond paragraph here.\n\nThird paragraph here.\n\n" +
+		table + "\n\n## Use\n\nRun it now. Then stop it again. Then run it once more.")
+	chunks, err := doc.Chunks(domain.ConversionOptions{Chunking: &domain.ChunkingOptions{MaxChars: 60, Overlap: 25}})
+	if err != nil {
+		t.Fatalf("Chunks() error = %v", err)
+	}
+
+	want := []struct {
+		markdown   string
+		breadcrumb string
+		overlap    int
+	}{
+		{"# Guide\n\n## Install\n\nFirst paragraph here.", "Guide/Install", 0},
+		{"First paragraph here.\n\nSecond paragraph here.", "Guide/Install", 23},
+		{"Second paragraph here.\n\nThird paragraph here.", "Guide/Install", 24},
+		{table, "Guide/Install", 0},
+		{"## Use\n\nRun it now. Then stop it again.", "Guide/Use", 0},
+		{"Then run it once more.", "Guide/Use", 0},
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("Expected %d chunks, got %+v", len(want), chunks)
+	}
+	for i, w := range want {
+		chunk := chunks[i]
+		if chunk.Index != i || chunk.Markdown != w.markdown || strings.Join(chunk.Breadcrumb, "/") != w.breadcrumb || chunk.Overlap != w.overlap {
+			t.Errorf("Chunk %d: expected %q in %s with overlap %d, got %+v", i, w.markdown, w.breadcrumb, w.overlap, chunk)
+		}
+	}
+
+	// page ranges come from the positions of the blocks
+	first, second := &Paragraph{Content: []Inline{&Text{Value: "a"}}}, &Paragraph{Content: []Inline{&Text{Value: "b"}}}
+	first.Pos.Page, second.Pos.Page = 2, 3
+	chunks, _ = (&Document{Blocks: []Block{first, second}}).Chunks(domain.ConversionOptions{Chunking: &domain.ChunkingOptions{}})
+	if len(chunks) != 1 || chunks[0].PageStart != 2 || chunks[0].PageEnd != 3 || chunks[0].Tokens != 3 {
+		t.Errorf("Expected a single chunk of pages 2 to 3, got %+v", chunks)
+	}
+
+	// long paragraphs are cut in their text only, never inside inline syntax
+	doc = ParseMarkdown("word word word **a bold phrase that is long** and [a link with many words inside it](https://example.com/x) " +
+		"and ==a marked phrase here== and <kbd>Ctrl and Shift</kbd> end.")
+	chunks, _ = doc.Chunks(domain.ConversionOptions{Chunking: &domain.ChunkingOptions{MaxChars: 40}})
+	var pieces []string
+	for _, chunk := range chunks {
+		pieces = append(pieces, chunk.Markdown)
+	}
+	if want := []string{"word word word", "**a bold phrase that is long** and", "[a link with many words inside it](https://example.com/x)",
+		"and ==a marked phrase here== and", "<kbd>Ctrl and Shift</kbd> end."}; !reflect.DeepEqual(pieces, want) {
+		t.Errorf("Expected chunks %q, got %q", want, pieces)
+	}
+
+	// chunks carry the definitions of the footnotes they refer to, and of
+	// the footnotes these refer to
+	doc = ParseMarkdown("# A\n\nSee the note[^n].\n\n# B\n\nNo notes here.\n\n# C\n\nAgain[^n], and[^m].\n\n[^n]: A note[^d].\n[^m]: More.\n[^d]: Deeper.")
+	chunks, _ = doc.Chunks(domain.ConversionOptions{Chunking: &domain.ChunkingOptions{}})
+	pieces = nil
+	for _, chunk := range chunks {
+		pieces = append(pieces, chunk.Markdown)
+	}
+	if want := []string{"# A\n\nSee the note[^n].\n\n[^n]: A note[^d].\n[^d]: Deeper.", "# B\n\nNo notes here.",
+		"# C\n\nAgain[^n], and[^m].\n\n[^n]: A note[^d].\n[^m]: More.\n[^d]: Deeper."}; !reflect.DeepEqual(pieces, want) {
+		t.Errorf("Expected chunks %q, got %q", want, pieces)
+	}
+
+	if _, err := doc.Chunks(domain.ConversionOptions{Tokenizer: "unknown"}); err == nil {
+		t.Error("Expected an error for an unknown tokenizer")
+	}
+}
+
+func TestDocumentSourceMap(t *testing.T) {
+	heading := &Heading{Level: 1, Content: []Inline{&Text{Value: "Report"}}}
+	heading.Pos = Position{Page: 1, BBox: []float64{72, 700, 200, 720}}
+	paragraph := &Paragraph{Content: []Inline{&Text{Value: "First quarter results were good."}}}
+	paragraph.Pos = Position{Path: "html > body > p", Offset: 12, EndOffset: 60}
+	code := &CodeBlock{Code: "total := 1"}
+	doc := &Document{Blocks: []Block{heading, &ThematicBreak{}, paragraph, code}}
+
+	// front matter and a wrapped paragraph, as the converters write them
+	markdown := "---\ntitle: Report\n---\n\n" + "Report\n======\n\n* * *\n\nFirst quarter results\nwere good.\n\n```\ntotal := 1\n```"
+	entries := doc.SourceMap(markdown)
+	if len(entries) != 3 {
+		t.Fatalf("Expected an entry per block, got %+v", entries)
+	}
+	for i, want := range []string{"Report\n======", "First quarter results\nwere good.", "```\ntotal := 1\n```"} {
+		if got := markdown[entries[i].Offset:entries[i].EndOffset]; got != want {
+			t.Errorf("Entry %d: expected %q, got %q", i, want, got)
+		}
+	}
+	if e := entries[0]; e.Type != "heading" || e.Line != 5 || e.EndLine != 6 || e.PDF == nil || e.PDF.Page != 1 || len(e.PDF.BBox) != 4 {
+		t.Errorf("Unexpected heading entry %+v", e)
+	}
+	if e := entries[1]; e.HTML == nil || e.HTML.Path != "html > body > p" || e.HTML.Offset != 12 {
+		t.Errorf("Unexpected paragraph entry %+v", e)
+	}
+	if e := entries[2]; e.Type != "code" || e.Line != 13 || e.EndLine != 15 || e.HTML != nil || e.PDF != nil {
+		t.Errorf("Unexpected code entry %+v", e)
+	}
+}
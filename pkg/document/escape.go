@@ -0,0 +1,111 @@
+package document
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// blockMarkerR matches text that would start a block if it began a line,
+// or turn the line before it into a setext heading.
+var blockMarkerR = regexp.MustCompile(`^(?:#{1,6}|[+>]|-+|=+|\d{1,9}[.)])(?:[ \t]|$)`)
+
+// escapeText escapes the characters of plain text that Markdown would
+// read as syntax. Underscores inside words and backslashes that don't
+// precede punctuation are left alone; at the start of a line, text that
+// looks like a heading, list item or quote is escaped too. In table cells
+// pipes are escaped as well.
+func escapeText(text string, lineStart, cell bool) string {
+	var b strings.Builder
+	for i, c := range text {
+		switch c {
+		case '*', '`', '[', ']':
+			b.WriteByte('\\')
+		case '_':
+			before, _ := utf8.DecodeLastRuneInString(text[:i])
+			after, _ := utf8.DecodeRuneInString(text[i+1:])
+			if !isAlphanumeric(before) || !isAlphanumeric(after) {
+				b.WriteByte('\\')
+			}
+		case '\\':
+			if after, _ := utf8.DecodeRuneInString(text[i+1:]); after == 0 || after < utf8.RuneSelf && isPunctuation(after) {
+				b.WriteByte('\\')
+			}
+		case '<':
+			if after, _ := utf8.DecodeRuneInString(text[i+1:]); after == '/' || after == '!' || after == '?' || unicode.IsLetter(after) {
+				b.WriteByte('\\')
+			}
+		case '|':
+			if cell {
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteRune(c)
+	}
+
+	escaped := b.String()
+	if lineStart {
+		escaped = escapeLineStart(escaped)
+	}
+	return escaped
+}
+
+// escapeLineStart escapes Markdown text that would start a heading, list
+// item, quote or thematic break at the start of a line.
+func escapeLineStart(markdown string) string {
+	if m := blockMarkerR.FindString(markdown); m != "" {
+		if c := m[0]; c >= '0' && c <= '9' {
+			n := strings.IndexAny(m, ".)")
+			return markdown[:n] + "\\" + markdown[n:]
+		}
+		return "\\" + markdown
+	}
+	return markdown
+}
+
+// escapeClosingSequence escapes the #s that end the text of an ATX
+// heading, which would be read as its closing sequence.
+func escapeClosingSequence(text string) string {
+	start := len(strings.TrimRight(text, "#"))
+	if start == len(text) || start > 0 && text[start-1] != ' ' {
+		return text
+	}
+	return text[:start] + "\\" + text[start:]
+}
+
+func isAlphanumeric(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// CanOpen and CanClose apply the CommonMark flanking rules to a delimiter
+// run between the characters before and after it (0 at a line edge).
+func CanOpen(marker byte, before, after rune) bool {
+	if !leftFlanking(before, after) {
+		return false
+	}
+	return marker == '*' || !rightFlanking(before, after) || isPunctuation(before)
+}
+
+func CanClose(marker byte, before, after rune) bool {
+	if !rightFlanking(before, after) {
+		return false
+	}
+	return marker == '*' || !leftFlanking(before, after) || isPunctuation(after)
+}
+
+func leftFlanking(before, after rune) bool {
+	return !isBlank(after) && (!isPunctuation(after) || isBlank(before) || isPunctuation(before))
+}
+
+func rightFlanking(before, after rune) bool {
+	return !isBlank(before) && (!isPunctuation(before) || isBlank(after) || isPunctuation(after))
+}
+
+func isBlank(r rune) bool {
+	return r == 0 || unicode.IsSpace(r)
+}
+
+func isPunctuation(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
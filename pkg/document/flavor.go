@@ -0,0 +1,267 @@
+package document
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	nethtml "golang.org/x/net/html"
+)
+
+// Flavors accepted in ConversionOptions.Flavor: the syntax the renderer
+// writes. GFM is the default.
+const (
+	FlavorGFM           = "gfm"
+	FlavorCommonMark    = "commonmark"
+	FlavorMultiMarkdown = "multimarkdown"
+	FlavorPandoc        = "pandoc"
+	FlavorPlain         = "plain"
+)
+
+// metadataLineR matches a line MultiMarkdown would read as metadata at
+// the start of a document.
+var metadataLineR = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} _-]*:`)
+
+// cellRenderer turns the Markdown of a table cell into HTML for the
+// CommonMark flavor, which has no tables.
+var cellRenderer = goldmark.New(goldmark.WithRendererOptions(gmhtml.WithUnsafe()))
+
+// bareLink renders a URL found in plain text. Only GFM links it without
+// markup; the other flavors need an autolink.
+func (r *renderer) bareLink(url string) string {
+	if r.options.Flavor == "" || r.options.Flavor == FlavorGFM || !strings.Contains(url, ":") {
+		return url
+	}
+	return "<" + url + ">"
+}
+
+// flavorDocument makes sure that a MultiMarkdown document doesn't start
+// with a line that reads as metadata, by escaping its colon.
+func (r *renderer) flavorDocument(markdown string) string {
+	if r.options.Flavor != FlavorMultiMarkdown {
+		return markdown
+	}
+	if m := metadataLineR.FindString(markdown); m != "" {
+		return m[:len(m)-1] + "\\" + markdown[len(m)-1:]
+	}
+	return markdown
+}
+
+// itemPrefix returns the marker of a list item followed by the space
+// before its content. MultiMarkdown follows Markdown.pl, where the
+// content of nested blocks is indented by four spaces at least.
+func (r *renderer) itemPrefix(marker string) string {
+	width := len(marker) + 1
+	if r.options.Flavor == FlavorMultiMarkdown && width < 4 {
+		width = 4
+	}
+	return marker + strings.Repeat(" ", width-len(marker))
+}
+
+// flavorText escapes what the MultiMarkdown and Pandoc extensions would
+// read in text: math between dollars, superscripts, subscripts and, for
+// Pandoc, citations. Escaped text is written through unchanged.
+func (r *renderer) flavorText(text string, raw bool) string {
+	if r.options.Flavor != FlavorMultiMarkdown && r.options.Flavor != FlavorPandoc {
+		return text
+	}
+	var b strings.Builder
+	escaped := false
+	for i, c := range text {
+		if escaped {
+			escaped = false
+			b.WriteRune(c)
+			continue
+		}
+		switch c {
+		case '\\':
+			escaped = true
+		case '$':
+			b.WriteByte('\\')
+		case '^', '~':
+			// in Markdown read back, these may already be the syntax of
+			// semantic elements
+			if !raw {
+				b.WriteByte('\\')
+			}
+		case '@':
+			before, _ := utf8.DecodeLastRuneInString(text[:i])
+			after, _ := utf8.DecodeRuneInString(text[i+1:])
+			if r.options.Flavor == FlavorPandoc && !isAlphanumeric(before) && (unicode.IsLetter(after) || after == '_') {
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// htmlTable renders a table as an HTML block, for the CommonMark flavor.
+// The cells are rendered as Markdown first, then as HTML.
+func (r *renderer) htmlTable(table *Table) string {
+	row := func(cells []Cell, tag string) string {
+		var b strings.Builder
+		b.WriteString("<tr>\n")
+		for col := range table.Header {
+			attributes := ""
+			if col < len(table.Align) {
+				switch table.Align[col] {
+				case AlignLeft:
+					attributes = ` style="text-align: left"`
+				case AlignCenter:
+					attributes = ` style="text-align: center"`
+				case AlignRight:
+					attributes = ` style="text-align: right"`
+				}
+			}
+			content := ""
+			if col < len(cells) {
+				content = r.htmlCell(cells[col])
+			}
+			b.WriteString("<" + tag + attributes + ">" + content + "</" + tag + ">\n")
+		}
+		b.WriteString("</tr>")
+		return b.String()
+	}
+
+	lines := []string{"<table>", "<thead>", row(table.Header, "th"), "</thead>"}
+	if len(table.Rows) > 0 {
+		lines = append(lines, "<tbody>")
+		for _, cells := range table.Rows {
+			lines = append(lines, row(cells, "td"))
+		}
+		lines = append(lines, "</tbody>")
+	}
+	lines = append(lines, "</table>")
+	return strings.Join(lines, "\n")
+}
+
+func (r *renderer) htmlCell(cell Cell) string {
+	markdown := strings.ReplaceAll(r.cell(cell), "\n", "<br>")
+	var b bytes.Buffer
+	if err := cellRenderer.Convert([]byte(markdown), &b); err != nil {
+		return html.EscapeString(plainText(cell))
+	}
+	content := strings.TrimSpace(b.String())
+	content = strings.TrimSuffix(strings.TrimPrefix(content, "<p>"), "</p>")
+	// an HTML block ends at a blank line
+	return strings.ReplaceAll(content, "\n", " ")
+}
+
+// footnoteReference renders a reference to a footnote. CommonMark has no
+// footnotes: the reference is a superscript link to the note.
+func (r *renderer) footnoteReference(label string) string {
+	switch r.options.Flavor {
+	case FlavorCommonMark:
+		return `<sup><a href="#fn-` + html.EscapeString(label) + `">` + html.EscapeString(label) + `</a></sup>`
+	case FlavorPlain:
+		return "[" + label + "]"
+	}
+	return "[^" + label + "]"
+}
+
+// footnote renders the definition of a footnote. In CommonMark the note
+// is a paragraph starting with the target of its references.
+func (r *renderer) footnote(note *Footnote) string {
+	content := r.blocks(note.Blocks, false)
+	switch r.options.Flavor {
+	case FlavorCommonMark:
+		anchor := `<sup id="fn-` + html.EscapeString(note.Label) + `">` + html.EscapeString(note.Label) + `</sup>`
+		if len(note.Blocks) > 0 {
+			if _, ok := note.Blocks[0].(*Paragraph); ok {
+				return anchor + " " + content
+			}
+		}
+		return strings.TrimSpace(anchor + "\n\n" + content)
+	case FlavorPlain:
+		return "[" + note.Label + "] " + content
+	}
+	return "[^" + note.Label + "]: " + indent(content, "    ")
+}
+
+// plainBlock renders a block as plain text: headings, quotes and code
+// lose their markup, list items keep their marker and table cells are
+// separated by tabs.
+func (r *renderer) plainBlock(block Block) string {
+	switch b := block.(type) {
+	case *Heading:
+		return strings.ReplaceAll(r.plainInlines(b.Content), "\n", " ")
+	case *Paragraph:
+		return r.plainInlines(b.Content)
+	case *List:
+		return r.list(b)
+	case *CodeBlock:
+		return b.Code
+	case *BlockQuote:
+		return r.blocks(b.Blocks, false)
+	case *Table:
+		lines := []string{r.plainRow(b.Header)}
+		for _, cells := range b.Rows {
+			lines = append(lines, r.plainRow(cells))
+		}
+		return strings.Join(lines, "\n")
+	case *HTMLBlock:
+		return htmlText(b.HTML)
+	}
+	return ""
+}
+
+func (r *renderer) plainRow(cells []Cell) string {
+	texts := make([]string, len(cells))
+	for i, cell := range cells {
+		texts[i] = strings.ReplaceAll(r.plainInlines(cell), "\n", " ")
+	}
+	return strings.Join(texts, "\t")
+}
+
+// plainInlines renders inlines as plain text. Links keep their URL after
+// their text, in parentheses, and images are replaced by their alt text.
+func (r *renderer) plainInlines(inlines []Inline) string {
+	var b strings.Builder
+	for _, inline := range inlines {
+		switch n := inline.(type) {
+		case *Text:
+			b.WriteString(n.Value)
+		case *LineBreak:
+			b.WriteString("\n")
+		case *Emphasis:
+			b.WriteString(r.plainInlines(n.Content))
+		case *Code:
+			b.WriteString(n.Value)
+		case *Link:
+			text := r.plainInlines(n.Content)
+			switch {
+			case text == "":
+				b.WriteString(n.Destination)
+			case text == n.Destination || strings.TrimPrefix(n.Destination, "mailto:") == text || strings.HasPrefix(n.Destination, "#"):
+				b.WriteString(text)
+			default:
+				b.WriteString(text + " (" + n.Destination + ")")
+			}
+		case *Image:
+			b.WriteString(r.plainInlines(n.Alt))
+		case *FootnoteReference:
+			b.WriteString(r.footnoteReference(n.Label))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// htmlText returns the text of an HTML fragment.
+func htmlText(fragment string) string {
+	var b strings.Builder
+	z := nethtml.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch z.Next() {
+		case nethtml.ErrorToken:
+			return strings.TrimSpace(b.String())
+		case nethtml.TextToken:
+			b.Write(z.Text())
+		}
+	}
+}
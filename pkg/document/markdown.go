@@ -0,0 +1,303 @@
+package document
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// markdownParser reads CommonMark with GFM tables and footnotes. Other
+// extensions (strikethrough, task lists) are left out so that their
+// syntax stays in the text as written.
+var markdownParser = goldmark.New(
+	goldmark.WithExtensions(extension.Table, extension.Footnote),
+	goldmark.WithParserOptions(parser.WithBlockParsers(
+		util.Prioritized(thematicBreakParser{parser.NewThematicBreakParser()}, 199),
+	)),
+).Parser()
+
+// thematicBreakParser keeps the line of a thematic break, which goldmark
+// doesn't, as the lines of the node.
+type thematicBreakParser struct {
+	parser.BlockParser
+}
+
+func (p thematicBreakParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	_, segment := reader.PeekLine()
+	node, state := p.BlockParser.Open(parent, reader, pc)
+	if node != nil {
+		node.Lines().Append(segment)
+	}
+	return node, state
+}
+
+// ParseMarkdown reads Markdown into a Document. Text keeps the Markdown it
+// was read from, so rendering the document with the options that produced
+// the Markdown gives it back, modulo whitespace and link references.
+func ParseMarkdown(source string) *Document {
+	src := []byte(source)
+	r := &markdownReader{source: src, footnotes: make(map[int]string)}
+	for i, c := range src {
+		if c == '\n' {
+			r.lineStarts = append(r.lineStarts, i+1)
+		}
+	}
+
+	root := markdownParser.Parse(text.NewReader(src))
+	// footnote links only know the index of their definition
+	ast.Walk(root, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if note, ok := node.(*east.Footnote); ok && entering {
+			r.footnotes[note.Index] = string(note.Ref)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	doc := &Document{}
+	for child := root.FirstChild(); child != nil; child = child.NextSibling() {
+		if list, ok := child.(*east.FootnoteList); ok {
+			for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+				if note, ok := item.(*east.Footnote); ok {
+					doc.Footnotes = append(doc.Footnotes, &Footnote{Label: string(note.Ref), Blocks: r.blocks(note)})
+				}
+			}
+			continue
+		}
+		if block := r.block(child); block != nil {
+			doc.Blocks = append(doc.Blocks, block)
+		}
+	}
+	return doc
+}
+
+type markdownReader struct {
+	source     []byte
+	lineStarts []int
+	footnotes  map[int]string
+}
+
+func (r *markdownReader) blocks(parent ast.Node) []Block {
+	var blocks []Block
+	for child := parent.FirstChild(); child != nil; child = child.NextSibling() {
+		if block := r.block(child); block != nil {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+func (r *markdownReader) block(node ast.Node) Block {
+	base := blockBase{Pos: r.position(node)}
+	base.Attached = r.isAttached(node, base.Pos)
+	switch n := node.(type) {
+	case *ast.Heading:
+		return &Heading{blockBase: base, Level: n.Level, Setext: r.isSetext(n), Content: r.inlines(n)}
+	case *ast.Paragraph, *ast.TextBlock:
+		if n.Lines().Len() == 0 {
+			// what is left of link reference definitions
+			return nil
+		}
+		return &Paragraph{blockBase: base, Content: r.inlines(n)}
+	case *ast.List:
+		list := &List{blockBase: base, Ordered: n.IsOrdered(), Start: n.Start, Marker: n.Marker, Tight: n.IsTight}
+		for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+			list.Items = append(list.Items, &ListItem{Blocks: r.blocks(item), Attached: r.isAttached(item, r.position(item))})
+		}
+		return list
+	case *ast.CodeBlock:
+		return &CodeBlock{blockBase: base, Code: r.lines(n.Lines())}
+	case *ast.FencedCodeBlock:
+		language := ""
+		if n.Info != nil {
+			language = string(n.Info.Segment.Value(r.source))
+		}
+		return &CodeBlock{blockBase: base, Language: language, Code: r.lines(n.Lines())}
+	case *ast.Blockquote:
+		return &BlockQuote{blockBase: base, Blocks: r.blocks(n)}
+	case *ast.ThematicBreak:
+		return &ThematicBreak{blockBase: base, Marker: strings.TrimSpace(r.lines(n.Lines()))}
+	case *ast.HTMLBlock:
+		html := r.lines(n.Lines())
+		if n.HasClosure() {
+			if html != "" {
+				html += "\n"
+			}
+			html += string(bytes.TrimRight(n.ClosureLine.Value(r.source), "\n"))
+		}
+		return &HTMLBlock{blockBase: base, HTML: html}
+	case *east.Table:
+		table := &Table{blockBase: base}
+		for _, align := range n.Alignments {
+			switch align {
+			case east.AlignLeft:
+				table.Align = append(table.Align, AlignLeft)
+			case east.AlignCenter:
+				table.Align = append(table.Align, AlignCenter)
+			case east.AlignRight:
+				table.Align = append(table.Align, AlignRight)
+			default:
+				table.Align = append(table.Align, AlignNone)
+			}
+		}
+		for row := n.FirstChild(); row != nil; row = row.NextSibling() {
+			var cells []Cell
+			for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+				cells = append(cells, Cell(r.inlines(cell)))
+			}
+			if _, ok := row.(*east.TableHeader); ok {
+				table.Header = cells
+			} else {
+				table.Rows = append(table.Rows, cells)
+			}
+		}
+		return table
+	}
+	return nil
+}
+
+// isAttached reports whether a block directly follows its previous
+// sibling, without a blank line in between.
+func (r *markdownReader) isAttached(node ast.Node, pos Position) bool {
+	if node.PreviousSibling() == nil || pos.Line < 2 {
+		return false
+	}
+	start := 0
+	if pos.Line > 2 {
+		start = r.lineStarts[pos.Line-3]
+	}
+	return len(bytes.TrimSpace(r.source[start:r.lineStarts[pos.Line-2]])) > 0
+}
+
+// isSetext tells underlined headings from ATX ones by the line the heading
+// starts on.
+func (r *markdownReader) isSetext(heading *ast.Heading) bool {
+	if heading.Lines().Len() == 0 {
+		return false
+	}
+	start := heading.Lines().At(0).Start
+	for start > 0 && r.source[start-1] != '\n' {
+		start--
+	}
+	line := bytes.TrimLeft(r.source[start:], " ")
+	n := 0
+	for n < len(line) && line[n] == '#' {
+		n++
+	}
+	return n == 0 || n > 6 || (n < len(line) && line[n] != ' ' && line[n] != '\t' && line[n] != '\n')
+}
+
+// lines joins the lines of a code or HTML block without the final newline.
+func (r *markdownReader) lines(lines *text.Segments) string {
+	var b bytes.Buffer
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		b.WriteString(string(bytes.Repeat([]byte(" "), segment.Padding)))
+		b.Write(segment.Value(r.source))
+	}
+	return string(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+}
+
+func (r *markdownReader) inlines(parent ast.Node) []Inline {
+	var inlines []Inline
+	add := func(inline Inline) {
+		// goldmark splits text around every delimiter that didn't match
+		if text, ok := inline.(*Text); ok && len(inlines) > 0 {
+			if last, ok := inlines[len(inlines)-1].(*Text); ok {
+				last.Value += text.Value
+				last.Raw += text.Raw
+				return
+			}
+		}
+		inlines = append(inlines, inline)
+	}
+
+	for child := parent.FirstChild(); child != nil; child = child.NextSibling() {
+		switch n := child.(type) {
+		case *ast.Text:
+			raw := n.Segment.Value(r.source)
+			add(&Text{Value: unescape(raw), Raw: string(raw)})
+			if n.HardLineBreak() {
+				add(&LineBreak{Hard: true})
+			} else if n.SoftLineBreak() {
+				add(&LineBreak{})
+			}
+		case *ast.String:
+			add(&Text{Value: string(n.Value), Raw: string(n.Value)})
+		case *ast.CodeSpan:
+			var b bytes.Buffer
+			for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+				if t, ok := c.(*ast.Text); ok {
+					b.Write(t.Segment.Value(r.source))
+				} else if s, ok := c.(*ast.String); ok {
+					b.Write(s.Value)
+				}
+			}
+			add(&Code{Value: b.String()})
+		case *ast.Emphasis:
+			add(&Emphasis{Strong: n.Level > 1, Content: r.inlines(n)})
+		case *ast.Link:
+			add(&Link{Destination: unescape(n.Destination), Title: unescape(n.Title), Content: r.inlines(n)})
+		case *ast.AutoLink:
+			label := string(n.Label(r.source))
+			add(&Link{Destination: string(n.URL(r.source)), Content: []Inline{&Text{Value: label, Raw: label}}, Autolink: true})
+		case *ast.Image:
+			add(&Image{Source: unescape(n.Destination), Title: unescape(n.Title), Alt: r.inlines(n)})
+		case *ast.RawHTML:
+			var b bytes.Buffer
+			for i := 0; i < n.Segments.Len(); i++ {
+				segment := n.Segments.At(i)
+				b.Write(segment.Value(r.source))
+			}
+			add(&RawHTML{Value: b.String()})
+		case *east.FootnoteLink:
+			add(&FootnoteReference{Label: r.footnote(n.Index)})
+		}
+	}
+	return inlines
+}
+
+func (r *markdownReader) footnote(index int) string {
+	if label, ok := r.footnotes[index]; ok {
+		return label
+	}
+	return strconv.Itoa(index)
+}
+
+// position returns the lines of the source a block spans.
+func (r *markdownReader) position(node ast.Node) Position {
+	first, last := -1, -1
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering || n.Type() != ast.TypeBlock {
+			return ast.WalkContinue, nil
+		}
+		if lines := n.Lines(); lines.Len() > 0 {
+			if first < 0 {
+				first = lines.At(0).Start
+			}
+			last = lines.At(lines.Len() - 1).Start
+		}
+		return ast.WalkContinue, nil
+	})
+	if first < 0 {
+		return Position{}
+	}
+	return Position{Line: r.line(first), EndLine: r.line(last)}
+}
+
+func (r *markdownReader) line(offset int) int {
+	return sort.SearchInts(r.lineStarts, offset+1) + 1
+}
+
+// unescape resolves the backslash escapes and character references of
+// Markdown text.
+func unescape(source []byte) string {
+	return string(util.ResolveEntityNames(util.ResolveNumericReferences(util.UnescapePunctuations(source))))
+}
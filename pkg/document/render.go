@@ -0,0 +1,538 @@
+package document
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"any2md/internal/domain"
+	"any2md/pkg/errors"
+)
+
+// MarkdownRenderer renders a Document as Markdown. It implements
+// domain.MarkdownRenderer and is the only place where the rendering
+// options are applied, whatever the source format. Options that are set
+// win over the hints recorded in the tree (Heading.Setext,
+// ThematicBreak.Marker, List.Marker), which win over the defaults.
+type MarkdownRenderer struct{}
+
+func NewMarkdownRenderer() *MarkdownRenderer {
+	return &MarkdownRenderer{}
+}
+
+// Render renders a document produced by this package.
+func (r *MarkdownRenderer) Render(doc domain.ParsedDocument, options domain.ConversionOptions) (string, error) {
+	d, ok := doc.(*Document)
+	if !ok {
+		return "", errors.NewInternalError("Unsupported document type")
+	}
+	return newRenderer(options).document(d), nil
+}
+
+// renderer holds the state of a single rendering: the options and the
+// link references collected so far, with the target of every label and
+// the number of every target that has one.
+type renderer struct {
+	options     domain.ConversionOptions
+	definitions []string
+	references  map[string]string
+	numbers     map[string]string
+}
+
+func newRenderer(options domain.ConversionOptions) *renderer {
+	if options.CodeBlockStyle == "" {
+		options.CodeBlockStyle = "fenced"
+	}
+	if options.Fence == "" {
+		options.Fence = "```"
+	}
+	if options.EmDelimiter == "" {
+		options.EmDelimiter = "_"
+	}
+	if options.StrongDelimiter == "" {
+		options.StrongDelimiter = "**"
+	}
+	if options.LinkStyle == "" {
+		options.LinkStyle = "inlined"
+	}
+	if options.LinkReferenceStyle == "" {
+		options.LinkReferenceStyle = "full"
+	}
+	return &renderer{options: options, references: make(map[string]string), numbers: make(map[string]string)}
+}
+
+func (r *renderer) document(d *Document) string {
+	body := r.blocks(d.Blocks, false)
+
+	var notes []string
+	for _, note := range d.Footnotes {
+		notes = append(notes, r.footnote(note))
+	}
+
+	parts := []string{body}
+	if len(r.definitions) > 0 {
+		parts = append(parts, strings.Join(r.definitions, "\n"))
+	}
+	if len(notes) > 0 {
+		separator := "\n"
+		if r.options.Flavor == FlavorCommonMark || r.options.Flavor == FlavorPlain {
+			separator = "\n\n"
+		}
+		parts = append(parts, strings.Join(notes, separator))
+	}
+	// only newlines: a leading indented code block keeps its indentation
+	return r.flavorDocument(strings.Trim(strings.Join(parts, "\n\n"), "\n"))
+}
+
+// blocks renders blocks separated by a blank line, or by a newline only
+// inside the items of a tight list and between attached blocks.
+func (r *renderer) blocks(blocks []Block, tight bool) string {
+	var b strings.Builder
+	var previous Block
+	for _, block := range blocks {
+		text := r.block(block)
+		if text == "" {
+			continue
+		}
+		separator := "\n\n"
+		if tight || previous != nil && keepsAttached(previous, block) {
+			separator = "\n"
+		}
+		if previous != nil && r.wouldMerge(previous, block) {
+			// an empty comment keeps the lists apart
+			b.WriteString(separator + "<!-- -->")
+		}
+		if previous != nil {
+			b.WriteString(separator)
+		}
+		b.WriteString(text)
+		previous = block
+	}
+	return b.String()
+}
+
+// wouldMerge reports whether block is a list that Markdown would read as
+// the continuation of the list before it: both are bullet lists, or
+// ordered lists with the same delimiter, once rendered.
+func (r *renderer) wouldMerge(previous, block Block) bool {
+	first, ok := previous.(*List)
+	second, ok2 := block.(*List)
+	if !ok || !ok2 || first.Ordered != second.Ordered || r.options.Flavor == FlavorPlain {
+		return false
+	}
+	if !first.Ordered {
+		return r.bulletMarker(first) == r.bulletMarker(second)
+	}
+	return r.orderedDelimiter(first) == r.orderedDelimiter(second)
+}
+
+// keepsAttached reports whether block can follow previous without a blank
+// line: it has to be attached in the source, and previous has to end
+// where it is whatever the options. Headings and thematic breaks are one
+// line long (setext ones end with their underline); any other block could
+// swallow the next line as a continuation.
+func keepsAttached(previous, block Block) bool {
+	switch previous.(type) {
+	case *Heading, *ThematicBreak:
+		return block.attached()
+	}
+	return false
+}
+
+func (r *renderer) block(block Block) string {
+	if r.options.Flavor == FlavorPlain {
+		return r.plainBlock(block)
+	}
+	switch b := block.(type) {
+	case *Heading:
+		content := r.inlines(b.Content, true)
+		setext := r.options.HeadingStyle == "setext" || (r.options.HeadingStyle == "" && b.Setext)
+		if setext && b.Level < 3 && content != "" {
+			underline := "="
+			if b.Level == 2 {
+				underline = "-"
+			}
+			width := 0
+			for _, line := range strings.Split(content, "\n") {
+				if len(line) > width {
+					width = len(line)
+				}
+			}
+			return content + "\n" + strings.Repeat(underline, width)
+		}
+		return strings.TrimSpace(strings.Repeat("#", b.Level) + " " + escapeClosingSequence(strings.ReplaceAll(content, "\n", " ")))
+	case *Paragraph:
+		return r.inlines(b.Content, true)
+	case *List:
+		return r.list(b)
+	case *CodeBlock:
+		return FormatCodeBlock(b.Code, b.Language, r.options.CodeBlockStyle, r.options.Fence)
+	case *BlockQuote:
+		content := r.blocks(b.Blocks, false)
+		lines := strings.Split(content, "\n")
+		for i, line := range lines {
+			if line == "" {
+				lines[i] = ">"
+			} else {
+				lines[i] = "> " + line
+			}
+		}
+		return strings.Join(lines, "\n")
+	case *Table:
+		if r.options.Flavor == FlavorCommonMark {
+			return r.htmlTable(b)
+		}
+		return r.table(b)
+	case *ThematicBreak:
+		if b.Marker != "" {
+			return b.Marker
+		}
+		return "* * *"
+	case *HTMLBlock:
+		return b.HTML
+	}
+	return ""
+}
+
+// bulletMarker returns the marker of the items of a bullet list: the
+// option, or the marker of the source, or "-".
+func (r *renderer) bulletMarker(list *List) string {
+	switch {
+	case r.options.BulletListMarker != "":
+		return r.options.BulletListMarker
+	case list.Marker != 0:
+		return string(list.Marker)
+	}
+	return "-"
+}
+
+// orderedDelimiter returns the delimiter after the numbers of an ordered
+// list. MultiMarkdown only knows ".".
+func (r *renderer) orderedDelimiter(list *List) string {
+	if list.Marker == ')' && r.options.Flavor != FlavorMultiMarkdown {
+		return ")"
+	}
+	return "."
+}
+
+func (r *renderer) list(list *List) string {
+	var items []string
+	for i, item := range list.Items {
+		marker := r.bulletMarker(list)
+		if list.Ordered {
+			marker = strconv.Itoa(list.Start+i) + r.orderedDelimiter(list)
+		}
+
+		content := r.blocks(item.Blocks, list.Tight)
+		if content == "" {
+			items = append(items, marker)
+			continue
+		}
+		prefix := r.itemPrefix(marker)
+		items = append(items, prefix+indent(content, strings.Repeat(" ", len(prefix))))
+	}
+
+	var b strings.Builder
+	for i, item := range items {
+		if i > 0 {
+			if list.Tight || list.Items[i].Attached {
+				b.WriteString("\n")
+			} else {
+				b.WriteString("\n\n")
+			}
+		}
+		b.WriteString(item)
+	}
+	return b.String()
+}
+
+func (r *renderer) table(table *Table) string {
+	row := func(cells []Cell) string {
+		var b strings.Builder
+		b.WriteString("|")
+		for col := range table.Header {
+			content := ""
+			if col < len(cells) {
+				content = strings.ReplaceAll(r.cell(cells[col]), "\n", "<br>")
+			}
+			b.WriteString(" " + content + " |")
+		}
+		return b.String()
+	}
+
+	lines := []string{row(table.Header)}
+	delimiter := "|"
+	for col := range table.Header {
+		align := AlignNone
+		if col < len(table.Align) {
+			align = table.Align[col]
+		}
+		switch align {
+		case AlignLeft:
+			delimiter += " :-- |"
+		case AlignCenter:
+			delimiter += " :-: |"
+		case AlignRight:
+			delimiter += " --: |"
+		default:
+			delimiter += " --- |"
+		}
+	}
+	lines = append(lines, delimiter)
+	for _, cells := range table.Rows {
+		lines = append(lines, row(cells))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cell renders the content of a table cell, where pipes in the text have
+// to be escaped.
+func (r *renderer) cell(cell Cell) string {
+	inlines := make([]Inline, len(cell))
+	for i, inline := range cell {
+		if text, ok := inline.(*Text); ok && text.Raw == "" {
+			inline = &Text{Raw: r.flavorText(escapeText(text.Value, false, true), false)}
+		}
+		inlines[i] = inline
+	}
+	return r.inlines(inlines, false)
+}
+
+// inlines renders the content of a block. Emphasis is rendered last, once
+// the text on both sides is known, so that a delimiter that wouldn't be
+// recognized there can be replaced.
+func (r *renderer) inlines(inlines []Inline, block bool) string {
+	parts := make([]string, len(inlines))
+	for i, inline := range inlines {
+		lineStart := block && (i == 0 || isLineBreak(inlines[i-1]))
+		parts[i] = r.inline(inline, lineStart)
+	}
+
+	var b strings.Builder
+	for i, inline := range inlines {
+		emphasis, ok := inline.(*Emphasis)
+		if !ok {
+			b.WriteString(parts[i])
+			continue
+		}
+		content := parts[i]
+		trimmed := strings.TrimSpace(content)
+		if trimmed == "" {
+			b.WriteString(content)
+			continue
+		}
+		// spaces at the edges move outside the delimiters
+		leading, trailing := trimmed != strings.TrimRight(content, " \n"), trimmed != strings.TrimLeft(content, " \n")
+		if leading {
+			b.WriteString(" ")
+		}
+		before, _ := utf8.DecodeLastRuneInString(b.String())
+		after := ' '
+		if !trailing {
+			after = 0
+			if i+1 < len(inlines) {
+				if _, ok := inlines[i+1].(*Emphasis); ok {
+					after = '*'
+				} else {
+					after, _ = utf8.DecodeRuneInString(parts[i+1])
+				}
+			}
+		}
+		b.WriteString(r.emphasis(emphasis.Strong, trimmed, before, after))
+		if trailing {
+			b.WriteString(" ")
+		}
+	}
+	return b.String()
+}
+
+// emphasis wraps text in the configured delimiter or, where the CommonMark
+// flanking rules wouldn't let it open or close, in the other one, falling
+// back to inline HTML when neither works.
+func (r *renderer) emphasis(strong bool, content string, before, after rune) string {
+	delimiter, tag := r.options.EmDelimiter, "em"
+	if strong {
+		delimiter, tag = r.options.StrongDelimiter, "strong"
+	}
+	first, _ := utf8.DecodeRuneInString(content)
+	last, _ := utf8.DecodeLastRuneInString(content)
+
+	alternate := strings.Repeat("*", len(delimiter))
+	if delimiter[0] == '*' {
+		alternate = strings.Repeat("_", len(delimiter))
+	}
+	for _, d := range []string{delimiter, alternate} {
+		if CanOpen(d[0], before, first) && CanClose(d[0], last, after) {
+			return d + content + d
+		}
+	}
+	return "<" + tag + ">" + content + "</" + tag + ">"
+}
+
+func (r *renderer) inline(inline Inline, lineStart bool) string {
+	switch n := inline.(type) {
+	case *Text:
+		if n.Raw != "" {
+			return r.flavorText(n.Raw, true)
+		}
+		return r.flavorText(escapeText(n.Value, lineStart, false), false)
+	case *LineBreak:
+		if n.Hard {
+			return "\\\n"
+		}
+		return "\n"
+	case *Emphasis:
+		return r.inlines(n.Content, false)
+	case *Code:
+		return codeSpan(n.Value)
+	case *Link:
+		return r.link(n)
+	case *Image:
+		return "![" + r.inlines(n.Alt, false) + "](" + destination(n.Source) + title(n.Title) + ")"
+	case *RawHTML:
+		return n.Value
+	case *FootnoteReference:
+		return r.footnoteReference(n.Label)
+	}
+	return ""
+}
+
+// link renders a link inline or as a reference. Bare URLs stay plain text
+// unless references are asked for.
+func (r *renderer) link(link *Link) string {
+	if link.Autolink {
+		return "<" + link.Destination + ">"
+	}
+	var content string
+	if link.Bare {
+		if r.options.LinkStyle != "referenced" {
+			return r.bareLink(link.Destination)
+		}
+		content = link.Destination
+	} else {
+		content = r.inlines(link.Content, false)
+	}
+
+	if r.options.LinkStyle != "referenced" {
+		return "[" + content + "](" + destination(link.Destination) + title(link.Title) + ")"
+	}
+
+	target := destination(link.Destination) + title(link.Title)
+	switch r.options.LinkReferenceStyle {
+	case "collapsed", "shortcut":
+		// labels match regardless of case and spacing
+		label := strings.Join(strings.Fields(strings.ToLower(content)), " ")
+		defined, ok := r.references[label]
+		if !ok {
+			r.references[label] = target
+			r.definitions = append(r.definitions, "["+content+"]: "+target)
+		}
+		if ok && defined != target {
+			// the text already labels another target
+			return "[" + content + "][" + r.number(target) + "]"
+		}
+		if r.options.LinkReferenceStyle == "collapsed" {
+			return "[" + content + "][]"
+		}
+		return "[" + content + "]"
+	default:
+		return "[" + content + "][" + r.number(target) + "]"
+	}
+}
+
+// number returns the numbered label of target, defining it on first use.
+// Numbers taken as labels by the text of other links are skipped.
+func (r *renderer) number(target string) string {
+	if id, ok := r.numbers[target]; ok {
+		return id
+	}
+	n := len(r.definitions) + 1
+	for {
+		if _, taken := r.references[strconv.Itoa(n)]; !taken {
+			break
+		}
+		n++
+	}
+	id := strconv.Itoa(n)
+	r.numbers[target] = id
+	r.references[id] = target
+	r.definitions = append(r.definitions, "["+id+"]: "+target)
+	return id
+}
+
+// FormatCodeBlock formats code as a fenced or indented Markdown code block.
+// The fence is made longer than any run of its character in the code.
+func FormatCodeBlock(code, language, style, fence string) string {
+	if style == "indented" {
+		return "    " + strings.ReplaceAll(code, "\n", "\n    ")
+	}
+
+	fenceChar, _ := utf8.DecodeRuneInString(fence)
+	fence = md.CalculateCodeFence(fenceChar, code)
+
+	return fence + language + "\n" + code + "\n" + fence
+}
+
+// codeSpan wraps code in more backticks than it contains in a row.
+func codeSpan(code string) string {
+	longest, run := 0, 0
+	for _, c := range code {
+		if c == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	ticks := strings.Repeat("`", longest+1)
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") ||
+		(strings.HasPrefix(code, " ") && strings.HasSuffix(code, " ") && strings.TrimSpace(code) != "") {
+		code = " " + code + " "
+	}
+	return ticks + code + ticks
+}
+
+// destination writes a link destination, in angle brackets when it
+// couldn't be written bare.
+func destination(url string) string {
+	depth, balanced := 0, true
+	for _, c := range url {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		balanced = balanced && depth >= 0
+	}
+	if url == "" || !balanced || depth != 0 || strings.ContainsAny(url, " \t\n<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E", "\n", "%0A").Replace(url) + ">"
+	}
+	return url
+}
+
+func title(title string) string {
+	if title == "" {
+		return ""
+	}
+	return ` "` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(title) + `"`
+}
+
+func isLineBreak(inline Inline) bool {
+	_, ok := inline.(*LineBreak)
+	return ok
+}
+
+// indent indents every line but the first; blank lines stay empty.
+func indent(text, prefix string) string {
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = prefix + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
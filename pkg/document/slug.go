@@ -0,0 +1,45 @@
+package document
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Slugger generates GitHub-compatible heading anchors, numbering repeated
+// slugs the way GitHub does ("intro", "intro-1", "intro-2").
+type Slugger struct {
+	seen map[string]int
+}
+
+func NewSlugger() *Slugger {
+	return &Slugger{seen: make(map[string]int)}
+}
+
+func (s *Slugger) Slug(text string) string {
+	base := githubSlug(text)
+	slug := base
+	// the counter of the base goes on until base-N is free, which it may
+	// not be when a heading reads like a numbered one
+	for _, taken := s.seen[slug]; taken; _, taken = s.seen[slug] {
+		s.seen[base]++
+		slug = base + "-" + strconv.Itoa(s.seen[base])
+	}
+	s.seen[slug] = 0
+	return slug
+}
+
+// githubSlug lowercases the text, drops punctuation and symbols and turns
+// spaces into hyphens.
+func githubSlug(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case r == ' ':
+			b.WriteRune('-')
+		case r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.M, r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
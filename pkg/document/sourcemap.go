@@ -0,0 +1,131 @@
+package document
+
+import (
+	"strings"
+	"unicode"
+
+	"any2md/internal/domain"
+)
+
+// SourceMap locates the blocks of the document in markdown, the final
+// output rendered from it, and pairs them with where they come from. The
+// output is parsed again and its blocks are matched in order by type and
+// text, so that the passes run after rendering (normalization, wrapping,
+// front matter, table of contents) don't matter; output blocks that match
+// no block of the document are left out.
+func (d *Document) SourceMap(markdown string) []domain.SourceMapEntry {
+	output := ParseMarkdown(markdown).Blocks
+	lines := strings.Split(markdown, "\n")
+	lineStarts := make([]int, len(lines))
+	for i := 1; i < len(lines); i++ {
+		lineStarts[i] = lineStarts[i-1] + len(lines[i-1]) + 1
+	}
+	extents := blockExtents(output, lines)
+	keys := make([]string, len(output))
+	for i, block := range output {
+		keys[i] = blockKey(block)
+	}
+
+	var entries []domain.SourceMapEntry
+	next := 0
+	for _, block := range d.Blocks {
+		kind := blockType(block)
+		if kind == "" {
+			continue
+		}
+		key := blockKey(block)
+		for i := next; i < len(output); i++ {
+			if extents[i][0] == 0 || blockType(output[i]) != kind || keys[i] != key {
+				continue
+			}
+			first, last := extents[i][0], extents[i][1]
+			entry := domain.SourceMapEntry{
+				Type:      kind,
+				Offset:    lineStarts[first-1],
+				EndOffset: lineStarts[last-1] + len(lines[last-1]),
+				Line:      first,
+				EndLine:   last,
+			}
+			pos := block.Position()
+			if pos.Path != "" {
+				entry.HTML = &domain.HTMLSource{Path: pos.Path, Offset: pos.Offset, EndOffset: pos.EndOffset}
+			} else if pos.Page > 0 {
+				entry.PDF = &domain.PDFSource{Page: pos.Page, BBox: pos.BBox}
+			}
+			entries = append(entries, entry)
+			next = i + 1
+			break
+		}
+	}
+	return entries
+}
+
+// blockExtents returns the first and last lines of every block. The
+// position of a block only covers its content, so it is extended to the
+// non-blank lines around it, such as code fences and setext underlines,
+// without reaching into the blocks next to it.
+func blockExtents(blocks []Block, lines []string) [][2]int {
+	extents := make([][2]int, len(blocks))
+	previous := 0
+	for i, block := range blocks {
+		pos := block.Position()
+		if pos.Line == 0 {
+			continue
+		}
+		limit := len(lines)
+		for _, after := range blocks[i+1:] {
+			if line := after.Position().Line; line > 0 {
+				limit = line - 1
+				break
+			}
+		}
+
+		first, last := pos.Line, pos.EndLine
+		for first-1 > previous && strings.TrimSpace(lines[first-2]) != "" {
+			first--
+		}
+		for last < limit && strings.TrimSpace(lines[last]) != "" {
+			last++
+		}
+		extents[i] = [2]int{first, last}
+		previous = last
+	}
+	return extents
+}
+
+// blockType names the kind of a block in the JSON output; thematic breaks
+// have none.
+func blockType(block Block) string {
+	switch block.(type) {
+	case *Heading:
+		return "heading"
+	case *Paragraph:
+		return "paragraph"
+	case *List:
+		return "list"
+	case *CodeBlock:
+		return "code"
+	case *Table:
+		return "table"
+	case *BlockQuote:
+		return "quote"
+	case *HTMLBlock:
+		return "html"
+	}
+	return ""
+}
+
+// blockKey is the text of a block without whitespace, which wrapping and
+// normalization change.
+func blockKey(block Block) string {
+	text := plainBlocks([]Block{block})
+	if b, ok := block.(*HTMLBlock); ok {
+		text = b.HTML
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
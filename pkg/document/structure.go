@@ -0,0 +1,234 @@
+package document
+
+import (
+	"strconv"
+	"strings"
+
+	"any2md/internal/domain"
+)
+
+// Structure returns the document as a tree of sections, for clients that
+// want its structure rather than the Markdown text. The Markdown of each
+// block is rendered with the given options, with inlined links so that
+// every block stands on its own.
+func (d *Document) Structure(options domain.ConversionOptions) *domain.DocumentStructure {
+	options.LinkStyle = "inlined"
+	b := &structureBuilder{renderer: newRenderer(options), slugs: NewSlugger()}
+	if d.Metadata != nil {
+		b.title = d.Metadata.Title
+	}
+
+	for _, block := range d.Blocks {
+		if heading, ok := block.(*Heading); ok {
+			b.open(heading)
+			continue
+		}
+		b.add(block)
+	}
+	b.closeTo(0)
+
+	return &domain.DocumentStructure{Title: b.title, Sections: b.sections}
+}
+
+// structureBuilder groups the blocks of a document into sections. stack
+// holds the sections that can still receive content, outermost first.
+type structureBuilder struct {
+	renderer *renderer
+	slugs    *Slugger
+	title    string
+	sections []domain.Section
+	stack    []*domain.Section
+}
+
+// open starts the section of a heading, closing the sections of the same
+// or a deeper level.
+func (b *structureBuilder) open(heading *Heading) {
+	text := plainText(heading.Content)
+	if b.title == "" && heading.Level == 1 {
+		b.title = text
+	}
+	b.closeTo(heading.Level)
+
+	section := &domain.Section{
+		ID:      b.slugs.Slug(text),
+		Heading: text,
+		Level:   heading.Level,
+		Page:    heading.Position().Page,
+	}
+	if section.ID == "" {
+		section.ID = b.slugs.Slug("section")
+	}
+	b.collect(section, heading.Content)
+	b.stack = append(b.stack, section)
+}
+
+// closeTo closes the open sections of level or deeper, and the level 0
+// section, attaching each one to its parent.
+func (b *structureBuilder) closeTo(level int) {
+	for len(b.stack) > 0 {
+		last := b.stack[len(b.stack)-1]
+		if last.Level > 0 && last.Level < level {
+			return
+		}
+		b.stack = b.stack[:len(b.stack)-1]
+		if len(b.stack) == 0 {
+			b.sections = append(b.sections, *last)
+		} else {
+			parent := b.stack[len(b.stack)-1]
+			parent.Sections = append(parent.Sections, *last)
+		}
+	}
+}
+
+// add appends a block to the innermost open section, opening the level 0
+// section for content that comes before any heading.
+func (b *structureBuilder) add(block Block) {
+	if len(b.stack) == 0 {
+		b.stack = append(b.stack, &domain.Section{ID: b.slugs.Slug("preamble")})
+	}
+	section := b.stack[len(b.stack)-1]
+
+	content := domain.ContentBlock{
+		ID:       section.ID + "/" + strconv.Itoa(len(section.Blocks)+1),
+		Type:     blockType(block),
+		Markdown: b.renderer.block(block),
+		Page:     block.Position().Page,
+	}
+	switch bl := block.(type) {
+	case *Paragraph:
+		content.Text = plainText(bl.Content)
+	case *List:
+		content.Ordered = bl.Ordered
+		for _, item := range bl.Items {
+			content.Items = append(content.Items, plainBlocks(item.Blocks))
+		}
+		content.Text = strings.Join(content.Items, "\n")
+	case *CodeBlock:
+		content.Language = bl.Language
+		content.Text = bl.Code
+	case *Table:
+		content.Header = plainCells(bl.Header)
+		for _, row := range bl.Rows {
+			content.Rows = append(content.Rows, plainCells(row))
+		}
+		content.Text = plainBlocks([]Block{bl})
+	case *BlockQuote:
+		content.Text = plainBlocks(bl.Blocks)
+	case *HTMLBlock:
+	default:
+		// thematic breaks only separate sections
+		return
+	}
+	if content.Markdown == "" {
+		return
+	}
+
+	section.Blocks = append(section.Blocks, content)
+	walkInlines([]Block{block}, func(inlines []Inline) { b.collect(section, inlines) })
+}
+
+// collect adds the links and images of inlines to the section.
+func (b *structureBuilder) collect(section *domain.Section, inlines []Inline) {
+	for _, inline := range inlines {
+		switch n := inline.(type) {
+		case *Emphasis:
+			b.collect(section, n.Content)
+		case *Link:
+			section.Links = append(section.Links, domain.Link{URL: n.Destination, Text: plainText(n.Content), Title: n.Title})
+			b.collect(section, n.Content)
+		case *Image:
+			section.Images = append(section.Images, domain.Image{Source: n.Source, Alt: plainText(n.Alt), Title: n.Title})
+		}
+	}
+}
+
+// walkInlines calls fn with the text of every block, nested ones and
+// table cells included.
+func walkInlines(blocks []Block, fn func([]Inline)) {
+	Walk(&Document{Blocks: blocks}, func(block Block) {
+		switch b := block.(type) {
+		case *Heading:
+			fn(b.Content)
+		case *Paragraph:
+			fn(b.Content)
+		case *Table:
+			for _, cell := range b.Header {
+				fn(cell)
+			}
+			for _, row := range b.Rows {
+				for _, cell := range row {
+					fn(cell)
+				}
+			}
+		}
+	})
+}
+
+// plainText returns the text of inlines without any markup. Links are
+// replaced by their text, or their URL when they have none.
+func plainText(inlines []Inline) string {
+	var b strings.Builder
+	for _, inline := range inlines {
+		switch n := inline.(type) {
+		case *Text:
+			b.WriteString(n.Value)
+		case *LineBreak:
+			b.WriteString("\n")
+		case *Emphasis:
+			b.WriteString(plainText(n.Content))
+		case *Code:
+			b.WriteString(n.Value)
+		case *Link:
+			if text := plainText(n.Content); text != "" {
+				b.WriteString(text)
+			} else {
+				b.WriteString(n.Destination)
+			}
+		case *Image:
+			b.WriteString(plainText(n.Alt))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// PlainText returns the text of a block without any markup: one line per
+// paragraph, list item or table row, cells separated by tabs.
+func PlainText(block Block) string {
+	return plainBlocks([]Block{block})
+}
+
+// plainBlocks returns the text of blocks, one line per paragraph, list
+// item or table row.
+func plainBlocks(blocks []Block) string {
+	var lines []string
+	for _, block := range blocks {
+		switch b := block.(type) {
+		case *Heading:
+			lines = append(lines, plainText(b.Content))
+		case *Paragraph:
+			lines = append(lines, plainText(b.Content))
+		case *List:
+			for _, item := range b.Items {
+				lines = append(lines, plainBlocks(item.Blocks))
+			}
+		case *CodeBlock:
+			lines = append(lines, b.Code)
+		case *BlockQuote:
+			lines = append(lines, plainBlocks(b.Blocks))
+		case *Table:
+			lines = append(lines, strings.Join(plainCells(b.Header), "\t"))
+			for _, row := range b.Rows {
+				lines = append(lines, strings.Join(plainCells(row), "\t"))
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func plainCells(cells []Cell) []string {
+	texts := make([]string, len(cells))
+	for i, cell := range cells {
+		texts[i] = plainText(cell)
+	}
+	return texts
+}
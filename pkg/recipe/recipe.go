@@ -0,0 +1,313 @@
+// Package recipe implements site-specific conversion recipes: YAML files
+// that select the content to keep or drop for pages from a given site,
+// rewrite its markup and preset conversion options.
+package recipe
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html/atom"
+	"gopkg.in/yaml.v3"
+	"any2md/internal/domain"
+	"any2md/pkg/errors"
+)
+
+// Recipe is a single site recipe.
+//
+//	name: confluence
+//	match:
+//	  hosts: ["*.atlassian.net"]
+//	  urls: ["/wiki/spaces/"]
+//	  selectors: ["meta[name=confluence-space-key]"]
+//	keep: ["#main-content"]
+//	drop: [".page-metadata"]
+//	rewrite:
+//	  - selector: ".code.panel .codeContent"
+//	    tag: pre
+//	options:
+//	  table_strategy: expand
+type Recipe struct {
+	Name    string    `yaml:"name"`
+	Match   Match     `yaml:"match"`
+	Keep    []string  `yaml:"keep"`
+	Drop    []string  `yaml:"drop"`
+	Rewrite []Rewrite `yaml:"rewrite"`
+	// Options are presets; options sent with the request take precedence
+	Options domain.ConversionOptions `yaml:"-"`
+
+	urls []*regexp.Regexp
+}
+
+// Match decides when a recipe is selected automatically: when the page URL
+// host matches one of the host globs, the URL matches one of the regular
+// expressions, or the document contains one of the fingerprint selectors.
+type Match struct {
+	Hosts     []string `yaml:"hosts"`
+	URLs      []string `yaml:"urls"`
+	Selectors []string `yaml:"selectors"`
+}
+
+// Rewrite changes the elements matched by Selector: Tag renames them,
+// Unwrap replaces them with their children and Text with their text.
+type Rewrite struct {
+	Selector string `yaml:"selector"`
+	Tag      string `yaml:"tag"`
+	Unwrap   bool   `yaml:"unwrap"`
+	Text     bool   `yaml:"text"`
+}
+
+// Parse reads a recipe from YAML. Options use the same names as in
+// conversion requests and are validated the same way.
+func Parse(data []byte) (*Recipe, error) {
+	var raw struct {
+		Recipe  `yaml:",inline"`
+		Options map[string]interface{} `yaml:"options"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	r := raw.Recipe
+
+	if strings.TrimSpace(r.Name) == "" {
+		return nil, fmt.Errorf("recipe has no name")
+	}
+	if raw.Options != nil {
+		// go through JSON to reuse the option names of the API
+		encoded, err := json.Marshal(raw.Options)
+		if err != nil {
+			return nil, fmt.Errorf("recipe %s: options: %w", r.Name, err)
+		}
+		// as presets, without the record of the options set
+		type presets domain.ConversionOptions
+		decoder := json.NewDecoder(strings.NewReader(string(encoded)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode((*presets)(&r.Options)); err != nil {
+			return nil, fmt.Errorf("recipe %s: options: %w", r.Name, err)
+		}
+		if err := r.Options.Validate(); err != nil {
+			return nil, fmt.Errorf("recipe %s: %w", r.Name, err)
+		}
+	}
+	for _, pattern := range r.Match.URLs {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("recipe %s: url pattern %q: %w", r.Name, pattern, err)
+		}
+		r.urls = append(r.urls, re)
+	}
+	selectors := append(append(append([]string(nil), r.Keep...), r.Drop...), r.Match.Selectors...)
+	for _, rewrite := range r.Rewrite {
+		if rewrite.Selector == "" || (rewrite.Tag == "" && !rewrite.Unwrap && !rewrite.Text) {
+			return nil, fmt.Errorf("recipe %s: rewrite needs a selector and one of tag, unwrap or text", r.Name)
+		}
+		selectors = append(selectors, rewrite.Selector)
+	}
+	// goquery matches nothing with an invalid selector, which would
+	// silently disable the recipe
+	for _, selector := range selectors {
+		if _, err := cascadia.Compile(selector); err != nil {
+			return nil, fmt.Errorf("recipe %s: selector %q: %w", r.Name, selector, err)
+		}
+	}
+
+	return &r, nil
+}
+
+// Matches reports whether the recipe applies to a page.
+func (r *Recipe) Matches(pageURL string, doc *goquery.Document) bool {
+	if host := hostOf(pageURL); host != "" {
+		for _, pattern := range r.Match.Hosts {
+			if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+				return true
+			}
+		}
+	}
+	if pageURL != "" {
+		for _, re := range r.urls {
+			if re.MatchString(pageURL) {
+				return true
+			}
+		}
+	}
+	for _, selector := range r.Match.Selectors {
+		if doc.Find(selector).Length() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func hostOf(pageURL string) string {
+	rest := pageURL
+	if i := strings.Index(rest, "://"); i >= 0 {
+		rest = rest[i+3:]
+	}
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		rest = rest[i+1:]
+	}
+	if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.Contains(rest[i:], "]") {
+		rest = rest[:i]
+	}
+	return strings.ToLower(rest)
+}
+
+// Apply cleans up the document: everything outside the keep selectors is
+// removed, then the drop selectors, then the rewrites are applied in order.
+func (r *Recipe) Apply(doc *goquery.Document) {
+	if len(r.Keep) > 0 {
+		kept := doc.Find(strings.Join(r.Keep, ", "))
+		if kept.Length() > 0 {
+			// keep the outermost matches only, in document order
+			kept = kept.FilterFunction(func(i int, s *goquery.Selection) bool {
+				return s.ParentsFiltered(strings.Join(r.Keep, ", ")).Length() == 0
+			})
+			body := doc.Find("body")
+			kept.Remove()
+			body.Empty()
+			body.AppendSelection(kept)
+		}
+	}
+
+	for _, selector := range r.Drop {
+		doc.Find(selector).Remove()
+	}
+
+	for _, rewrite := range r.Rewrite {
+		doc.Find(rewrite.Selector).Each(func(i int, s *goquery.Selection) {
+			switch {
+			case rewrite.Text:
+				s.ReplaceWithHtml(escapeText(s.Text()))
+			case rewrite.Unwrap:
+				s.Contents().Unwrap()
+				if s.Parent().Length() > 0 {
+					s.Remove()
+				}
+			default:
+				s.Get(0).Data = rewrite.Tag
+				s.Get(0).DataAtom = atom.Lookup([]byte(rewrite.Tag))
+			}
+		})
+	}
+}
+
+func escapeText(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// WithDefaults fills the options that the request left unset from the
+// recipe's presets. Options decoded from JSON are set when the JSON has
+// them, even with a false or zero value; other options when they aren't
+// zero.
+func (r *Recipe) WithDefaults(options domain.ConversionOptions) domain.ConversionOptions {
+	merged := reflect.ValueOf(&options).Elem()
+	presets := reflect.ValueOf(r.Options)
+	for i := 0; i < merged.NumField(); i++ {
+		field := merged.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !options.IsSet(name) && merged.Field(i).IsZero() {
+			merged.Field(i).Set(presets.Field(i))
+		}
+	}
+	return options
+}
+
+// Book is the set of recipes known to the server.
+type Book struct {
+	recipes []*Recipe
+}
+
+// NewBook returns a book with the given recipes, tried in order.
+func NewBook(recipes ...*Recipe) *Book {
+	return &Book{recipes: recipes}
+}
+
+// LoadDir reads every .yaml and .yml file in dir, in name order.
+func LoadDir(dir string) (*Book, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if ext := filepath.Ext(entry.Name()); !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	book := &Book{}
+	seen := make(map[string]string)
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		r, err := Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if other, ok := seen[r.Name]; ok {
+			return nil, fmt.Errorf("%s: recipe %s is already defined in %s", name, r.Name, other)
+		}
+		seen[r.Name] = name
+		book.recipes = append(book.recipes, r)
+	}
+	return book, nil
+}
+
+// Names lists the recipes in the book.
+func (b *Book) Names() []string {
+	if b == nil {
+		return nil
+	}
+	names := make([]string, len(b.recipes))
+	for i, r := range b.recipes {
+		names[i] = r.Name
+	}
+	return names
+}
+
+// Select returns the recipe with the given name or, without a name, the
+// first recipe that matches the page. It returns nil when none applies and
+// a validation error for an unknown name.
+func (b *Book) Select(name string, pageURL string, doc *goquery.Document) (*Recipe, error) {
+	if name != "" {
+		if b != nil {
+			for _, r := range b.recipes {
+				if r.Name == name {
+					return r, nil
+				}
+			}
+		}
+		err := errors.NewValidationError(fmt.Sprintf("unknown recipe %q", name))
+		err.Details["available"] = b.Names()
+		return nil, err
+	}
+
+	if b == nil {
+		return nil, nil
+	}
+	for _, r := range b.recipes {
+		if r.Matches(pageURL, doc) {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
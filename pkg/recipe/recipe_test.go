@@ -0,0 +1,189 @@
+package recipe
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"any2md/internal/domain"
+)
+
+const wikiRecipe = `
+name: wiki
+match:
+  hosts: ["*.example.org"]
+  urls: ["/wiki/"]
+  selectors: ["meta[name=generator][content^=WikiEngine]"]
+keep: ["#title", "#content"]
+drop: [".edit"]
+rewrite:
+  - selector: "#title"
+    tag: h1
+  - selector: ".headline"
+    unwrap: true
+  - selector: ".badge"
+    text: true
+options:
+  table_strategy: expand
+  toc: true
+`
+
+func parseDocument(t *testing.T, html string) *goquery.Document {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatalf("failed to parse HTML: %v", err)
+	}
+	return doc
+}
+
+func TestParse(t *testing.T) {
+	r, err := Parse([]byte(wikiRecipe))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if r.Name != "wiki" || len(r.Keep) != 2 || len(r.Rewrite) != 3 {
+		t.Errorf("Unexpected recipe %+v", r)
+	}
+	if r.Options.TableStrategy != "expand" || !r.Options.TOC {
+		t.Errorf("Expected option presets, got %+v", r.Options)
+	}
+
+	invalid := []struct {
+		name string
+		yaml string
+	}{
+		{"Missing name", "keep: [main]"},
+		{"Unknown option", "name: x\noptions:\n  colour: red"},
+		{"Invalid option value", "name: x\noptions:\n  table_strategy: pretty"},
+		{"Unknown tokenizer", "name: x\noptions:\n  tokenizer: gpt-9"},
+		{"Invalid URL pattern", "name: x\nmatch:\n  urls: ['(']"},
+		{"Rewrite without action", "name: x\nrewrite:\n  - selector: p"},
+		{"Invalid keep selector", "name: x\nkeep: ['#main >']"},
+		{"Invalid drop selector", "name: x\ndrop: ['.ad[']"},
+		{"Invalid rewrite selector", "name: x\nrewrite:\n  - selector: 'p:nope'\n    unwrap: true"},
+		{"Invalid fingerprint selector", "name: x\nmatch:\n  selectors: ['meta[name=']"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.yaml)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestBook_Select(t *testing.T) {
+	wiki, err := Parse([]byte(wikiRecipe))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	book := NewBook(wiki)
+	plain := parseDocument(t, "<p>Hello</p>")
+
+	tests := []struct {
+		name    string
+		recipe  string
+		url     string
+		html    string
+		want    string
+		wantErr bool
+	}{
+		{name: "By name", recipe: "wiki", want: "wiki"},
+		{name: "Unknown name", recipe: "blog", wantErr: true},
+		{name: "By host", url: "https://docs.example.org:8443/guide", want: "wiki"},
+		{name: "By URL pattern", url: "https://intranet.local/wiki/Main_Page", want: "wiki"},
+		{name: "By fingerprint", html: `<meta name="generator" content="WikiEngine 2.1"><p>Hi</p>`, want: "wiki"},
+		{name: "No match", url: "https://example.com/blog"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := plain
+			if tt.html != "" {
+				doc = parseDocument(t, tt.html)
+			}
+			r, err := book.Select(tt.recipe, tt.url, doc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Select() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := ""
+			if r != nil {
+				got = r.Name
+			}
+			if got != tt.want {
+				t.Errorf("Expected recipe %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	var empty *Book
+	if r, err := empty.Select("", "https://docs.example.org/", plain); r != nil || err != nil {
+		t.Errorf("Expected no recipe without a book, got %v, %v", r, err)
+	}
+}
+
+func TestRecipe_Apply(t *testing.T) {
+	r, err := Parse([]byte(wikiRecipe))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	doc := parseDocument(t, `<div id="sidebar">Navigation</div>
+		<span id="title">Main Page</span>
+		<div id="content">
+			<h2><span class="headline">Intro</span> <a class="edit">[edit]</a></h2>
+			<p>Status: <b class="badge">draft</b></p>
+		</div>
+		<footer>Powered by WikiEngine</footer>`)
+	r.Apply(doc)
+
+	body, _ := doc.Find("body").Html()
+	for _, want := range []string{`<h1 id="title">Main Page</h1>`, "<h2>Intro </h2>", "Status: draft"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected body to contain %q, got:\n%s", want, body)
+		}
+	}
+	for _, exclude := range []string{"Navigation", "[edit]", "Powered by", "headline", "badge"} {
+		if strings.Contains(body, exclude) {
+			t.Errorf("Expected body not to contain %q, got:\n%s", exclude, body)
+		}
+	}
+}
+
+func TestRecipe_WithDefaults(t *testing.T) {
+	r, err := Parse([]byte(wikiRecipe))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	options := r.WithDefaults(domain.ConversionOptions{TableStrategy: "html", HeadingStyle: "setext"})
+	if options.TableStrategy != "html" || options.HeadingStyle != "setext" || !options.TOC {
+		t.Errorf("Expected request options to win over presets, got %+v", options)
+	}
+
+	// false and empty values sent with a request win too
+	var request domain.ConversionOptions
+	if err := json.Unmarshal([]byte(`{"TOC": false, "table_strategy": ""}`), &request); err != nil {
+		t.Fatal(err)
+	}
+	options = r.WithDefaults(request)
+	if options.TOC || options.TableStrategy != "" {
+		t.Errorf("Expected the options of the request to win over presets, got %+v", options)
+	}
+	if options = r.WithDefaults(domain.ConversionOptions{}); !options.TOC || options.TableStrategy != "expand" {
+		t.Errorf("Expected presets for unset options, got %+v", options)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	book, err := LoadDir("../../examples/recipes")
+	if err != nil {
+		t.Fatalf("LoadDir() error = %v", err)
+	}
+	want := []string{"confluence", "gitbook", "jira", "mediawiki"}
+	if got := book.Names(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected recipes %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,193 @@
+package tokenizer
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// BPE is a byte-level byte pair encoding tokenizer with the vocabulary of
+// a tiktoken file. Text is split with Split and every piece is encoded by
+// merging its bytes, lowest rank first, as tiktoken does.
+type BPE struct {
+	name  string
+	ranks map[string]int
+}
+
+// ParseBPE reads a vocabulary in the tiktoken format: one token per line,
+// base64 encoded, followed by its rank. Every single byte has to be in the
+// vocabulary so that any text can be encoded.
+func ParseBPE(name string, r io.Reader) (*BPE, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a token and its rank", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for b := 0; b < 256; b++ {
+		if _, ok := ranks[string([]byte{byte(b)})]; !ok {
+			return nil, fmt.Errorf("byte %#02x is missing from the vocabulary", b)
+		}
+	}
+	return &BPE{name: name, ranks: ranks}, nil
+}
+
+func (t *BPE) Name() string {
+	return t.name
+}
+
+// Encode returns the ranks of the tokens of text.
+func (t *BPE) Encode(text string) []int {
+	var tokens []int
+	for _, piece := range Split(text) {
+		if rank, ok := t.ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		for _, part := range t.merge(piece) {
+			tokens = append(tokens, t.ranks[part])
+		}
+	}
+	return tokens
+}
+
+func (t *BPE) Count(text string) int {
+	count := 0
+	for _, piece := range Split(text) {
+		if _, ok := t.ranks[piece]; ok {
+			count++
+			continue
+		}
+		count += len(t.merge(piece))
+	}
+	return count
+}
+
+// maxPiece bounds the bytes merged at once. A longer piece, such as a
+// word of thousands of letters, is merged in parts of this size: its
+// count may then differ slightly from tiktoken's, but its cost stays
+// linear in its length.
+const maxPiece = 512
+
+// merge splits piece into bytes and merges the adjacent pair with the
+// lowest rank, leftmost first, until no pair is in the vocabulary.
+func (t *BPE) merge(piece string) []string {
+	var parts []string
+	for len(piece) > maxPiece {
+		n := maxPiece
+		for n > 0 && !utf8.RuneStart(piece[n]) {
+			n--
+		}
+		if n == 0 {
+			n = maxPiece
+		}
+		parts = append(parts, t.mergeBytes(piece[:n])...)
+		piece = piece[n:]
+	}
+	return append(parts, t.mergeBytes(piece)...)
+}
+
+// mergeBytes merges the bytes of piece. The parts are a linked list of
+// offsets in piece and the candidate pairs a heap ordered by rank then
+// offset, so that neither a scan of every pair nor a concatenation is
+// needed per merge. Candidates whose parts changed since they were
+// pushed are skipped when they come out of the heap.
+func (t *BPE) mergeBytes(piece string) []string {
+	n := len(piece)
+	start := make([]int, n+1) // start[n] is the end of the last part
+	next := make([]int, n)
+	prev := make([]int, n)
+	version := make([]int, n)
+	for i := 0; i < n; i++ {
+		start[i], next[i], prev[i] = i, i+1, i-1
+	}
+	start[n] = n
+	end := func(i int) int { return start[next[i]] }
+
+	var candidates pairHeap
+	push := func(i int) {
+		if i < 0 || next[i] >= n {
+			return
+		}
+		if rank, ok := t.ranks[piece[start[i]:end(next[i])]]; ok {
+			heap.Push(&candidates, pair{rank: rank, at: start[i], left: i, leftVersion: version[i], rightVersion: version[next[i]]})
+		}
+	}
+	for i := 0; i+1 < n; i++ {
+		push(i)
+	}
+
+	for candidates.Len() > 0 {
+		p := heap.Pop(&candidates).(pair)
+		right := next[p.left]
+		if version[p.left] != p.leftVersion || right >= n || version[right] != p.rightVersion {
+			continue
+		}
+		// the right part joins the left one
+		next[p.left] = next[right]
+		if next[right] < n {
+			prev[next[right]] = p.left
+		}
+		version[p.left]++
+		version[right] = -1
+		push(prev[p.left])
+		push(p.left)
+	}
+
+	var parts []string
+	for i := 0; i < n; i = next[i] {
+		parts = append(parts, piece[start[i]:end(i)])
+	}
+	return parts
+}
+
+// pair is a candidate merge of the part at left with the one after it.
+type pair struct {
+	rank, at                        int
+	left, leftVersion, rightVersion int
+}
+
+type pairHeap []pair
+
+func (h pairHeap) Len() int { return len(h) }
+
+func (h pairHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank < h[j].rank
+	}
+	return h[i].at < h[j].at
+}
+
+func (h pairHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *pairHeap) Push(x interface{}) { *h = append(*h, x.(pair)) }
+
+func (h *pairHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
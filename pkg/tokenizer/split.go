@@ -0,0 +1,146 @@
+package tokenizer
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Split cuts text into the pieces that are encoded separately, following
+// the cl100k_base pattern:
+//
+//	'(?i:[sdmt]|ll|ve|re)|[^\r\n\p{L}\p{N}]?+\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]++[\r\n]*|\s*[\r\n]|\s+(?!\S)|\s+
+//
+// Go's regexp package has neither possessive quantifiers nor lookahead, so
+// the alternatives are matched by hand, in order, at every position.
+func Split(text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		n := contraction(text)
+		if n == 0 {
+			n = letters(text)
+		}
+		if n == 0 {
+			n = numbers(text)
+		}
+		if n == 0 {
+			n = punctuation(text)
+		}
+		if n == 0 {
+			n = spaces(text)
+		}
+		pieces = append(pieces, text[:n])
+		text = text[n:]
+	}
+	return pieces
+}
+
+// contraction matches '(?i:[sdmt]|ll|ve|re).
+func contraction(text string) int {
+	if text[0] != '\'' || len(text) < 2 {
+		return 0
+	}
+	switch lower(text[1]) {
+	case 's', 'd', 'm', 't':
+		return 2
+	}
+	if len(text) >= 3 {
+		switch string([]byte{lower(text[1]), lower(text[2])}) {
+		case "ll", "ve", "re":
+			return 3
+		}
+	}
+	return 0
+}
+
+// letters matches [^\r\n\p{L}\p{N}]?+\p{L}+.
+func letters(text string) int {
+	i := 0
+	if r, size := utf8.DecodeRuneInString(text); r != '\r' && r != '\n' && !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+		i = size
+	}
+	start := i
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !unicode.IsLetter(r) {
+			break
+		}
+		i += size
+	}
+	if i == start {
+		return 0
+	}
+	return i
+}
+
+// numbers matches \p{N}{1,3}.
+func numbers(text string) int {
+	i := 0
+	for n := 0; n < 3 && i < len(text); n++ {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !unicode.IsNumber(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// punctuation matches  ?[^\s\p{L}\p{N}]++[\r\n]*.
+func punctuation(text string) int {
+	i := 0
+	if text[0] == ' ' {
+		i = 1
+	}
+	start := i
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if unicode.IsSpace(r) || unicode.IsLetter(r) || unicode.IsNumber(r) {
+			break
+		}
+		i += size
+	}
+	if i == start {
+		return 0
+	}
+	for i < len(text) && (text[i] == '\r' || text[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// spaces matches \s*[\r\n]|\s+(?!\S)|\s+. Everything else has been tried,
+// so text starts with whitespace.
+func spaces(text string) int {
+	end, lastNewline, lastStart := 0, -1, 0
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		if r == '\r' || r == '\n' {
+			lastNewline = end + size
+		}
+		lastStart = end
+		end += size
+	}
+	switch {
+	case end == 0:
+		// not whitespace after all: a lone character no alternative
+		// matches, such as an invalid byte
+		_, size := utf8.DecodeRuneInString(text)
+		return size
+	case lastNewline > 0:
+		return lastNewline
+	case end < len(text) && lastStart > 0:
+		// leave the last space to the word that follows
+		return lastStart
+	}
+	return end
+}
+
+func lower(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
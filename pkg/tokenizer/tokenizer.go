@@ -0,0 +1,133 @@
+// Package tokenizer counts tokens with byte pair encodings, offline.
+// Vocabularies are tiktoken files: those embedded from the data directory
+// are always available, more can be loaded from a directory at startup.
+// The embedded cl100k_base vocabulary is OpenAI's, as published with
+// tiktoken, so its counts are those of the models that use it.
+package tokenizer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Default is the tokenizer used when a request doesn't choose one.
+const Default = "cl100k_base"
+
+const extension = ".tiktoken"
+
+//go:embed data/*.tiktoken
+var data embed.FS
+
+// Tokenizer splits text into tokens.
+type Tokenizer interface {
+	Name() string
+	Encode(text string) []int
+	// Count is len(Encode(text)), without building the slice.
+	Count(text string) int
+}
+
+var (
+	mu      sync.Mutex
+	loaders = make(map[string]func() (Tokenizer, error))
+	loaded  = make(map[string]Tokenizer)
+)
+
+// Register makes a tokenizer available under name. It is loaded on first
+// use, so that unused vocabularies cost nothing.
+func Register(name string, load func() (Tokenizer, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	loaders[name] = load
+	delete(loaded, name)
+}
+
+// Get returns the tokenizer called name, or the default one when name is
+// empty.
+func Get(name string) (Tokenizer, error) {
+	if name == "" {
+		name = Default
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if t, ok := loaded[name]; ok {
+		return t, nil
+	}
+	load, ok := loaders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tokenizer %q", name)
+	}
+	t, err := load()
+	if err != nil {
+		return nil, fmt.Errorf("tokenizer %s: %w", name, err)
+	}
+	loaded[name] = t
+	return t, nil
+}
+
+// Names returns the sorted names of the registered tokenizers.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(loaders))
+	for name := range loaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadDir registers the tiktoken files of dir (cl100k_base.tiktoken...)
+// under their file name without the extension, and returns their names.
+// The files are checked right away but kept in memory only once used.
+func LoadDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != extension {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		name := strings.TrimSuffix(entry.Name(), extension)
+		load := func() (Tokenizer, error) {
+			f, err := os.Open(file)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			return ParseBPE(name, f)
+		}
+		if _, err := load(); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		Register(name, load)
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func init() {
+	entries, _ := data.ReadDir("data")
+	for _, entry := range entries {
+		file := path.Join("data", entry.Name())
+		name := strings.TrimSuffix(entry.Name(), extension)
+		Register(name, func() (Tokenizer, error) {
+			vocabulary, err := data.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			return ParseBPE(name, bytes.NewReader(vocabulary))
+		})
+	}
+}
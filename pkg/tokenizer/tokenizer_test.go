@@ -0,0 +1,101 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, world!", []string{"Hello", ",", " world", "!"}},
+		{"they'll DON'T", []string{"they", "'ll", " DON", "'T"}},
+		{"12345 apples", []string{"123", "45", " apples"}},
+		{"a  b\n\n  c", []string{"a", " ", " b", "\n\n", " ", " c"}},
+		{"x = f(y);\n", []string{"x", " =", " f", "(y", ");\n"}},
+		{"end   ", []string{"end", "   "}},
+		{"日本語 テキスト", []string{"日本語", " テキスト"}},
+	}
+	for _, tt := range tests {
+		if got := Split(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Split(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultTokenizer(t *testing.T) {
+	tokens, err := Get("")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if tokens.Name() != Default {
+		t.Errorf("Expected the %s tokenizer, got %s", Default, tokens.Name())
+	}
+
+	text := "# Installation\n\nRun `go build ./...` and start the server. 日本語も数えます。"
+	encoded := tokens.Encode(text)
+	if len(encoded) != tokens.Count(text) {
+		t.Errorf("Count() = %d, want len(Encode()) = %d", tokens.Count(text), len(encoded))
+	}
+	// the ranks tiktoken gives with cl100k_base
+	for text, want := range map[string][]int{
+		"hello world":        {15339, 1917},
+		"tiktoken is great!": {83, 1609, 5963, 374, 2294, 0},
+		"The quick brown fox jumps over the lazy dog.": {791, 4062, 14198, 39935, 35308, 927, 279, 16053, 5679, 13},
+	} {
+		if got := tokens.Encode(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("Encode(%q) = %v, want %v", text, got, want)
+		}
+	}
+	if len(encoded) >= len(text) {
+		t.Errorf("Expected fewer tokens than bytes, got %d for %d bytes", len(encoded), len(text))
+	}
+
+	// a word of 200 KB is merged in parts, in linear time
+	word := strings.Repeat("thequickbrownfox", 12800)
+	if n := tokens.Count(word); n == 0 || n != len(tokens.Encode(word)) {
+		t.Errorf("Count() = %d for a long word, want len(Encode())", n)
+	}
+
+	if _, err := Get("unknown"); err == nil {
+		t.Error("Expected an error for an unknown tokenizer")
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	var vocabulary strings.Builder
+	for b := 0; b < 256; b++ {
+		fmt.Fprintf(&vocabulary, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(b)}), b)
+	}
+	fmt.Fprintf(&vocabulary, "%s 256\n", base64.StdEncoding.EncodeToString([]byte("ab")))
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tiny.tiktoken"), []byte(vocabulary.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	names, err := LoadDir(dir)
+	if err != nil || !reflect.DeepEqual(names, []string{"tiny"}) {
+		t.Fatalf("LoadDir() = %v, %v", names, err)
+	}
+	tokens, err := Get("tiny")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got := tokens.Encode("abc"); !reflect.DeepEqual(got, []int{256, 'c'}) {
+		t.Errorf("Encode(\"abc\") = %v", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "broken.tiktoken"), []byte("YWI= 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDir(dir); err == nil || !strings.Contains(err.Error(), "broken.tiktoken") {
+		t.Errorf("Expected an error naming the incomplete vocabulary, got %v", err)
+	}
+}